@@ -15,17 +15,27 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
 	"github.com/unpackdev/solgo/ast"
-	"github.com/unpackdev/solgo/printer/ast_printer"
 	"go.uber.org/zap"
 )
 
 type OptimizationConfig struct {
-	StructPacking          bool `json:"structPacking"`
-	StorageVariableCaching bool `json:"storageVariableCaching"`
-	CallData               bool `json:"callData"`
-
-	// Add more optimization flags here
+	// Level selects the passes of -O0 to -O3, Passes adds to them, see GET /passes
+	Level              int      `json:"level"`
+	Passes             []string `json:"passes"`
+	MaxIterations      int      `json:"maxIterations"`
+	MaxExponent        int      `json:"maxExponent"`
+	AggressiveCallData bool     `json:"aggressiveCallData"`
+
+	// the gas model deciding which storage variables are cached, a field left at 0 keeps its default
+	LoopIterations    int     `json:"loopIterations"`
+	ColdSload         int     `json:"coldSload"`
+	WarmSload         int     `json:"warmSload"`
+	Sstore            int     `json:"sstore"`
+	BranchProbability float64 `json:"branchProbability"`
+
+	// Add more pass options here
 }
 
 func main() {
@@ -36,6 +46,7 @@ func main() {
 	r.Use(cors.Default())
 
 	r.GET("/health", healthHandler)
+	r.GET("/passes", passesHandler)
 	r.POST("/optimize", optimizeHandler)
 	r.POST("/estimate", estimateHandler)
 
@@ -46,6 +57,19 @@ func healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+func passesHandler(c *gin.Context) {
+	type passInfo struct {
+		Name               string `json:"name"`
+		Description        string `json:"description"`
+		MinSolidityVersion string `json:"minSolidityVersion,omitempty"`
+	}
+	passes := make([]passInfo, 0)
+	for _, pass := range optimizer.Passes() {
+		passes = append(passes, passInfo{Name: pass.Name(), Description: pass.Description(), MinSolidityVersion: pass.MinSolidityVersion()})
+	}
+	c.JSON(http.StatusOK, gin.H{"passes": passes, "levels": optimizer.Levels})
+}
+
 func optimizeHandler(c *gin.Context) {
 	zap.L().Info("Optimize handler")
 	ctx, cancel := context.WithCancel(context.Background())
@@ -94,21 +118,21 @@ func optimizeHandler(c *gin.Context) {
 		return
 	}
 
-	rootNode := ast.GetRoot()
-
 	opt := optimizer.NewOptimizer(builder)
-	originalCode, ok := ast_printer.Print(rootNode.GetSourceUnits()[0])
-
-	contractName, err := printer.GetContractName(originalCode)
-	if err != nil {
-		zap.L().Error("Failed to get contract name", zap.Error(err))
+	// the code is rewritten in place, so it keeps its comments and formatting
+	contractName := ""
+	for _, contract := range builder.GetRoot().GetContracts() {
+		if contract.GetKind() == ast_pb.NodeType_KIND_CONTRACT {
+			contractName = contract.GetName()
+			break
+		}
+	}
+	if contractName == "" {
+		zap.L().Error("Failed to get contract name")
 	}
 
 	// Rename the contract to Unoptimized
-	unoptimized := renameContract(originalCode, contractName, "Unoptimized")
-	if !ok {
-		zap.L().Error("Error while printing Original AST")
-	}
+	unoptimized := renameContract(input.ContractCode, contractName, "Unoptimized")
 
 	// write unoptimized code to file system
 	if err := ioutil.WriteFile("../estimator/src/unoptimized.sol", []byte(unoptimized), 0644); err != nil {
@@ -116,13 +140,22 @@ func optimizeHandler(c *gin.Context) {
 	}
 
 	// Optimize the contract
-	optimizeContract(opt, input.Options)
+	if err := optimizeContract(opt, input.Options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		zap.L().Error("Failed to optimize contract", zap.Error(err))
+		return
+	}
 
-	// Print optimised AST
-	optimizedCode, ok := ast_printer.Print(rootNode.GetSourceUnits()[0])
-	if !ok {
-		// error
-		zap.L().Error("Error while printing Optimised AST")
+	// Apply the edits of the passes to the original code
+	optimizedCode := input.ContractCode
+	for _, file := range opt.Edits() {
+		code, err := file.Apply()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			zap.L().Error("Failed to apply the edits of the optimizer", zap.Error(err))
+			return
+		}
+		optimizedCode = code
 	}
 
 	// Rename contract to Optimized
@@ -132,7 +165,8 @@ func optimizeHandler(c *gin.Context) {
 	if err := ioutil.WriteFile("../estimator/src/optimized.sol", []byte(optimized), 0644); err != nil {
 		zap.L().Error("Failed to write optimized code to file system", zap.Error(err))
 	}
-	c.JSON(http.StatusOK, gin.H{"optimizedCode": optimized, "unoptimizedCode": unoptimized})
+	result := opt.Result()
+	c.JSON(http.StatusOK, gin.H{"optimizedCode": optimized, "unoptimizedCode": unoptimized, "changes": result.Changes, "gasDelta": result.GasDelta()})
 }
 
 func estimateHandler(c *gin.Context) {
@@ -173,16 +207,36 @@ func resolveReferences(ast *ast.ASTBuilder) error {
 	return nil
 }
 
-func optimizeContract(opt *optimizer.Optimizer, config OptimizationConfig) {
-	if config.StructPacking {
-		opt.PackStructs()
+func optimizeContract(opt *optimizer.Optimizer, config OptimizationConfig) error {
+	passes, err := optimizer.PipelinePasses(config.Level, config.Passes)
+	if err != nil {
+		return err
+	}
+	options := optimizer.LevelOptions(config.Level)
+	options.AggressiveCallData = options.AggressiveCallData || config.AggressiveCallData
+	if config.MaxExponent > 0 {
+		options.MaxExponent = config.MaxExponent
+	}
+	if config.LoopIterations > 0 {
+		options.GasModel.LoopIterations = config.LoopIterations
+	}
+	if config.ColdSload > 0 {
+		options.GasModel.ColdSload = config.ColdSload
+	}
+	if config.WarmSload > 0 {
+		options.GasModel.WarmSload = config.WarmSload
+	}
+	if config.Sstore > 0 {
+		options.GasModel.Sstore = config.Sstore
 	}
-	if config.StorageVariableCaching {
-		opt.CacheStorageVariables()
+	if config.BranchProbability > 0 {
+		options.GasModel.BranchProbability = config.BranchProbability
 	}
-	if config.CallData {
-		opt.OptimizeCallData()
+	if err := options.GasModel.Validate(); err != nil {
+		return err
 	}
+	_, err = opt.RunPipeline(passes, options, config.MaxIterations)
+	return err
 }
 
 func tryTestFile(test string) {
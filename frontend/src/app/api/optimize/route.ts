@@ -29,7 +29,10 @@ async function optimizeCode(contractCode: string, opts: OptimizationConfig) {
 }
 
 type OptimizationConfig = {
-  structPacking: boolean;
-  storageVariableCaching: boolean;
-  callData: boolean;
+  level?: number;
+  passes: string[];
+  maxIterations?: number;
+  maxExponent?: number;
+  loopIterations?: number;
+  aggressiveCallData?: boolean;
 };
@@ -0,0 +1,23 @@
+import { NextResponse } from "next/server";
+
+export async function GET() {
+  // Ask the backend which optimization passes it has
+  const data = await listPasses();
+
+  return NextResponse.json({ data });
+}
+
+// Helper function to call your backend API
+async function listPasses() {
+  const response = await fetch("http://localhost:8080/passes", {
+    cache: "no-store",
+  });
+
+  if (response.ok) {
+    const data = await response.json();
+    return data;
+  } else {
+    const error = await response.text();
+    throw new Error("Listing passes failed due to: " + error);
+  }
+}
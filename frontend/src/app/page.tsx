@@ -10,21 +10,19 @@ import { DiffEditor, Editor } from "@monaco-editor/react";
 import EthIcon from "./ethereum-eth-logo.svg";
 import stripAnsi from "strip-ansi";
 
-type OptimizationOptions = {
-  structPacking: boolean;
-  storageVariableCaching: boolean;
-  callData: boolean;
+// An optimization pass as listed by the backend
+type Pass = {
+  name: string;
+  description: string;
+  minSolidityVersion?: string;
 };
 
-// Option name
-const optimizationOptionsNames: { [K in keyof OptimizationOptions]: string } = {
-  structPacking: "Pack Structs",
-  storageVariableCaching: "Cache Storage Variables",
-  callData: "Optimise Call Data",
-};
-
-function getOptionName<K extends keyof OptimizationOptions>(option: K): string {
-  return optimizationOptionsNames[option];
+// Option name, eliminate-dead-code becomes Eliminate Dead Code
+function getOptionName(pass: Pass): string {
+  return pass.name
+    .split("-")
+    .map((word) => word.charAt(0).toUpperCase() + word.slice(1))
+    .join(" ");
 }
 
 export default function Home() {
@@ -46,12 +44,17 @@ function test() public view {
   const [estimationVisible, setEstimationVisible] = useState(false);
   const [error, setError] = useState("");
   const [isErrorVisible, setIsErrorVisible] = useState(false);
-  const [optimizationOptions, setOptimizationOptions] =
-    useState<OptimizationOptions>({
-      structPacking: false,
-      storageVariableCaching: false,
-      callData: false,
-    });
+  const [passes, setPasses] = useState<Pass[]>([]);
+  const [optimizationOptions, setOptimizationOptions] = useState<{
+    [name: string]: boolean;
+  }>({});
+
+  useEffect(() => {
+    fetch("/api/passes")
+      .then((response) => response.json())
+      .then((data) => setPasses(data.data.passes))
+      .catch(() => setError("Failed to load the optimization passes."));
+  }, []);
 
   useEffect(() => {
     if (error) {
@@ -75,7 +78,11 @@ function test() public view {
         body: JSON.stringify({
           contractCode: inputCode,
           testCode: testCode,
-          opts: optimizationOptions,
+          opts: {
+            passes: passes
+              .filter((pass) => optimizationOptions[pass.name])
+              .map((pass) => pass.name),
+          },
         }),
       });
 
@@ -127,9 +134,7 @@ function test() public view {
     }
   };
 
-  const handleOptimizationOptionChange = (
-    option: keyof OptimizationOptions,
-  ) => {
+  const handleOptimizationOptionChange = (option: string) => {
     setOptimizationOptions((prevOptions) => ({
       ...prevOptions,
       [option]: !prevOptions[option],
@@ -179,20 +184,17 @@ function test() public view {
               <label className="block font-bold py-2 border-b border-gray-700">
                 Optimizations
               </label>
-              {Object.entries(optimizationOptions).map(([option, enabled]) => (
+              {passes.map((pass) => (
                 <motion.div
-                  key={option}
+                  key={pass.name}
                   variants={itemVariants}
-                  className={`flex items-center space-x-2 ${enabled ? "text-green-400" : "text-white"} cursor-pointer transition duration-300 hover:text-green-500`}
-                  onClick={() =>
-                    handleOptimizationOptionChange(
-                      option as keyof OptimizationOptions,
-                    )
-                  }
+                  title={pass.description}
+                  className={`flex items-center space-x-2 ${optimizationOptions[pass.name] ? "text-green-400" : "text-white"} cursor-pointer transition duration-300 hover:text-green-500`}
+                  onClick={() => handleOptimizationOptionChange(pass.name)}
                 >
                   <span>
-                    {enabled ? "✅ " : "⚡️ "}
-                    {getOptionName(option as keyof OptimizationOptions)}
+                    {optimizationOptions[pass.name] ? "✅ " : "⚡️ "}
+                    {getOptionName(pass)}
                   </span>
                 </motion.div>
               ))}
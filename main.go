@@ -4,14 +4,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"optimizer/optimizer/logger"
 	"optimizer/optimizer/optimizer"
+	"optimizer/optimizer/optimizer/diff"
 	"optimizer/optimizer/printer"
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/unpackdev/solgo/ast"
-	"github.com/unpackdev/solgo/printer/ast_printer"
 	"go.uber.org/zap"
 )
 
@@ -32,12 +33,22 @@ func optimize() {
 
 	config := GetConfig()
 
-	// join the current working directory with the file path
-	filepath := filepath.Join(cwd, config.filepath)
+	project, err := printer.NewProject(config.root, config.paths...)
+	if err != nil {
+		zap.L().Fatal("Failed to collect the source files", zap.Error(err))
+	}
+	if err := project.AddIncludePaths(config.includePaths...); err != nil {
+		zap.L().Fatal("Failed to find the include paths", zap.Error(err))
+	}
+	if config.remappings != "" {
+		if err := project.LoadRemappings(config.remappings); err != nil {
+			zap.L().Fatal("Failed to read the remappings", zap.Error(err))
+		}
+	}
 
-	builder, err := printer.GetBuilder(ctx, filepath)
+	builder, err := printer.GetProjectBuilder(ctx, project)
 	if err != nil {
-		zap.L().Error("Failed to get builder", zap.Error(err))
+		zap.L().Fatal("Failed to get builder", zap.Error(err))
 	}
 
 	zap.L().Info("Parsing and building contract")
@@ -54,76 +65,295 @@ func optimize() {
 		zap.L().Error("Failed to resolve references", zap.Errors("resolve errors", errs))
 	}
 
-	if config.printOutput {
-		fmt.Println("UNOPTIMIZED====================")
-		printRoot(ast.GetRoot())
-		fmt.Println("================================")
-	}
 	opt := optimizer.NewOptimizer(builder)
-	if config.packStructs {
-		opt.PackStructs()
+	// the libraries are analyzed with the project but left as they are
+	for _, unit := range builder.GetSources().SourceUnits {
+		if project.IsLibrary(unit.Path) {
+			zap.L().Info("Not rewriting library file", zap.String("path", unit.Path))
+			opt.ReadOnly(unit.Path)
+		}
 	}
-	if config.optimizeCallData {
-		opt.OptimizeCallData()
+	passes, err := optimizer.PipelinePasses(config.level, config.passes)
+	if err != nil {
+		zap.L().Fatal("Failed to select optimization passes", zap.Error(err))
 	}
-	if config.cacheStorageVariables {
-		opt.CacheStorageVariables()
+	options := optimizer.LevelOptions(config.level)
+	options.AggressiveCallData = options.AggressiveCallData || config.aggressiveCallData
+	options.MaxExponent = config.maxExponent
+	options.GasModel = config.gasModel
+	if _, err := opt.RunPipeline(passes, options, config.maxIterations); err != nil {
+		zap.L().Fatal("Failed to optimize contract", zap.Error(err))
 	}
+	printResult(config.report, cwd, opt.Result())
 
+	files := opt.Edits()
 	if config.printOutput {
-		fmt.Println("OPTIMIZED======================")
-		printRoot(ast.GetRoot())
+		printSources(files)
+	}
+	if config.diff {
+		printDiff(cwd, files)
+	}
+	if config.write || config.output != "" {
+		if err := writeSources(files, config); err != nil {
+			zap.L().Fatal("Failed to write the optimized sources", zap.Error(err))
+		}
+	}
+}
+
+// printDiff prints the edits of the passes as a unified diff, with the paths relative to the working directory
+// so that `git apply` and `patch -p1` run from there take it
+func printDiff(cwd string, files []optimizer.SourceEdits) {
+	for _, file := range files {
+		optimized, err := file.Apply()
+		if err != nil {
+			zap.L().Error("Failed to apply the edits of the optimizer", zap.String("path", file.Path), zap.Error(err))
+			continue
+		}
+		fmt.Print(diff.Unified(relativePath(cwd, file.Path), file.Original, optimized))
+	}
+}
+
+// relativePath returns the path relative to the working directory, with forward slashes, if it is below it
+func relativePath(cwd, path string) string {
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// writeSources writes the optimized sources over the original files, or to the -o path,
+// keeping a copy of every original file rewritten in place if -backup is set
+func writeSources(files []optimizer.SourceEdits, config Config) error {
+	if config.output != "" && len(files) != 1 {
+		return fmt.Errorf("-o takes a single source file, there are %d", len(files))
+	}
+	for _, file := range files {
+		optimized, err := file.Apply()
+		if err != nil {
+			return err
+		}
+		path := file.Path
+		if config.output != "" {
+			path = config.output
+		} else if len(file.Edits) == 0 {
+			continue
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(file.Path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if config.output == "" && config.backup != "" {
+			if err := os.WriteFile(path+config.backup, []byte(file.Original), mode); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(path, []byte(optimized), mode); err != nil {
+			return err
+		}
+		zap.L().Info("Wrote optimized source", zap.String("path", path), zap.Int("edits", len(file.Edits)))
+	}
+	return nil
+}
+
+// printSources prints every source file before and after the edits of the passes
+func printSources(files []optimizer.SourceEdits) {
+	for _, file := range files {
+		optimized, err := file.Apply()
+		if err != nil {
+			zap.L().Error("Failed to apply the edits of the optimizer", zap.String("path", file.Path), zap.Error(err))
+			continue
+		}
+		fmt.Printf("UNOPTIMIZED %s====================\n", file.Path)
+		fmt.Println(file.Original)
+		fmt.Printf("OPTIMIZED %s (%d edits)======================\n", file.Path, len(file.Edits))
+		fmt.Println(optimized)
 		fmt.Println("================================")
 	}
 }
 
-func printRoot(root *ast.RootNode) {
-	str, ok := ast_printer.Print(root.GetSourceUnits()[0])
-	if !ok {
-		zap.L().Error("Failed to print root")
+// printResult lists the changes made by the passes with their location, rationale and estimated gas delta
+func printResult(w io.Writer, cwd string, result optimizer.Result) {
+	fmt.Fprintf(w, "CHANGES (%d, estimated gas delta %d)===========\n", len(result.Changes), result.GasDelta())
+	for _, change := range result.Changes {
+		location := change.Contract
+		if change.Function != "" {
+			location += "." + change.Function
+		}
+		if change.File != "" {
+			location = relativePath(cwd, change.File) + " " + location
+		}
+		fmt.Fprintf(w, "[%s] %s, line %d: %s (gas %+d)\n", change.Pass, location, change.Src.Line, change.Rationale, change.GasDelta)
+		printSnippet(w, "- ", change.Before)
+		printSnippet(w, "+ ", change.After)
+	}
+	fmt.Fprintln(w, "================================")
+}
+
+// printSnippet prints every line of the code with the prefix
+func printSnippet(w io.Writer, prefix string, code string) {
+	if code == "" {
+		return
+	}
+	for _, line := range strings.Split(code, "\n") {
+		fmt.Fprintln(w, "    "+prefix+line)
 	}
-	fmt.Println(str)
 }
 
 type Config struct {
-	filepath              string
-	packStructs           bool
-	optimizeCallData      bool
-	cacheStorageVariables bool
-	printOutput           bool
+	// paths are the files and directories to optimize
+	paths              []string
+	root               string
+	includePaths       []string
+	remappings         string
+	level              int
+	passes             []string
+	maxIterations      int
+	aggressiveCallData bool
+	maxExponent        int
+	gasModel           optimizer.GasModel
+	printOutput        bool
+	diff               bool
+	write              bool
+	backup             string
+	output             string
+	// report is where the configuration and the changes are printed, stderr when stdout takes the diff
+	report io.Writer
 }
 
 func GetConfig() Config {
 	// use the flag library to parse the command line arguments
 	var (
-		filepath              string
-		packStructs           bool
-		optimizeCallData      bool
-		cacheStorageVariables bool
-		printOutput           bool
+		filepath           string
+		root               string
+		includePaths       string
+		remappings         string
+		maxIterations      int
+		aggressiveCallData bool
+		maxExponent        int
+		gasModel           = optimizer.DefaultGasModel
+		printOutput        bool
+		printDiff          bool
+		write              bool
+		backup             string
+		output             string
+		listPasses         bool
 	)
-	flag.StringVar(&filepath, "file", "", "The path to the file to optimize")
-	flag.BoolVar(&packStructs, "pack-structs", false, "Pack structs")
-	flag.BoolVar(&optimizeCallData, "optimize-call-data", false, "Optimize call data")
-	flag.BoolVar(&cacheStorageVariables, "cache-storage-variables", false, "Cache storage variables")
+	flag.StringVar(&filepath, "file", "", "The path to the file or directory to optimize, more can follow the flags")
+	flag.StringVar(&root, "root", "", "The project root, only the files inside it are rewritten. By default the closest directory above the file holding a remappings.txt, foundry.toml or Hardhat config")
+	flag.StringVar(&includePaths, "include", "", "Comma separated directories to look up imports in, after the root and before its lib and node_modules")
+	flag.StringVar(&remappings, "remappings", "", "A file of import remappings, one prefix=target per line, used on top of the remappings.txt of the root")
+	// -O0 to -O3 select a preset list of passes, the pass flags add to it
+	levels := make([]*bool, len(optimizer.Levels))
+	for level, names := range optimizer.Levels {
+		levels[level] = flag.Bool(fmt.Sprintf("O%d", level), false, fmt.Sprintf("Optimization level %d, runs %d passes", level, len(names)))
+	}
+	flag.IntVar(&maxIterations, "max-iterations", optimizer.DefaultMaxIterations, "Largest number of times the passes are run while they still change the code")
+	// every registered pass gets a flag of its own name
+	enabled := make(map[string]*bool, 0)
+	for _, pass := range optimizer.Passes() {
+		enabled[pass.Name()] = flag.Bool(pass.Name(), false, pass.Description())
+	}
+	flag.BoolVar(&aggressiveCallData, "aggressive-call-data", false, "Split public functions called internally with memory into an external calldata entry point and an internal implementation")
+	flag.IntVar(&maxExponent, "max-exponent", optimizer.DefaultMaxExponent, "Largest exponent to expand into multiplications")
+	// the gas model only decides which storage variables are cached, the other passes use the default one
+	flag.IntVar(&gasModel.LoopIterations, "loop-iterations", gasModel.LoopIterations, "Assumed number of loop iterations when estimating the gas saved by caching storage variables")
+	flag.IntVar(&gasModel.ColdSload, "cold-sload", gasModel.ColdSload, "Gas of the first read of a storage slot when estimating the gas saved by caching storage variables")
+	flag.IntVar(&gasModel.WarmSload, "warm-sload", gasModel.WarmSload, "Gas of the later reads of a storage slot when estimating the gas saved by caching storage variables")
+	flag.IntVar(&gasModel.Sstore, "sstore", gasModel.Sstore, "Gas of writing a storage slot already written when estimating the gas saved by caching storage variables")
+	flag.Float64Var(&gasModel.BranchProbability, "branch-probability", gasModel.BranchProbability, "Assumed chance that a branch is taken when estimating the gas saved by caching storage variables")
 	flag.BoolVar(&printOutput, "print-output", false, "Print the output")
+	flag.BoolVar(&printDiff, "diff", false, "Print the changes to the source files as a unified diff that git apply accepts")
+	flag.BoolVar(&write, "write", false, "Rewrite the source files in place")
+	flag.StringVar(&backup, "backup", "", "With -write, keep a copy of every rewritten file with this suffix, such as .orig")
+	flag.StringVar(&output, "o", "", "Write the optimized source to this path instead of rewriting the file")
+	flag.BoolVar(&listPasses, "list-passes", false, "List the optimization passes and exit")
 	flag.Parse()
 
-	fmt.Println("Starting with the following configuration:")
-	fmt.Println("  filepath:", filepath)
-	fmt.Println("  pack-structs:", packStructs)
-	fmt.Println("  optimize-call-data:", optimizeCallData)
-	fmt.Println("  cache-storage-variables:", cacheStorageVariables)
-	fmt.Println("  print-output:", printOutput)
+	if listPasses {
+		for _, pass := range optimizer.Passes() {
+			version := ""
+			if pass.MinSolidityVersion() != "" {
+				version = fmt.Sprintf(" (solidity >= %s)", pass.MinSolidityVersion())
+			}
+			fmt.Printf("  %-28s %s%s\n", pass.Name(), pass.Description(), version)
+		}
+		os.Exit(0)
+	}
+
+	level := 0
+	for l, set := range levels {
+		if *set {
+			level = l
+		}
+	}
+
+	var report io.Writer = os.Stdout
+	if printDiff {
+		report = os.Stderr
+	}
+	fmt.Fprintln(report, "Starting with the following configuration:")
+	paths := flag.Args()
+	if filepath != "" {
+		paths = append([]string{filepath}, paths...)
+	}
+	fmt.Fprintln(report, "  files:", strings.Join(paths, ", "))
+	fmt.Fprintln(report, "  root:", root)
+	fmt.Fprintf(report, "  optimization level: O%d\n", level)
+	fmt.Fprintln(report, "  max-iterations:", maxIterations)
+	passes := make([]string, 0)
+	for _, pass := range optimizer.Passes() {
+		fmt.Fprintf(report, "  %s: %t\n", pass.Name(), *enabled[pass.Name()])
+		if *enabled[pass.Name()] {
+			passes = append(passes, pass.Name())
+		}
+	}
+	fmt.Fprintln(report, "  aggressive-call-data:", aggressiveCallData)
+	fmt.Fprintln(report, "  max-exponent:", maxExponent)
+	fmt.Fprintln(report, "  loop-iterations:", gasModel.LoopIterations)
+	fmt.Fprintln(report, "  cold-sload:", gasModel.ColdSload)
+	fmt.Fprintln(report, "  warm-sload:", gasModel.WarmSload)
+	fmt.Fprintln(report, "  sstore:", gasModel.Sstore)
+	fmt.Fprintln(report, "  branch-probability:", gasModel.BranchProbability)
+	fmt.Fprintln(report, "  print-output:", printOutput)
+	fmt.Fprintln(report, "  diff:", printDiff)
+	fmt.Fprintln(report, "  write:", write)
 
-	if filepath == "" {
+	if len(paths) == 0 {
 		zap.L().Fatal("File path is required")
 	}
+	if write && output != "" {
+		zap.L().Fatal("-write and -o can not be used together")
+	}
+	if err := gasModel.Validate(); err != nil {
+		zap.L().Fatal("Invalid gas model", zap.Error(err))
+	}
 	return Config{
-		filepath:              filepath,
-		packStructs:           packStructs,
-		optimizeCallData:      optimizeCallData,
-		cacheStorageVariables: cacheStorageVariables,
-		printOutput:           printOutput,
+		paths:              paths,
+		root:               root,
+		includePaths:       splitList(includePaths),
+		remappings:         remappings,
+		level:              level,
+		passes:             passes,
+		maxIterations:      maxIterations,
+		aggressiveCallData: aggressiveCallData,
+		maxExponent:        maxExponent,
+		gasModel:           gasModel,
+		printOutput:        printOutput,
+		diff:               printDiff,
+		write:              write,
+		backup:             backup,
+		output:             output,
+		report:             report,
+	}
+}
+
+// splitList splits a comma separated flag, dropping empty items
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,374 @@
+// Reads the length of an array once before a loop instead of in every evaluation of the loop condition
+package optimizer
+
+import (
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"go.uber.org/zap"
+)
+
+func (o *Optimizer) optimizeArrayLengthCaching() []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	contracts := o.builder.GetRoot().GetContracts()
+	graph := newCallGraph(contracts)
+	modifiers := make(map[string][]*callable, 0)
+	for _, c := range graph.callables {
+		if c.node.GetType() == ast_pb.NodeType_MODIFIER_DEFINITION {
+			modifiers[c.name] = append(modifiers[c.name], c)
+		}
+	}
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		visible := make(map[string]*ast.StateVariableDeclaration, 0)
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			visible = visibleStateVariables(tree, astContract)
+		}
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil || hasUnsupportedStatement(fn.GetBody()) {
+				continue
+			}
+			stateVariables, references := collectStateVariableReferences(fn, visible)
+			c := &lengthCacher{
+				contract:       contract.GetName(),
+				fn:             fn,
+				body:           fn.GetBody(),
+				graph:          graph,
+				modifiers:      modifiers,
+				visible:        visible,
+				stateVariables: make(map[*ast.PrimaryExpression]*ast.StateVariableDeclaration, 0),
+				names:          localNames(fn),
+				locals:         arrayLocations(fn),
+			}
+			for id, refs := range references {
+				for _, ref := range refs {
+					c.stateVariables[ref] = stateVariables[id]
+				}
+			}
+			c.cacheLengths(fn.GetBody())
+			changes = append(changes, c.changes...)
+		}
+	}
+	return changes
+}
+
+// lengthCacher holds the state of a function while the array lengths read by its loops are cached
+type lengthCacher struct {
+	contract  string
+	fn        *ast.Function
+	body      *ast.BodyNode
+	graph     *callGraph
+	modifiers map[string][]*callable
+	visible   map[string]*ast.StateVariableDeclaration
+	// state variables by the identifiers referring to them
+	stateVariables map[*ast.PrimaryExpression]*ast.StateVariableDeclaration
+	names          map[string]bool
+	// storage location of the parameters and local variables, by name
+	locals map[string]ast_pb.StorageLocation
+	// changes holds a change for every loop whose lengths were cached
+	changes []Change
+}
+
+// cacheLengths looks for loops in the block, outermost first, and caches the lengths their conditions read
+func (c *lengthCacher) cacheLengths(block *ast.BodyNode) {
+	// statements can not be inserted in an if body without braces, the else branch is appended to it
+	if block.GetSrc().End == 0 && block != c.body {
+		return
+	}
+	statements := make([]ast.Node[ast.NodeType], 0, len(block.GetStatements()))
+	for _, stmt := range block.GetStatements() {
+		if isLoop(stmt) {
+			declarations, gasDelta := c.cacheLoop(stmt)
+			if len(declarations) > 0 {
+				change := newChange(c.contract, c.fn.GetName(), stmt.GetSrc(), nil,
+					"the length does not change while the loop runs, so it is read once before the loop", gasDelta)
+				change.after = append(append([]ast.Node[ast.NodeType]{}, declarations...), stmt)
+				c.changes = append(c.changes, change)
+			}
+			statements = append(statements, declarations...)
+		}
+		statements = append(statements, stmt)
+
+		switch stmt := stmt.(type) {
+		case *ast.IfStatement:
+			if body, ok := stmt.GetBody().(*ast.BodyNode); ok {
+				c.cacheLengths(body)
+			}
+		case *ast.BodyNode:
+			c.cacheLengths(stmt)
+		case *ast.ForStatement, *ast.WhileStatement, *ast.DoWhileStatement:
+			c.cacheLengths(loopBody(stmt))
+		}
+	}
+	block.Statements = statements
+}
+
+// cacheLoop returns the declarations of the lengths read by the condition of the loop that do not change
+// while it runs, and points the reads of those lengths inside the loop at them.
+// Also returns the gas saved by reading the cached lengths in every evaluation of the condition.
+func (c *lengthCacher) cacheLoop(loop ast.Node[ast.NodeType]) ([]ast.Node[ast.NodeType], int) {
+	var condition ast.Node[ast.NodeType]
+	switch loop := loop.(type) {
+	case *ast.ForStatement:
+		condition = loop.Condition
+	case *ast.WhileStatement:
+		condition = loop.GetCondition()
+	case *ast.DoWhileStatement:
+		condition = loop.GetCondition()
+	}
+	declaredInLoop := make(map[string]bool, 0)
+	walk(loop, func(node ast.Node[ast.NodeType]) bool {
+		if d, ok := node.(*ast.Declaration); ok {
+			declaredInLoop[d.GetName()] = true
+		}
+		return true
+	})
+
+	declarations := make([]ast.Node[ast.NodeType], 0)
+	gasDelta := 0
+	cached := make(map[string]bool, 0)
+	walk(condition, func(node ast.Node[ast.NodeType]) bool {
+		member, ok := node.(*ast.MemberAccessExpression)
+		if !ok || member.GetMemberName() != "length" {
+			return true
+		}
+		array, ok := member.GetExpression().(*ast.PrimaryExpression)
+		if !ok || cached[array.GetName()] || declaredInLoop[array.GetName()] {
+			return true
+		}
+		inStorage, ok := c.arrayLocation(array)
+		if !ok {
+			return true
+		}
+		// the length of a state variable may also change through a storage pointer, a call or a reentrant call
+		name := array.GetName()
+		checker := &lengthChecker{lengthCacher: c, visited: make(map[*callable]bool, 0)}
+		if checker.changesLength(loop, name, inStorage, c.names) {
+			zap.L().Debug("Array length may change in the loop", zap.String("function", c.fn.GetName()), zap.String("array", name))
+			return true
+		}
+		cached[name] = true
+		declarations = append(declarations, c.cacheLength(loop, array))
+		// the length of a memory array is a memory read, about as cheap as reading the local variable
+		if inStorage {
+			gasDelta -= DefaultGasModel.LoopIterations * (DefaultGasModel.WarmSload - DefaultGasModel.StackRead)
+		} else {
+			gasDelta -= DefaultGasModel.LoopIterations * DefaultGasModel.StackRead
+		}
+		return true
+	})
+	return declarations, gasDelta
+}
+
+// cacheLength declares `uint256 cached_a_length = a.length` and replaces `a.length` inside the loop with it
+func (c *lengthCacher) cacheLength(loop ast.Node[ast.NodeType], array *ast.PrimaryExpression) ast.Node[ast.NodeType] {
+	typeName := &ast.TypeName{NodeType: ast_pb.NodeType_ELEMENTARY_TYPE_NAME, Name: "uint256"}
+	declaration := &ast.Declaration{
+		Id:       nextID(c.body),
+		Name:     uniqueName(c.names, cachedElementName(array.GetName()+".length")),
+		NodeType: ast_pb.NodeType_VARIABLE_DECLARATION,
+		TypeName: typeName,
+	}
+	lengths := make(map[ast.Node[ast.NodeType]]bool, 0)
+	walk(loop, func(node ast.Node[ast.NodeType]) bool {
+		member, ok := node.(*ast.MemberAccessExpression)
+		if ok && member.GetMemberName() == "length" {
+			ident, ok := member.GetExpression().(*ast.PrimaryExpression)
+			lengths[member] = ok && ident.GetName() == array.GetName()
+		}
+		return true
+	})
+	var initialValue ast.Node[ast.NodeType]
+	rewriteNodes(loop, func(node ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+		if !lengths[node] {
+			return node
+		}
+		member := node.(*ast.MemberAccessExpression)
+		if initialValue == nil {
+			initialValue = member
+		}
+		return &ast.PrimaryExpression{
+			Id:                    nextID(c.body),
+			NodeType:              ast_pb.NodeType_IDENTIFIER,
+			Name:                  declaration.GetName(),
+			ReferencedDeclaration: declaration.GetId(),
+			TypeName:              typeName,
+			TypeDescription:       member.GetTypeDescription(),
+		}
+	})
+	zap.L().Info("Cached array length before loop", zap.String("function", c.fn.GetName()), zap.String("array", array.GetName()), zap.String("local", declaration.GetName()))
+	return &ast.VariableDeclaration{
+		Id:           nextID(c.body),
+		Declarations: []*ast.Declaration{declaration},
+		NodeType:     ast_pb.NodeType_VARIABLE_DECLARATION,
+		InitialValue: initialValue,
+	}
+}
+
+// arrayLocation tells whether the identifier is a storage array or a memory array.
+// Returns false for anything else, such as calldata arrays whose length is as cheap as a local variable.
+func (c *lengthCacher) arrayLocation(array *ast.PrimaryExpression) (bool, bool) {
+	if sv, ok := c.stateVariables[array]; ok {
+		return true, usesStorage(sv) && isArrayTypeName(sv.GetTypeName())
+	}
+	location, ok := c.locals[array.GetName()]
+	return false, ok && location == ast_pb.StorageLocation_MEMORY
+}
+
+// isArrayTypeName reports whether the type is a dynamic array or bytes, the types whose length is not a constant
+func isArrayTypeName(typeName *ast.TypeName) bool {
+	return typeName != nil && typeName.ValueType == nil && (strings.HasSuffix(typeName.GetName(), "[]") || typeName.GetName() == "bytes")
+}
+
+// arrayLocations returns the storage location of the parameters and local variables of the function that
+// hold arrays. A name declared more than once is left out since it can not be told which one is meant.
+func arrayLocations(f *ast.Function) map[string]ast_pb.StorageLocation {
+	locations := make(map[string]ast_pb.StorageLocation, 0)
+	declared := make(map[string]int, 0)
+	add := func(name string, typeName *ast.TypeName, location ast_pb.StorageLocation) {
+		declared[name]++
+		if isArrayTypeName(typeName) && declared[name] == 1 {
+			locations[name] = location
+		} else {
+			delete(locations, name)
+		}
+	}
+	for _, list := range []*ast.ParameterList{f.GetParameters(), f.GetReturnParameters()} {
+		if list == nil {
+			continue
+		}
+		for _, p := range list.GetParameters() {
+			add(p.GetName(), p.GetTypeName(), p.GetStorageLocation())
+		}
+	}
+	walk(f.GetBody(), func(node ast.Node[ast.NodeType]) bool {
+		if d, ok := node.(*ast.Declaration); ok {
+			add(d.GetName(), d.GetTypeName(), d.GetStorageLocation())
+		}
+		return true
+	})
+	return locations
+}
+
+// lengthChecker follows a loop and the functions it calls to find what may change the length of an array
+type lengthChecker struct {
+	*lengthCacher
+	visited map[*callable]bool
+}
+
+// changesLength reports whether running the node may change the length of the array called name, which is
+// declared outside of it. locals are the names declared in the function the node belongs to.
+// The length of a memory array only changes when the variable is assigned to, or through assembly.
+// Inside called functions name is empty for memory arrays, which are out of their reach.
+func (lc *lengthChecker) changesLength(node ast.Node[ast.NodeType], name string, inStorage bool, locals map[string]bool) bool {
+	changes := false
+	isArray := func(target ast.Node[ast.NodeType]) bool {
+		ident, ok := target.(*ast.PrimaryExpression)
+		return ok && name != "" && ident.GetName() == name
+	}
+	var assigned func(target ast.Node[ast.NodeType]) bool
+	assigned = func(target ast.Node[ast.NodeType]) bool {
+		if tuple, ok := target.(*ast.TupleExpression); ok {
+			for _, component := range tuple.GetComponents() {
+				if assigned(component) {
+					return true
+				}
+			}
+			return false
+		}
+		return isArray(target)
+	}
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if changes {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.Assignment:
+			changes = n.LeftExpression != nil && assigned(n.LeftExpression)
+		case *ast.UnaryPrefix:
+			// delete is parsed as an increment
+			changes = isIncrementOrDecrement(n.GetOperator()) && assigned(n.GetExpression())
+		case *ast.FunctionCall:
+			changes = lc.callChangesLength(n, name, inStorage, locals)
+		default:
+			switch n.GetType() {
+			case ast_pb.NodeType_ASSEMBLY_STATEMENT:
+				changes = true
+			case ast_pb.NodeType_NEW_EXPRESSION:
+				// the constructor of the new contract may call back
+				changes = inStorage
+			}
+		}
+		return !changes
+	})
+	return changes
+}
+
+// callChangesLength reports whether the call may change the length of the array
+func (lc *lengthChecker) callChangesLength(call *ast.FunctionCall, name string, inStorage bool, locals map[string]bool) bool {
+	switch expression := call.GetExpression().(type) {
+	case *ast.MemberAccessExpression:
+		if expression.GetMemberName() == "push" || expression.GetMemberName() == "pop" {
+			base, ok := expression.GetExpression().(*ast.PrimaryExpression)
+			// a local storage pointer may point at the array
+			return ok && (name != "" && base.GetName() == name || inStorage && locals[base.GetName()])
+		}
+		// calls out of the contract may call back, library functions may take the array by reference
+		return inStorage && isExternalCall(call)
+	case *ast.PrimaryExpression:
+		callee := expression.GetName()
+		if !isStorageCall(call) {
+			return false
+		}
+		functions := lc.graph.functions[callee]
+		if len(functions) == 0 {
+			// a function held in a variable can not be followed, the rest are events, errors and struct constructors
+			_, isStateVariable := lc.visible[callee]
+			return locals[callee] || isStateVariable
+		}
+		for _, c := range functions {
+			if lc.callableChangesLength(c, name, inStorage) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// callableChangesLength reports whether calling the function or modifier may change the length of the array.
+// Inside it, a storage array is known by the name of its state variable unless a local variable hides it.
+func (lc *lengthChecker) callableChangesLength(c *callable, name string, inStorage bool) bool {
+	if lc.visited[c] {
+		return false
+	}
+	lc.visited[c] = true
+	locals := make(map[string]bool, 0)
+	for _, p := range append(append([]*ast.Parameter{}, c.params...), c.returns...) {
+		locals[p.GetName()] = true
+	}
+	walk(c.body, func(node ast.Node[ast.NodeType]) bool {
+		if d, ok := node.(*ast.Declaration); ok {
+			locals[d.GetName()] = true
+		}
+		return true
+	})
+	calleeName := ""
+	if inStorage && !locals[name] {
+		calleeName = name
+	}
+	if fn, ok := c.node.(*ast.Function); ok {
+		for _, invocation := range fn.GetModifiers() {
+			for _, modifier := range lc.modifiers[invocation.GetName()] {
+				if lc.callableChangesLength(modifier, name, inStorage) {
+					return true
+				}
+			}
+		}
+	}
+	return lc.changesLength(c.body, calleeName, inStorage, locals)
+}
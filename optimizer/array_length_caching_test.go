@@ -0,0 +1,110 @@
+// test file for array_length_caching.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const arrayLengthContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Lengths {
+    uint256[] items;
+    uint256[] other;
+    uint256 total;
+
+    function sum() external view returns (uint256 s) {
+        for (uint256 i = 0; i < items.length; i++) {
+            s += items[i];
+        }
+    }
+
+    function grow() external {
+        for (uint256 i = 0; i < items.length; i++) {
+            if (items[i] == 0) items.push(1);
+        }
+    }
+
+    function viaCall() external {
+        for (uint256 i = 0; i < items.length; i++) {
+            add(i);
+        }
+    }
+
+    function viaPointer() external {
+        uint256[] storage pointer = other;
+        for (uint256 i = 0; i < items.length; i++) {
+            pointer.push(i);
+        }
+    }
+
+    function viaOther() external {
+        for (uint256 i = 0; i < items.length; i++) {
+            other.push(i);
+            total += bump(i);
+        }
+    }
+
+    function inMemory(uint256[] memory values) external pure returns (uint256 s) {
+        for (uint256 i = 0; i < values.length; i++) {
+            s += values[i];
+        }
+    }
+
+    function reassigned(uint256[] memory values) external pure returns (uint256 s) {
+        for (uint256 i = 0; i < values.length; i++) {
+            values = new uint256[](i);
+        }
+        return values.length;
+    }
+
+    function add(uint256 i) internal {
+        items.push(i);
+    }
+
+    function bump(uint256 i) internal pure returns (uint256) {
+        return i + 1;
+    }
+}
+`
+
+// returns the names of the variables declared right before the first loop of the function
+func declaredBeforeLoop(builder *ir.Builder, name string) []string {
+	names := make([]string, 0)
+	for _, statement := range findFunction(builder, "Lengths", name).GetBody().GetStatements() {
+		if _, ok := statement.(*ast.ForStatement); ok {
+			break
+		}
+		if declaration, ok := statement.(*ast.VariableDeclaration); ok {
+			for _, d := range declaration.GetDeclarations() {
+				names = append(names, d.GetName())
+			}
+		}
+	}
+	return names
+}
+
+func TestCacheArrayLengths(t *testing.T) {
+	builder := setUpBuilder(t, arrayLengthContract)
+	optimizer.NewOptimizer(builder).CacheArrayLengths()
+
+	assert.Equal(t, []string{"cached_items_length"}, declaredBeforeLoop(builder, "sum"))
+	// the loop pushes to the array, directly, through a call or possibly through a storage pointer
+	assert.Empty(t, declaredBeforeLoop(builder, "grow"))
+	assert.Empty(t, declaredBeforeLoop(builder, "viaCall"))
+	assert.Equal(t, []string{"pointer"}, declaredBeforeLoop(builder, "viaPointer"))
+	// pushing to another array and calling a function that does not touch the array are fine
+	assert.Equal(t, []string{"cached_items_length"}, declaredBeforeLoop(builder, "viaOther"))
+	assert.Equal(t, []string{"cached_values_length"}, declaredBeforeLoop(builder, "inMemory"))
+	assert.Empty(t, declaredBeforeLoop(builder, "reassigned"))
+
+	// the condition reads the local variable
+	loop := findFunction(builder, "Lengths", "sum").GetBody().GetStatements()[1].(*ast.ForStatement)
+	condition := loop.Condition.(*ast.BinaryOperation)
+	assert.Equal(t, "cached_items_length", condition.RightExpression.(*ast.PrimaryExpression).GetName())
+}
@@ -0,0 +1,239 @@
+package optimizer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// isNilNode reports whether the node is nil or a typed nil pointer
+func isNilNode(node ast.Node[ast.NodeType]) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// walk visits the node and all of its children depth first.
+// Returning false from fn skips the children of the node.
+// Some nodes (e.g. assignments) list the same child more than once, so every node is visited only once.
+func walk(node ast.Node[ast.NodeType], fn func(ast.Node[ast.NodeType]) bool) {
+	seen := make(map[ast.Node[ast.NodeType]]bool, 0)
+	var visit func(ast.Node[ast.NodeType])
+	visit = func(n ast.Node[ast.NodeType]) {
+		if isNilNode(n) || seen[n] {
+			return
+		}
+		seen[n] = true
+		if !fn(n) {
+			return
+		}
+		for _, child := range n.GetNodes() {
+			visit(child)
+		}
+	}
+	visit(node)
+}
+
+// localNames returns the names of the parameters and local variables declared in the function
+func localNames(f *ast.Function) map[string]bool {
+	names := make(map[string]bool, 0)
+	for _, list := range []*ast.ParameterList{f.GetParameters(), f.GetReturnParameters()} {
+		if list == nil {
+			continue
+		}
+		for _, p := range list.GetParameters() {
+			names[p.GetName()] = true
+		}
+	}
+	walk(f.GetBody(), func(node ast.Node[ast.NodeType]) bool {
+		if d, ok := node.(*ast.Declaration); ok {
+			names[d.GetName()] = true
+		}
+		return true
+	})
+	return names
+}
+
+// uniqueName returns the name, or the name with a numeric suffix if it is already in names, and adds it to names
+func uniqueName(names map[string]bool, name string) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
+
+// visibleStateVariables returns the state variables declared in the contract and in the contracts it inherits from, by name
+func visibleStateVariables(tree *ast.Tree, contract *ast.Contract) map[string]*ast.StateVariableDeclaration {
+	variables := make(map[string]*ast.StateVariableDeclaration, 0)
+	contracts := inheritedContracts(tree, contract)
+	// most derived contract last so its declarations win
+	for i := len(contracts) - 1; i >= 0; i-- {
+		for _, node := range contracts[i].GetNodes() {
+			if sv, ok := node.(*ast.StateVariableDeclaration); ok {
+				variables[sv.GetName()] = sv
+			}
+		}
+	}
+	return variables
+}
+
+// inheritedContracts returns the contract followed by every contract, interface and library it inherits from, nearest first.
+// The parser only lists the direct bases of a contract, by the id of the source unit they are declared in.
+func inheritedContracts(tree *ast.Tree, contract ast.Node[ast.NodeType]) []ast.Node[ast.NodeType] {
+	contracts := []ast.Node[ast.NodeType]{contract}
+	seen := map[int64]bool{contract.GetId(): true}
+	for i := 0; i < len(contracts); i++ {
+		ids := linearizedBaseContracts(contracts[i])
+		// bases are listed from the most base-like to the most derived one
+		for j := len(ids) - 1; j >= 0; j-- {
+			base := contractDefinition(tree.GetById(ids[j]))
+			if base == nil || seen[base.GetId()] {
+				continue
+			}
+			seen[base.GetId()] = true
+			contracts = append(contracts, base)
+		}
+	}
+	return contracts
+}
+
+func linearizedBaseContracts(node ast.Node[ast.NodeType]) []int64 {
+	switch node := node.(type) {
+	case *ast.Contract:
+		return node.GetLinearizedBaseContracts()
+	case *ast.Interface:
+		return node.GetLinearizedBaseContracts()
+	case *ast.Library:
+		return node.GetLinearizedBaseContracts()
+	}
+	return nil
+}
+
+// contractDefinition returns the contract, interface or library the node stands for, or the one declared in the source unit
+func contractDefinition(node ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+	switch node := node.(type) {
+	case *ast.Contract, *ast.Interface, *ast.Library:
+		return node
+	case *ast.SourceUnit[ast.Node[ast_pb.SourceUnit]]:
+		if isNilNode(node.GetContract()) {
+			return nil
+		}
+		return contractDefinition(node.GetContract())
+	}
+	return nil
+}
+
+// isIncrementOrDecrement reports whether the unary operator writes to its operand.
+// `delete x` is parsed as an increment, so it is covered as well.
+func isIncrementOrDecrement(op ast_pb.Operator) bool {
+	return op == ast_pb.Operator_INCREMENT || op == ast_pb.Operator_DECREMENT
+}
+
+// writtenIdentifiers returns the identifiers that are assigned to inside the node,
+// including the ones whose elements or members are assigned to such as `a` in `a[i].x = 1`
+func writtenIdentifiers(node ast.Node[ast.NodeType]) map[*ast.PrimaryExpression]bool {
+	written := make(map[*ast.PrimaryExpression]bool, 0)
+	var markTarget func(ast.Node[ast.NodeType])
+	markTarget = func(target ast.Node[ast.NodeType]) {
+		switch target := target.(type) {
+		case *ast.PrimaryExpression:
+			written[target] = true
+		case *ast.TupleExpression:
+			for _, component := range target.GetComponents() {
+				markTarget(component)
+			}
+		case *ast.IndexAccess:
+			markTarget(target.GetBaseExpression())
+		case *ast.MemberAccessExpression:
+			markTarget(target.GetExpression())
+		}
+	}
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		switch n := n.(type) {
+		case *ast.Assignment:
+			if n.LeftExpression != nil {
+				markTarget(n.LeftExpression)
+			}
+		case *ast.UnaryPrefix:
+			if isIncrementOrDecrement(n.GetOperator()) {
+				markTarget(n.GetExpression())
+			}
+		case *ast.UnarySuffix:
+			if isIncrementOrDecrement(n.GetOperator()) {
+				markTarget(n.GetExpression())
+			}
+		}
+		return true
+	})
+	return written
+}
+
+var nodeInterface = reflect.TypeOf((*ast.Node[ast.NodeType])(nil)).Elem()
+
+// rewriteNodes visits every node below root depth first and puts whatever fn returns in place of it.
+// fn gets the node and its parent and returns the node itself to leave it untouched.
+// Only children held in fields of the generic node type can be replaced, the others are only visited.
+func rewriteNodes(root ast.Node[ast.NodeType], fn func(node ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType]) {
+	seen := make(map[ast.Node[ast.NodeType]]bool, 0)
+	var visit func(parent ast.Node[ast.NodeType])
+	replace := func(child ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+		if isNilNode(child) {
+			return child
+		}
+		// children are rewritten before their parents, so a replacement is final
+		visit(child)
+		return fn(child, parent)
+	}
+	visit = func(parent ast.Node[ast.NodeType]) {
+		if isNilNode(parent) || seen[parent] {
+			return
+		}
+		seen[parent] = true
+		value := reflect.ValueOf(parent)
+		if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+			return
+		}
+		value = value.Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Field(i)
+			// the embedded builder holds the whole tree, and type names point back at their parents
+			if !field.CanSet() || value.Type().Field(i).Anonymous || strings.HasPrefix(value.Type().Field(i).Name, "Parent") {
+				continue
+			}
+			switch {
+			case field.Type() == nodeInterface:
+				if child, ok := field.Interface().(ast.Node[ast.NodeType]); ok {
+					if replacement := replace(child, parent); replacement != child {
+						field.Set(reflect.ValueOf(&replacement).Elem())
+					}
+				}
+			case field.Kind() == reflect.Slice && field.Type().Elem() == nodeInterface:
+				for j := 0; j < field.Len(); j++ {
+					if child, ok := field.Index(j).Interface().(ast.Node[ast.NodeType]); ok {
+						if replacement := replace(child, parent); replacement != child {
+							field.Index(j).Set(reflect.ValueOf(&replacement).Elem())
+						}
+					}
+				}
+			case field.Type().Implements(nodeInterface):
+				if child, ok := field.Interface().(ast.Node[ast.NodeType]); ok {
+					visit(child)
+				}
+			case field.Kind() == reflect.Slice && field.Type().Elem().Implements(nodeInterface):
+				for j := 0; j < field.Len(); j++ {
+					if child, ok := field.Index(j).Interface().(ast.Node[ast.NodeType]); ok {
+						visit(child)
+					}
+				}
+			}
+		}
+	}
+	visit(root)
+}
@@ -0,0 +1,213 @@
+// Finds the internal functions, library functions and modifiers that the arguments of a call are passed to
+package optimizer
+
+import (
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+// callable is a function, constructor or modifier declared in a contract or a library
+type callable struct {
+	contract *ir.Contract
+	node     ast.Node[ast.NodeType]
+	name     string
+	params   []*ast.Parameter
+	returns  []*ast.Parameter
+	body     *ast.BodyNode
+}
+
+// binding is an argument of a call or a modifier invocation together with the parameter it is passed to
+type binding struct {
+	caller   *callable
+	argument ast.Node[ast.NodeType]
+	callee   *callable
+	param    *ast.Parameter
+}
+
+// callGraph holds the callables of every contract and the arguments passed between them.
+// Calls are resolved by name and number of arguments, so an overloaded or overridden function gets the
+// arguments of all its namesakes: every binding that may happen is there, along with some that may not.
+type callGraph struct {
+	callables []*callable
+	bindings  []binding
+	// callables referenced without being called, which can be called through a function pointer
+	values map[*callable]bool
+	// functions of contracts, by name
+	functions map[string][]*callable
+}
+
+func newCallGraph(contracts []*ir.Contract) *callGraph {
+	functions := make(map[string][]*callable, 0)
+	g := &callGraph{values: make(map[*callable]bool, 0), functions: functions}
+	modifiers := make(map[string][]*callable, 0)
+	libraries := make(map[string]map[string][]*callable, 0)
+	for _, contract := range contracts {
+		astContract := contract.GetAST().GetContract()
+		_, isLibrary := astContract.(*ast.Library)
+		name := ""
+		if named, ok := astContract.(interface{ GetName() string }); ok {
+			name = named.GetName()
+		}
+		for _, node := range astContract.GetNodes() {
+			c := newCallable(contract, node)
+			if c == nil {
+				continue
+			}
+			g.callables = append(g.callables, c)
+			switch {
+			case node.GetType() == ast_pb.NodeType_MODIFIER_DEFINITION:
+				modifiers[c.name] = append(modifiers[c.name], c)
+			case isLibrary:
+				if libraries[name] == nil {
+					libraries[name] = make(map[string][]*callable, 0)
+				}
+				libraries[name][c.name] = append(libraries[name][c.name], c)
+			default:
+				functions[c.name] = append(functions[c.name], c)
+			}
+		}
+	}
+
+	for _, caller := range g.callables {
+		callees := make(map[ast.Node[ast.NodeType]]bool, 0)
+		if invoker, ok := caller.node.(interface {
+			GetModifiers() []*ast.ModifierInvocation
+		}); ok {
+			for _, invocation := range invoker.GetModifiers() {
+				g.bind(caller, modifiers[invocation.GetName()], nil, invocation.GetArguments())
+			}
+		}
+		walk(caller.body, func(node ast.Node[ast.NodeType]) bool {
+			call, ok := node.(*ast.FunctionCall)
+			if !ok {
+				return true
+			}
+			callees[call.GetExpression()] = true
+			switch expression := call.GetExpression().(type) {
+			case *ast.PrimaryExpression:
+				g.bind(caller, functions[expression.GetName()], nil, call.GetArguments())
+			case *ast.MemberAccessExpression:
+				qualifier, _ := expression.GetExpression().(*ast.PrimaryExpression)
+				switch {
+				case qualifier != nil && qualifier.GetName() == "this":
+					// an external call copies its arguments
+				case qualifier != nil && libraries[qualifier.GetName()] != nil:
+					g.bind(caller, internalOnly(libraries[qualifier.GetName()][expression.GetMemberName()]), nil, call.GetArguments())
+				case qualifier != nil && (qualifier.GetName() == "super" || len(functions[expression.GetMemberName()]) > 0 && isContractName(qualifier)):
+					g.bind(caller, functions[expression.GetMemberName()], nil, call.GetArguments())
+				default:
+					// `x.f(y)` may call a library function attached with `using for`, which gets x as its first argument
+					for _, library := range libraries {
+						g.bind(caller, internalOnly(library[expression.GetMemberName()]), expression.GetExpression(), call.GetArguments())
+					}
+				}
+			}
+			return true
+		})
+
+		// any other use of a function name takes it as a value
+		walk(caller.body, func(node ast.Node[ast.NodeType]) bool {
+			ident, ok := node.(*ast.PrimaryExpression)
+			if ok && !callees[ident] && ident.GetType() == ast_pb.NodeType_IDENTIFIER {
+				for _, c := range functions[ident.GetName()] {
+					g.values[c] = true
+				}
+			}
+			return true
+		})
+	}
+	return g
+}
+
+// newCallable returns the callable declared by the node, or nil if it is not a function, constructor or modifier
+func newCallable(contract *ir.Contract, node ast.Node[ast.NodeType]) *callable {
+	c := &callable{contract: contract, node: node}
+	switch node := node.(type) {
+	case *ast.Function:
+		c.name, c.body = node.GetName(), node.GetBody()
+		if node.GetParameters() != nil {
+			c.params = node.GetParameters().GetParameters()
+		}
+		if node.GetReturnParameters() != nil {
+			c.returns = node.GetReturnParameters().GetParameters()
+		}
+	case *ast.Constructor:
+		c.body = node.GetBody()
+		if node.GetParameters() != nil {
+			c.params = node.GetParameters().GetParameters()
+		}
+	case *ast.ModifierDefinition:
+		c.name, c.body = node.GetName(), node.GetBody()
+		if node.GetParameters() != nil {
+			c.params = node.GetParameters().GetParameters()
+		}
+	default:
+		return nil
+	}
+	return c
+}
+
+// bind records the arguments passed to every callee that takes as many parameters.
+// self is the value a library function attached with `using for` is called on, if any.
+func (g *callGraph) bind(caller *callable, callees []*callable, self ast.Node[ast.NodeType], arguments []ast.Node[ast.NodeType]) {
+	if self != nil {
+		arguments = append([]ast.Node[ast.NodeType]{self}, arguments...)
+	}
+	for _, callee := range callees {
+		if len(callee.params) != len(arguments) {
+			continue
+		}
+		for i, argument := range arguments {
+			g.bindings = append(g.bindings, binding{caller: caller, argument: argument, callee: callee, param: callee.params[i]})
+		}
+	}
+}
+
+// internalOnly keeps the library functions that are called in place, the others get a copy of their arguments
+func internalOnly(callables []*callable) []*callable {
+	internal := make([]*callable, 0, len(callables))
+	for _, c := range callables {
+		if fn, ok := c.node.(*ast.Function); ok && (fn.GetVisibility() == ast_pb.Visibility_INTERNAL || fn.GetVisibility() == ast_pb.Visibility_PRIVATE) {
+			internal = append(internal, c)
+		}
+	}
+	return internal
+}
+
+// isContractName reports whether the identifier names a contract, as in `Base.f()`
+func isContractName(ident *ast.PrimaryExpression) bool {
+	td := ident.GetTypeDescription()
+	return td != nil && td.GetString() == "contract "+ident.GetName()
+}
+
+// argumentRoot returns the variable an argument is taken from, such as `a` in `a`, `a[i]` or `a.b[i]`.
+// Elements and members of a reference type are passed by reference along with the variable itself.
+func argumentRoot(argument ast.Node[ast.NodeType]) (*ast.PrimaryExpression, bool) {
+	switch argument := argument.(type) {
+	case *ast.PrimaryExpression:
+		return argument, argument.GetType() == ast_pb.NodeType_IDENTIFIER
+	case *ast.IndexAccess:
+		return argumentRoot(argument.GetBaseExpression())
+	case *ast.MemberAccessExpression:
+		return argumentRoot(argument.GetExpression())
+	case *ast.TupleExpression:
+		if len(argument.GetComponents()) == 1 {
+			return argumentRoot(argument.GetComponents()[0])
+		}
+	}
+	return nil, false
+}
+
+// parameterNamed returns the parameter of the callable with the given name
+func (c *callable) parameterNamed(name string) *ast.Parameter {
+	if c == nil {
+		return nil
+	}
+	for _, param := range c.params {
+		if param.GetName() == name && name != "" {
+			return param
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 
 	ast_pb "github.com/unpackdev/protos/dist/go/ast"
 	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
 	"go.uber.org/zap"
 )
 
@@ -36,82 +37,536 @@ const (
 
 // if the function argument is only read, it can be converted to calldata
 // reference: https://ethereum.stackexchange.com/questions/19380/external-vs-public-best-practices
-func (o *Optimizer) OptimizeCallData() {
-	zap.L().Info("Optimizing call data")
-	nodeVisitor := initVisitor()
-	contracts := o.builder.GetRoot().GetContracts()
-	for _, contract := range contracts {
-		functions := contract.GetFunctions()
-		for _, f := range functions {
-			candidates := make([]*ast.Parameter, 0)
-			astParameters := f.GetAST().Parameters.Parameters
-			for _, param := range astParameters {
-				if canBeConvertedToCallData(param) {
-					candidates = append(candidates, param)
-				}
+//
+// A public function that is also called internally with memory arguments keeps its memory parameters, unless
+// aggressive is set: then it is split into an external entry point taking calldata and an internal implementation
+// that keeps taking memory, which the internal callers are pointed to.
+func (o *Optimizer) OptimizeCallData(aggressive bool) []Change {
+	zap.L().Info("Optimizing call data", zap.Bool("aggressive", aggressive))
+	return o.record("optimize-call-data", o.optimizeCallData(aggressive))
+}
+
+func (o *Optimizer) optimizeCallData(aggressive bool) []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	graph := newCallGraph(o.builder.GetRoot().GetContracts())
+	local := make(map[*ast.Parameter]bool, 0)
+	candidates := make(map[*ast.Parameter]bool, 0)
+	for _, c := range graph.callables {
+		// the parameters of read only contracts keep their location, which the calls into them take into account
+		if !o.rewritable(c.contract) {
+			continue
+		}
+		for _, param := range callDataCandidates(tree, graph, c) {
+			local[param] = true
+			candidates[param] = true
+		}
+	}
+	keepConsistentCandidates(graph, candidates)
+
+	for _, c := range graph.callables {
+		split := false
+		for _, param := range c.params {
+			if !local[param] || candidates[param] || !calledWithMemory(graph, candidates, c, param) {
+				continue
+			}
+			if !aggressive {
+				zap.L().Info("Keeping memory parameter of function called internally with memory", zap.String("contract", c.contract.GetName()), zap.String("function", c.name), zap.String("parameter", param.GetName()))
+				continue
 			}
+			split = true
+		}
+		if split {
+			changes = append(changes, splitEntryPoint(graph, c, local)...)
+		}
+	}
 
-			modifier := f.GetStateMutability()
-			if modifier == ast_pb.Mutability_PURE || modifier == ast_pb.Mutability_VIEW {
-				for _, param := range candidates {
-					param.StorageLocation = ast_pb.StorageLocation_CALLDATA
-				}
+	for _, c := range graph.callables {
+		for _, param := range c.params {
+			if !candidates[param] {
 				continue
 			}
+			zap.L().Info("Converting parameter to calldata", zap.String("contract", c.contract.GetName()), zap.String("function", c.name), zap.String("parameter", param.GetName()))
+			param.StorageLocation = ast_pb.StorageLocation_CALLDATA
+			changes = append(changes, newChange(c.contract.GetName(), c.name, param.GetSrc(), param,
+				"the parameter is only read, so it can be read from calldata without copying it to memory", -memoryCopyCost))
+		}
+	}
+	return changes
+}
+
+// calledWithMemory reports whether the parameter of a public function is passed an argument that is not calldata
+// by an internal call
+func calledWithMemory(graph *callGraph, candidates map[*ast.Parameter]bool, c *callable, param *ast.Parameter) bool {
+	fn, ok := c.node.(*ast.Function)
+	if !ok || fn.GetVisibility() != ast_pb.Visibility_PUBLIC {
+		return false
+	}
+	for _, b := range graph.bindings {
+		if b.param == param && !fromCallData(b, candidates) {
+			return true
+		}
+	}
+	return false
+}
+
+// callDataCandidates returns the parameters of the function or modifier that could be calldata as far as its own
+// body is concerned: they have a type that can live in calldata and are never written to
+func callDataCandidates(tree *ast.Tree, graph *callGraph, c *callable) []*ast.Parameter {
+	// a function without a body is implemented elsewhere, with parameters that have to keep the same location
+	if c.body == nil || graph.values[c] {
+		return nil
+	}
+	switch node := c.node.(type) {
+	case *ast.Function:
+		// only overrides of external functions may change the location of their parameters
+		overridable := node.IsVirtual() || len(node.GetOverrides()) > 0
+		if node.GetKind() != ast_pb.NodeType_KIND_FUNCTION || (overridable && node.GetVisibility() != ast_pb.Visibility_EXTERNAL) {
+			return nil
+		}
+		if !canUseCallData(c.contract, node.GetVisibility()) {
+			return nil
+		}
+	case *ast.ModifierDefinition:
+		if node.Virtual || !canUseCallData(c.contract, node.GetVisibility()) {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	candidates := make([]*ast.Parameter, 0)
+	for _, param := range c.params {
+		if canBeConvertedToCallData(tree, param) && (!needsABICoderV2(tree, param) || usesABICoderV2(c.contract)) {
+			candidates = append(candidates, param)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// pure and view functions can still write to their memory parameters, so they are checked too
+	v := newCallDataVisitor(c.params, c.returns, c.body)
+	readOnly := make([]*ast.Parameter, 0, len(candidates))
+	for _, param := range candidates {
+		if v.modifiedParams[param.GetId()] {
+			continue
+		}
+		if needsABICoderV2(tree, param) && copiesToStorage(c.body, param) {
+			continue
+		}
+		readOnly = append(readOnly, param)
+	}
+	return readOnly
+}
 
-			// check if param is being used in the function
-			f.GetAST().GetTree().WalkNode(f.GetAST(), nodeVisitor.visitor)
-			for _, param := range candidates {
-				if _, found := nodeVisitor.modifiedParams[param.GetName()]; !found {
-					param.StorageLocation = ast_pb.StorageLocation_CALLDATA
+// keepConsistentCandidates drops candidates until the conversion holds across calls. A candidate passed on to an
+// internal function, library function or modifier needs the parameter it is passed to to be calldata or a candidate
+// too: a callee with a memory parameter gets a copy, which hides its writes from the caller. In turn a candidate
+// of the callee can only take arguments that come from calldata, as solc does not convert memory to calldata.
+func keepConsistentCandidates(graph *callGraph, candidates map[*ast.Parameter]bool) {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range graph.bindings {
+			// value types are copied anyway
+			if b.param.GetStorageLocation() != ast_pb.StorageLocation_MEMORY {
+				continue
+			}
+			if candidates[b.param] && !fromCallData(b, candidates) {
+				delete(candidates, b.param)
+				changed = true
+			}
+			if candidates[b.param] {
+				continue
+			}
+			for _, param := range passedParameters(b) {
+				if candidates[param] {
+					delete(candidates, param)
+					changed = true
 				}
 			}
 		}
 	}
 }
 
+// fromCallData reports whether the argument is, or is part of, a parameter of the caller that is or will be calldata
+func fromCallData(b binding, candidates map[*ast.Parameter]bool) bool {
+	root, ok := argumentRoot(b.argument)
+	if !ok {
+		return false
+	}
+	source := b.caller.parameterNamed(root.GetName())
+	return source != nil && (candidates[source] || source.GetStorageLocation() == ast_pb.StorageLocation_CALLDATA)
+}
+
+// passedParameters returns the parameters of the caller that the argument may pass by reference, which excludes
+// the ones only used to compute the argument, such as `p` in `f(p.length)` or `f(abi.encode(p))`
+func passedParameters(b binding) []*ast.Parameter {
+	params := make([]*ast.Parameter, 0)
+	walk(b.argument, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.PrimaryExpression:
+			if param := b.caller.parameterNamed(node.GetName()); param != nil {
+				params = append(params, param)
+			}
+		case *ast.FunctionCall:
+			return false
+		case *ast.IndexAccess:
+			walk(node.GetBaseExpression(), func(n ast.Node[ast.NodeType]) bool {
+				if ident, ok := n.(*ast.PrimaryExpression); ok {
+					if param := b.caller.parameterNamed(ident.GetName()); param != nil {
+						params = append(params, param)
+					}
+				}
+				_, isCall := n.(*ast.FunctionCall)
+				return !isCall
+			})
+			return false
+		}
+		return true
+	})
+	return params
+}
+
+// canUseCallData reports whether the compiler accepts calldata parameters for a function with the visibility.
+// External functions have had them since 0.5.0, the other visibilities since 0.6.9.
+func canUseCallData(contract *ir.Contract, visibility ast_pb.Visibility) bool {
+	if visibility == ast_pb.Visibility_EXTERNAL {
+		return requiresSolidity(contract, calldataExternalVersion)
+	}
+	return requiresSolidity(contract, calldataVersion)
+}
+
+var (
+	calldataExternalVersion = solidityVersion{major: 0, minor: 5, patch: 0}
+	calldataVersion         = solidityVersion{major: 0, minor: 6, patch: 9}
+)
+
+// canBeConvertedToCallData reports whether the parameter is a memory reference type that can live in calldata:
+// strings, bytes, structs without mappings and arrays of any of those or of value types, at any depth.
 // https://docs.soliditylang.org/en/latest/types.html#reference-types
-func canBeConvertedToCallData(param *ast.Parameter) bool {
-	if param.StorageLocation != ast_pb.StorageLocation_MEMORY {
+func canBeConvertedToCallData(tree *ast.Tree, param *ast.Parameter) bool {
+	if param.StorageLocation != ast_pb.StorageLocation_MEMORY || param.GetTypeName() == nil {
+		return false
+	}
+	base, dimensions, ok := arrayBaseType(param.GetTypeName().GetName())
+	if !ok {
 		return false
 	}
-	paramType := param.GetTypeName().GetName()
-	isSlice := strings.Contains(paramType, "]")
-	isMapping := strings.Contains(paramType, "mapping")
-	// TODO: we dont handle structs for now
-	if isSlice || isMapping {
+	if base == "string" || base == "bytes" {
 		return true
 	}
+	if _, isValue := sizeMap[base]; isValue {
+		return dimensions > 0
+	}
+
+	// user defined types are only known through their declaration
+	switch decl := tree.GetById(param.GetTypeName().GetReferencedDeclaration()).(type) {
+	case *ast.StructDefinition:
+		// the parser leaves the name of a plain struct type empty
+		if base != "" && base != decl.GetName() {
+			return false
+		}
+		return !containsMapping(tree, decl, make(map[int64]bool, 0))
+	case *ast.EnumDefinition, *ast.Contract, *ast.Interface:
+		return dimensions > 0 && base == decl.(interface{ GetName() string }).GetName()
+	}
 	return false
 }
 
+// arrayBaseType splits a type name such as `uint256[][3]` into its base type and its number of dimensions.
+// It returns false if the name is not a well formed array type.
+func arrayBaseType(name string) (string, int, bool) {
+	dimensions := 0
+	for strings.HasSuffix(name, "]") {
+		open := strings.LastIndex(name, "[")
+		if open < 0 {
+			return "", 0, false
+		}
+		for _, c := range name[open+1 : len(name)-1] {
+			if c < '0' || c > '9' {
+				return "", 0, false
+			}
+		}
+		name = name[:open]
+		dimensions++
+	}
+	return name, dimensions, true
+}
+
+// containsMapping reports whether the struct has a mapping member, directly or through a nested struct.
+// Such a struct can only live in storage.
+func containsMapping(tree *ast.Tree, structDef *ast.StructDefinition, visited map[int64]bool) bool {
+	if visited[structDef.GetId()] {
+		return false
+	}
+	visited[structDef.GetId()] = true
+	for _, member := range structDef.GetMembers() {
+		typeName := member.GetTypeName()
+		if typeName == nil || isMapping(typeName) {
+			return true
+		}
+		if nested, ok := tree.GetById(typeName.GetReferencedDeclaration()).(*ast.StructDefinition); ok && containsMapping(tree, nested, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsABICoderV2 reports whether the parameter can only be decoded from calldata by ABI coder v2,
+// which is the case for structs and for arrays of dynamic or nested types.
+func needsABICoderV2(tree *ast.Tree, param *ast.Parameter) bool {
+	base, dimensions, _ := arrayBaseType(param.GetTypeName().GetName())
+	if base == "string" || base == "bytes" {
+		return dimensions > 0
+	}
+	if _, isValue := sizeMap[base]; isValue {
+		return dimensions > 1
+	}
+	_, isStruct := tree.GetById(param.GetTypeName().GetReferencedDeclaration()).(*ast.StructDefinition)
+	return isStruct || dimensions > 1
+}
+
+// copiesToStorage reports whether the parameter is assigned or pushed as a whole, which may copy it to storage.
+// The legacy code generator cannot copy structs and nested arrays from calldata to storage.
+func copiesToStorage(body *ast.BodyNode, param *ast.Parameter) bool {
+	isParam := func(node ast.Node[ast.NodeType]) bool {
+		ident, ok := node.(*ast.PrimaryExpression)
+		return ok && ident.GetName() == param.GetName()
+	}
+	found := false
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.Assignment:
+			found = found || isParam(node.GetRightExpression())
+		case *ast.FunctionCall:
+			if member, ok := node.GetExpression().(*ast.MemberAccessExpression); ok && member.GetMemberName() == "push" {
+				for _, arg := range node.GetArguments() {
+					found = found || isParam(arg)
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// CallDataVisitor finds the parameters of one function or modifier that are written to, either directly or through a
+// local memory variable that points into them. Variables are told apart by declaration id, so a parameter is never
+// confused with a namesake declared somewhere else.
 type CallDataVisitor struct {
-	modifiedParams map[string]bool
-	visitor        *ast.NodeVisitor
-}
-
-// TODO: Check if the parameter is modified in the function
-// might have to do a dfs here to check if the parameter is modified
-func initVisitor() *CallDataVisitor {
-	callDataVisitor := &CallDataVisitor{
-		modifiedParams: make(map[string]bool, 0),
-		visitor:        &ast.NodeVisitor{},
-	}
-	callDataVisitor.visitor.RegisterTypeVisit(ast_pb.NodeType_ASSIGNMENT, func(node ast.Node[ast.NodeType]) (bool, error) {
-		assignment, _ := node.(*ast.Assignment)
-		if le := assignment.GetLeftExpression(); le != nil {
-			assignment.GetTree().ExecuteCustomTypeVisit(assignment.GetNodes(), ast_pb.NodeType_IDENTIFIER, func(node ast.Node[ast.NodeType]) (bool, error) {
-				name := node.(*ast.PrimaryExpression).GetName()
-				callDataVisitor.modifiedParams[name] = true
-				return true, nil
-			})
+	// declaration ids of the parameters and local variables, by name
+	declarations map[string]int64
+	params       map[int64]bool
+	// local memory variables, with the parameters they may point into
+	aliases        map[int64]map[int64]bool
+	modifiedParams map[int64]bool
+}
+
+// newCallDataVisitor analyses the body of a function or modifier with the given parameters and return parameters
+func newCallDataVisitor(params []*ast.Parameter, returns []*ast.Parameter, body *ast.BodyNode) *CallDataVisitor {
+	v := &CallDataVisitor{
+		declarations:   make(map[string]int64, 0),
+		params:         make(map[int64]bool, 0),
+		aliases:        make(map[int64]map[int64]bool, 0),
+		modifiedParams: make(map[int64]bool, 0),
+	}
+	for _, param := range params {
+		v.declarations[param.GetName()] = param.GetId()
+		v.params[param.GetId()] = true
+	}
+	for _, param := range returns {
+		v.declarations[param.GetName()] = param.GetId()
+		if param.GetStorageLocation() == ast_pb.StorageLocation_MEMORY {
+			v.aliases[param.GetId()] = make(map[int64]bool, 0)
 		}
-		// check left hand side
-		return true, nil
+	}
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		if declaration, ok := node.(*ast.Declaration); ok {
+			v.declarations[declaration.GetName()] = declaration.GetId()
+			if declaration.StorageLocation == ast_pb.StorageLocation_MEMORY {
+				v.aliases[declaration.GetId()] = make(map[int64]bool, 0)
+			}
+		}
+		return true
 	})
-	return callDataVisitor
+
+	// an alias can be made after the write that goes through it when both are in a loop, so the aliases are
+	// collected until they stop growing before looking for writes
+	for v.collectAliases(body) {
+	}
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.Assignment:
+			// compound assignments such as `a[i] += 1` write too
+			if node.GetLeftExpression() != nil {
+				v.markWritten(node.GetLeftExpression(), false)
+			}
+		case *ast.UnaryPrefix:
+			// the parser reads `delete x` as an increment
+			if isIncrementOrDecrement(node.GetOperator()) {
+				v.markWritten(node.GetExpression(), false)
+			}
+		case *ast.UnarySuffix:
+			if isIncrementOrDecrement(node.GetOperator()) {
+				v.markWritten(node.GetExpression(), false)
+			}
+		case *ast.FunctionCall:
+			if member, ok := node.GetExpression().(*ast.MemberAccessExpression); ok && (member.GetMemberName() == "push" || member.GetMemberName() == "pop") {
+				v.markWritten(member.GetExpression(), true)
+			}
+		}
+		return true
+	})
+	return v
 }
 
-func isParamModified(param *ast.Parameter, f *ast.Function) bool {
-	return false
+// declarationOf returns the id of the parameter or local variable the identifier refers to
+func (v *CallDataVisitor) declarationOf(node ast.Node[ast.NodeType]) (int64, bool) {
+	ident, ok := node.(*ast.PrimaryExpression)
+	if !ok || ident.GetType() != ast_pb.NodeType_IDENTIFIER {
+		return 0, false
+	}
+	id, ok := v.declarations[ident.GetName()]
+	return id, ok
+}
+
+// collectAliases adds the parameters that each local memory variable may point into after its declaration or an
+// assignment to it. Returns true if anything was added.
+func (v *CallDataVisitor) collectAliases(body *ast.BodyNode) bool {
+	added := false
+	alias := func(target ast.Node[ast.NodeType], value ast.Node[ast.NodeType]) {
+		walk(target, func(node ast.Node[ast.NodeType]) bool {
+			id, ok := v.declarationOf(node)
+			if !ok || v.aliases[id] == nil {
+				return true
+			}
+			for param := range v.sources(value) {
+				if !v.aliases[id][param] {
+					v.aliases[id][param] = true
+					added = true
+				}
+			}
+			return true
+		})
+	}
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.VariableDeclaration:
+			for _, declaration := range node.GetDeclarations() {
+				if v.aliases[declaration.GetId()] == nil {
+					continue
+				}
+				for param := range v.sources(node.GetInitialValue()) {
+					if !v.aliases[declaration.GetId()][param] {
+						v.aliases[declaration.GetId()][param] = true
+						added = true
+					}
+				}
+			}
+		case *ast.Assignment:
+			// only a whole variable is made to point somewhere else, `(a, b) = (p, q)` may alias either with either
+			if target := node.GetLeftExpression(); target != nil {
+				if _, isTuple := target.(*ast.TupleExpression); isTuple || isIdentifier(target) {
+					alias(target, node.GetRightExpression())
+				}
+			}
+		}
+		return true
+	})
+	return added
+}
+
+// isIdentifier reports whether the node is a plain identifier
+func isIdentifier(node ast.Node[ast.NodeType]) bool {
+	ident, ok := node.(*ast.PrimaryExpression)
+	return ok && ident.GetType() == ast_pb.NodeType_IDENTIFIER
+}
+
+// sources returns the parameters whose memory the value of the expression may point into
+func (v *CallDataVisitor) sources(expression ast.Node[ast.NodeType]) map[int64]bool {
+	sources := make(map[int64]bool, 0)
+	var visit func(node ast.Node[ast.NodeType])
+	visit = func(node ast.Node[ast.NodeType]) {
+		switch node := node.(type) {
+		case *ast.PrimaryExpression:
+			id, ok := v.declarationOf(node)
+			if !ok {
+				return
+			}
+			if v.params[id] {
+				sources[id] = true
+			}
+			for param := range v.aliases[id] {
+				sources[param] = true
+			}
+		case *ast.IndexAccess:
+			visit(node.GetBaseExpression())
+		case *ast.MemberAccessExpression:
+			visit(node.GetExpression())
+		case *ast.TupleExpression:
+			for _, component := range node.GetComponents() {
+				visit(component)
+			}
+		case *ast.Conditional:
+			for _, branch := range node.Expressions[1:] {
+				visit(branch)
+			}
+		case *ast.FunctionCall:
+			// a function can return one of its arguments, builtins and conversions return a new value
+			if isStorageCall(node) {
+				for _, arg := range node.GetArguments() {
+					visit(arg)
+				}
+			}
+		}
+	}
+	if !isNilNode(expression) {
+		visit(expression)
+	}
+	return sources
+}
+
+// markWritten records the parameters written to by a write to the target. through is true when the write goes to
+// an element or member of the target rather than to the target itself.
+func (v *CallDataVisitor) markWritten(target ast.Node[ast.NodeType], through bool) {
+	switch target := target.(type) {
+	case *ast.PrimaryExpression:
+		id, ok := v.declarationOf(target)
+		if !ok {
+			return
+		}
+		// a parameter can not be assigned a new value once it is calldata either
+		if v.params[id] {
+			v.modifiedParams[id] = true
+		}
+		// making a local variable point somewhere else leaves what it pointed to untouched
+		if through {
+			for param := range v.aliases[id] {
+				v.modifiedParams[param] = true
+			}
+		}
+	case *ast.IndexAccess:
+		v.markWritten(target.GetBaseExpression(), true)
+	case *ast.MemberAccessExpression:
+		v.markWritten(target.GetExpression(), true)
+	case *ast.TupleExpression:
+		for _, component := range target.GetComponents() {
+			v.markWritten(component, through)
+		}
+	}
+}
+
+// IsParamModified reports whether the function writes to the parameter, or to memory the parameter points to,
+// which a calldata parameter does not allow
+func IsParamModified(param *ast.Parameter, f *ast.Function) bool {
+	if f.GetBody() == nil || f.GetParameters() == nil {
+		return false
+	}
+	returns := make([]*ast.Parameter, 0)
+	if f.GetReturnParameters() != nil {
+		returns = f.GetReturnParameters().GetParameters()
+	}
+	v := newCallDataVisitor(f.GetParameters().GetParameters(), returns, f.GetBody())
+	return v.modifiedParams[param.GetId()]
 }
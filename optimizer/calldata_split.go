@@ -0,0 +1,172 @@
+// Splits a public function into an external entry point taking calldata and an internal implementation
+package optimizer
+
+import (
+	"fmt"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+// splitEntryPoint turns `function f(T memory a) public` into `function f(T calldata a) external` that calls
+// `function _f(T memory a) internal`, which keeps the body and the modifiers, and points the internal calls of f
+// to _f. The parameters in convertible become calldata in the entry point.
+// Overloaded functions, functions with unnamed parameters and functions of libraries are left alone,
+// in which case it returns no change.
+func splitEntryPoint(graph *callGraph, c *callable, convertible map[*ast.Parameter]bool) []Change {
+	fn := c.node.(*ast.Function)
+	nodes, ok := contractNodes(c.contract.GetAST().GetContract())
+	if !ok || c.contract.GetAST().GetContract().GetType() != ast_pb.NodeType_CONTRACT_DEFINITION || len(graph.functions[c.name]) != 1 {
+		return nil
+	}
+	for _, param := range c.params {
+		if param.GetName() == "" {
+			return nil
+		}
+	}
+	if fn.ASTBuilder == nil {
+		return nil
+	}
+	name := implementationName(graph, c.name)
+	src := fn.GetSrc()
+	zap.L().Info("Splitting function into an external entry point and an internal implementation", zap.String("contract", c.contract.GetName()), zap.String("function", c.name), zap.String("implementation", name))
+
+	entry := &ast.Function{
+		ASTBuilder:       fn.ASTBuilder,
+		Id:               fn.GetNextID(),
+		Name:             fn.GetName(),
+		NodeType:         ast_pb.NodeType_FUNCTION_DEFINITION,
+		Kind:             ast_pb.NodeType_KIND_FUNCTION,
+		Implemented:      true,
+		Visibility:       ast_pb.Visibility_EXTERNAL,
+		StateMutability:  fn.GetStateMutability(),
+		Modifiers:        make([]*ast.ModifierInvocation, 0),
+		Overrides:        make([]*ast.OverrideSpecifier, 0),
+		Parameters:       &ast.ParameterList{Id: fn.GetNextID(), NodeType: ast_pb.NodeType_PARAMETER_LIST, Parameters: []*ast.Parameter{}},
+		ReturnParameters: &ast.ParameterList{Id: fn.GetNextID(), NodeType: ast_pb.NodeType_PARAMETER_LIST, Parameters: []*ast.Parameter{}},
+		Scope:            fn.Scope,
+		TypeDescription:  fn.GetTypeDescription(),
+	}
+	call := &ast.FunctionCall{
+		Id:       fn.GetNextID(),
+		NodeType: ast_pb.NodeType_FUNCTION_CALL,
+		Kind:     ast_pb.NodeType_FUNCTION_CALL,
+		Expression: &ast.PrimaryExpression{
+			Id:                    fn.GetNextID(),
+			NodeType:              ast_pb.NodeType_IDENTIFIER,
+			Name:                  name,
+			ReferencedDeclaration: fn.GetId(),
+			TypeDescription:       fn.GetTypeDescription(),
+		},
+		Arguments: make([]ast.Node[ast.NodeType], 0, len(c.params)),
+	}
+	converted := 0
+	for _, param := range c.params {
+		clone := *param
+		clone.Id = fn.GetNextID()
+		if convertible[param] {
+			clone.StorageLocation = ast_pb.StorageLocation_CALLDATA
+			converted++
+		}
+		entry.Parameters.Parameters = append(entry.Parameters.Parameters, &clone)
+		call.Arguments = append(call.Arguments, &ast.PrimaryExpression{
+			Id:                    fn.GetNextID(),
+			NodeType:              ast_pb.NodeType_IDENTIFIER,
+			Name:                  clone.GetName(),
+			ReferencedDeclaration: clone.GetId(),
+			TypeDescription:       clone.GetTypeDescription(),
+		})
+	}
+	var statement ast.Node[ast.NodeType] = call
+	if fn.GetReturnParameters() != nil && len(fn.GetReturnParameters().GetParameters()) > 0 {
+		for _, param := range fn.GetReturnParameters().GetParameters() {
+			clone := *param
+			clone.Id = fn.GetNextID()
+			entry.ReturnParameters.Parameters = append(entry.ReturnParameters.Parameters, &clone)
+		}
+		statement = &ast.ReturnStatement{Id: fn.GetNextID(), NodeType: ast_pb.NodeType_RETURN_STATEMENT, Expression: call}
+	}
+	entry.Body = &ast.BodyNode{Id: fn.GetNextID(), NodeType: ast_pb.NodeType_BLOCK, Statements: []ast.Node[ast.NodeType]{statement}}
+
+	// internal calls now go to the implementation, external ones to the entry point with the old name
+	renameInternalCalls(graph, c.name, name)
+	fn.Name = name
+	fn.Visibility = ast_pb.Visibility_INTERNAL
+	// internal functions can not be payable, the entry point checks the value
+	if fn.GetStateMutability() == ast_pb.Mutability_PAYABLE {
+		fn.StateMutability = ast_pb.Mutability_NONPAYABLE
+	}
+	c.name = name
+
+	split := make([]ast.Node[ast.NodeType], 0, len(*nodes)+1)
+	for _, node := range *nodes {
+		if node == ast.Node[ast.NodeType](fn) {
+			split = append(split, entry)
+		}
+		split = append(split, node)
+	}
+	*nodes = split
+	for _, f := range c.contract.Functions {
+		if f.GetAST() == fn {
+			f.Name = name
+			f.Visibility = fn.GetVisibility()
+			f.StateMutability = fn.GetStateMutability()
+		}
+	}
+	c.contract.Functions = append(c.contract.Functions, &ir.Function{
+		Unit:            entry,
+		Id:              entry.GetId(),
+		NodeType:        entry.GetType(),
+		Kind:            entry.GetKind(),
+		Name:            entry.GetName(),
+		Implemented:     true,
+		Visibility:      entry.GetVisibility(),
+		StateMutability: entry.GetStateMutability(),
+	})
+	rationale := fmt.Sprintf("external callers pass calldata to the entry point, the internal callers keep passing memory to %s", name)
+	return []Change{newChange(c.contract.GetName(), entry.GetName(), src, entry, rationale, -converted*memoryCopyCost)}
+}
+
+// implementationName returns `_name`, with a number appended if a declaration of that name already exists
+func implementationName(graph *callGraph, name string) string {
+	taken := make(map[string]bool, 0)
+	for _, c := range graph.callables {
+		walk(c.contract.GetAST().GetContract(), func(node ast.Node[ast.NodeType]) bool {
+			if named, ok := node.(interface{ GetName() string }); ok {
+				taken[named.GetName()] = true
+			}
+			return true
+		})
+	}
+	candidate := "_" + name
+	for i := 2; taken[candidate]; i++ {
+		candidate = fmt.Sprintf("_%s%d", name, i)
+	}
+	return candidate
+}
+
+// renameInternalCalls points the calls of `name()`, `super.name()` and `Base.name()` to the new name
+func renameInternalCalls(graph *callGraph, name, renamed string) {
+	for _, caller := range graph.callables {
+		walk(caller.body, func(node ast.Node[ast.NodeType]) bool {
+			call, ok := node.(*ast.FunctionCall)
+			if !ok {
+				return true
+			}
+			switch expression := call.GetExpression().(type) {
+			case *ast.PrimaryExpression:
+				if expression.GetName() == name {
+					expression.Name = renamed
+				}
+			case *ast.MemberAccessExpression:
+				qualifier, ok := expression.GetExpression().(*ast.PrimaryExpression)
+				if ok && expression.GetMemberName() == name && (qualifier.GetName() == "super" || isContractName(qualifier)) {
+					expression.MemberName = renamed
+				}
+			}
+			return true
+		})
+	}
+}
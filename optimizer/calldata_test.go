@@ -0,0 +1,323 @@
+// test file for calldata.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const callDataContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Registry {
+    struct Point {
+        uint256 x;
+        uint256 y;
+    }
+
+    struct Ledger {
+        uint256 id;
+        mapping(address => uint256) balances;
+    }
+
+    enum Kind { Small, Large }
+
+    Point public origin;
+    Point[] public points;
+
+    function name(string memory label) external pure returns (uint256) {
+        return bytes(label).length;
+    }
+
+    function hash(bytes memory payload) external pure returns (bytes32) {
+        return keccak256(payload);
+    }
+
+    function sum(Point memory p) external pure returns (uint256) {
+        return p.x + p.y;
+    }
+
+    function corner(uint256[][] memory cells) external pure returns (uint256) {
+        return cells[0][0];
+    }
+
+    function first(Point[] memory ps) external pure returns (uint256) {
+        return ps[0].x;
+    }
+
+    function count(Kind[] memory kinds) external pure returns (uint256) {
+        return kinds.length;
+    }
+
+    function ledger(Ledger memory l) external pure returns (uint256) {
+        return l.id;
+    }
+
+    function bump(Point memory p) external pure returns (uint256) {
+        p.x++;
+        return p.x;
+    }
+
+    function move(Point memory p) external {
+        origin = p;
+    }
+
+    function add(Point memory p) external {
+        points.push(p);
+    }
+}
+`
+
+const oldCallDataContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.6.12;
+
+contract Registry {
+    function corner(uint256[][] memory cells) external pure returns (uint256) {
+        return cells[0][0];
+    }
+
+    function total(uint256[] memory values) external pure returns (uint256) {
+        return values[0];
+    }
+}
+`
+
+const callDataCallsContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+library Arrays {
+    function total(uint256[] memory values) internal pure returns (uint256) {
+        return values[0];
+    }
+
+    function clear(uint256[] memory cleared) internal pure {
+        cleared[0] = 0;
+    }
+}
+
+contract Summer {
+    using Arrays for uint256[];
+
+    modifier nonEmpty(uint256[] memory values) {
+        require(values.length > 0);
+        _;
+    }
+
+    function first(uint256[] memory values) internal pure returns (uint256) {
+        return values[0];
+    }
+
+    function last(uint256[] memory values) internal pure returns (uint256) {
+        return values[values.length - 1];
+    }
+
+    function reset(uint256[] memory zeroed) internal pure {
+        zeroed[0] = 0;
+    }
+
+    function sum(uint256[] memory values) external pure nonEmpty(values) returns (uint256) {
+        return first(values) + Arrays.total(values) + values.total();
+    }
+
+    function sumAndReset(uint256[] memory values) external pure returns (uint256) {
+        reset(values);
+        return values[0];
+    }
+
+    function sumAndClear(uint256[] memory values) external pure returns (uint256) {
+        values.clear();
+        return values[0];
+    }
+
+    function fresh() external pure returns (uint256) {
+        uint256[] memory values = new uint256[](1);
+        return last(values);
+    }
+
+    function wrapped(uint256[] memory values) external pure returns (uint256) {
+        return last(values);
+    }
+}
+`
+
+const writesContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Writes {
+    struct Point {
+        uint256 x;
+        uint256[] ys;
+    }
+
+    function remove(uint256[] memory a) external pure {
+        delete a[0];
+    }
+
+    function shrink(Point memory p) external pure {
+        p.ys.pop();
+    }
+
+    function destructure(uint256[] memory a, uint256[] memory b) external pure {
+        (a[1], b[0]) = (1, 2);
+    }
+
+    function either(uint256[] memory b, uint256[] memory c, bool f) external pure {
+        uint256[] memory other = f ? c : b;
+        other[0] += 1;
+    }
+
+    function later(Point memory p) external pure {
+        Point memory q;
+        for (uint256 i = 0; i < 2; i++) {
+            if (i == 1) {
+                q.x--;
+            }
+            q = p;
+        }
+    }
+
+    function rebind(uint256[] memory a, uint256[] memory b) external pure returns (uint256) {
+        uint256[] memory other = a;
+        other = b;
+        return other[0];
+    }
+
+    function sum(uint256[] memory a) external pure returns (uint256) {
+        uint256 s = a[0];
+        s += a[1];
+        return s;
+    }
+}
+`
+
+const publicCallDataContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Summer {
+    uint256 public total;
+
+    function sum(uint256[] memory values) public pure returns (uint256) {
+        uint256 s = 0;
+        for (uint256 i = 0; i < values.length; i++) {
+            s += values[i];
+        }
+        return s;
+    }
+
+    function pay(uint256[] memory amounts) public payable {
+        total += amounts[0];
+    }
+
+    function sumOfTwo() external pure returns (uint256) {
+        uint256[] memory values = new uint256[](2);
+        return sum(values);
+    }
+
+    function payOnce(uint256[] memory amounts) external {
+        pay(amounts);
+    }
+}
+`
+
+// returns the storage location of the first parameter of the function
+func firstParameterLocation(builder *ir.Builder, contractName string, name string) ast_pb.StorageLocation {
+	fn := findFunction(builder, contractName, name)
+	return fn.GetParameters().GetParameters()[0].GetStorageLocation()
+}
+
+func TestOptimizeCallData(t *testing.T) {
+	builder := setUpBuilder(t, callDataContract)
+	optimizer.NewOptimizer(builder).OptimizeCallData(false)
+
+	for _, name := range []string{"name", "hash", "sum", "corner", "first", "count"} {
+		assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Registry", name), name)
+	}
+	// a struct with a mapping cannot be in calldata, a written parameter and
+	// a struct copied to storage would not compile
+	for _, name := range []string{"ledger", "bump", "move", "add"} {
+		assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Registry", name), name)
+	}
+}
+
+func TestOptimizeCallDataABICoderV1(t *testing.T) {
+	builder := setUpBuilder(t, oldCallDataContract)
+	optimizer.NewOptimizer(builder).OptimizeCallData(false)
+
+	// nested arrays can only be decoded from calldata by ABI coder v2
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Registry", "corner"))
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Registry", "total"))
+}
+
+func TestOptimizeCallDataAcrossCalls(t *testing.T) {
+	builder := setUpBuilder(t, callDataCallsContract)
+	optimizer.NewOptimizer(builder).OptimizeCallData(false)
+
+	// the modifier and the functions the parameter is passed to are converted along with it
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "sum"))
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "first"))
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Arrays", "total"))
+	// the callee writes to the parameter, directly or through using for
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "sumAndReset"))
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "sumAndClear"))
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Arrays", "clear"))
+	// last is also called with a memory array, so it has to keep taking memory
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "last"))
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "wrapped"))
+}
+
+func TestIsParamModified(t *testing.T) {
+	builder := setUpBuilder(t, writesContract)
+	modified := func(function string) []bool {
+		fn := findFunction(builder, "Writes", function)
+		result := make([]bool, 0)
+		for _, param := range fn.GetParameters().GetParameters() {
+			result = append(result, optimizer.IsParamModified(param, fn))
+		}
+		return result
+	}
+
+	assert.Equal(t, []bool{true}, modified("remove"))
+	assert.Equal(t, []bool{true}, modified("shrink"))
+	assert.Equal(t, []bool{true, true}, modified("destructure"))
+	// written through a local that may point to either array
+	assert.Equal(t, []bool{true, true, false}, modified("either"))
+	// the alias is made after the write, in an earlier iteration of the loop
+	assert.Equal(t, []bool{true}, modified("later"))
+	// pointing a local somewhere else and writing to a value copied out of the array do not write to it
+	assert.Equal(t, []bool{false, false}, modified("rebind"))
+	assert.Equal(t, []bool{false}, modified("sum"))
+}
+
+func TestOptimizeCallDataPublicFunctions(t *testing.T) {
+	builder := setUpBuilder(t, publicCallDataContract)
+	optimizer.NewOptimizer(builder).OptimizeCallData(false)
+
+	// sum is called internally with a memory array, so it can not take calldata
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "sum"))
+	// pay only gets calldata, from payOnce
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "pay"))
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "payOnce"))
+}
+
+func TestOptimizeCallDataSplitPublicFunctions(t *testing.T) {
+	builder := setUpBuilder(t, publicCallDataContract)
+	optimizer.NewOptimizer(builder).OptimizeCallData(true)
+
+	// the entry point keeps the name and forwards to the implementation, which internal callers now call
+	sum := findFunction(builder, "Summer", "sum")
+	assert.Equal(t, ast_pb.Visibility_EXTERNAL, sum.GetVisibility())
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "sum"))
+	assert.Equal(t, "_sum(values)", printReturn(t, sum))
+	implementation := findFunction(builder, "Summer", "_sum")
+	assert.Equal(t, ast_pb.Visibility_INTERNAL, implementation.GetVisibility())
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, firstParameterLocation(builder, "Summer", "_sum"))
+	assert.Equal(t, "_sum(values)", printReturn(t, findFunction(builder, "Summer", "sumOfTwo")))
+
+	// pay needs no split
+	assert.Nil(t, findFunction(builder, "Summer", "_pay"))
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, firstParameterLocation(builder, "Summer", "pay"))
+}
@@ -0,0 +1,92 @@
+package optimizer
+
+import (
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// builtin functions that neither touch storage nor hand control to another contract
+var builtinFunctions = map[string]bool{
+	"require":   true,
+	"assert":    true,
+	"revert":    true,
+	"keccak256": true,
+	"sha256":    true,
+	"ripemd160": true,
+	"ecrecover": true,
+	"addmod":    true,
+	"mulmod":    true,
+	"blockhash": true,
+	"gasleft":   true,
+	"type":      true,
+	"payable":   true,
+	"string":    true,
+	"bytes":     true,
+}
+
+// members of builtin objects and arrays that do not call out of the contract
+var builtinMembers = map[string]bool{
+	"push":                true,
+	"pop":                 true,
+	"encode":              true,
+	"encodePacked":        true,
+	"encodeWithSelector":  true,
+	"encodeWithSignature": true,
+	"encodeCall":          true,
+	"decode":              true,
+	"concat":              true,
+}
+
+// isExternalCall reports whether the call can hand control to another contract.
+// Anything accessed through a member that is not a known builtin is treated as external,
+// which also covers `this.f()`, low level calls and library calls.
+func isExternalCall(call *ast.FunctionCall) bool {
+	var expression ast.Node[ast.NodeType] = call.GetExpression()
+	if option, ok := expression.(*ast.FunctionCallOption); ok {
+		expression = option.GetExpression()
+	}
+	member, ok := expression.(*ast.MemberAccessExpression)
+	if !ok {
+		return false
+	}
+	return !builtinMembers[member.GetMemberName()]
+}
+
+// isStorageCall reports whether the call may read or write storage behind our back,
+// either by calling out of the contract or by calling an internal function
+func isStorageCall(call *ast.FunctionCall) bool {
+	if isExternalCall(call) {
+		return true
+	}
+	switch expression := call.GetExpression().(type) {
+	case *ast.PrimaryExpression:
+		name := expression.GetName()
+		// type conversions such as uint8(x) or address(x)
+		if _, ok := sizeMap[name]; ok {
+			return false
+		}
+		return !builtinFunctions[name]
+	case *ast.MemberAccessExpression:
+		return false
+	}
+	return true
+}
+
+// storageCalls returns the calls inside the nodes that may touch storage
+func storageCalls(tree *ast.Tree, nodes ...ast.Node[ast.NodeType]) []*ast.FunctionCall {
+	calls := make([]*ast.FunctionCall, 0)
+	seen := make(map[*ast.FunctionCall]bool, 0)
+	tree.ExecuteCustomTypeVisit(nodes, ast_pb.NodeType_FUNCTION_CALL, func(node ast.Node[ast.NodeType]) (bool, error) {
+		if call, ok := node.(*ast.FunctionCall); ok && !seen[call] && isStorageCall(call) {
+			seen[call] = true
+			calls = append(calls, call)
+		}
+		return true, nil
+	})
+	return calls
+}
+
+// containsStorageCall reports whether any call inside the nodes may touch storage
+func containsStorageCall(tree *ast.Tree, nodes ...ast.Node[ast.NodeType]) bool {
+	return len(storageCalls(tree, nodes...)) > 0
+}
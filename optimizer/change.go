@@ -0,0 +1,172 @@
+// Records what the passes changed, where and why
+package optimizer
+
+import (
+	"strings"
+
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/printer/ast_printer"
+	"go.uber.org/zap"
+)
+
+// Change is one rewrite made by a pass
+type Change struct {
+	Pass     string `json:"pass"`
+	Contract string `json:"contract"`
+	// Function is empty for changes to the declarations of the contract, such as its state variables
+	Function string `json:"function,omitempty"`
+	// File is the path of the source file the change is in, empty for code that was not read from a file
+	File string `json:"file,omitempty"`
+	// Src is the range of the rewritten code in the original file. Code added by a pass points at the code it was
+	// added next to, and code rewritten by an earlier round of the pipeline at the original code it came from.
+	Src SourceRange `json:"src"`
+	// Before is the original text of Src, After the code it was rewritten into. After is empty for removed code.
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	Rationale string `json:"rationale"`
+	// GasDelta is the estimated gas the rewrite adds, negative when it saves gas. It counts one call of the function
+	// with loops running DefaultGasModel.LoopIterations times, or the deployment for changes to the bytecode size.
+	GasDelta int `json:"gasDelta"`
+
+	// src is the range reported by the parser, which counts characters rather than bytes
+	src ast.SrcNode
+	// after are the nodes the code was rewritten into, rendered into After once the pass is done
+	after []ast.Node[ast.NodeType]
+}
+
+// SourceRange is a range of bytes in a source file, End excluded, with the line it starts on
+type SourceRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Line  int `json:"line"`
+}
+
+// Result holds the changes made by the passes an Optimizer ran, in the order they were made
+type Result struct {
+	Changes []Change `json:"changes"`
+}
+
+// Result returns the changes made so far
+func (o *Optimizer) Result() Result {
+	changes := make([]Change, len(o.changes))
+	copy(changes, o.changes)
+	return Result{Changes: changes}
+}
+
+// GasDelta returns the sum of the estimated gas deltas of the changes
+func (r Result) GasDelta() int {
+	total := 0
+	for _, change := range r.Changes {
+		total += change.GasDelta
+	}
+	return total
+}
+
+// newChange describes the rewrite of the code at src into after, in a function of the contract or in the contract
+// itself if function is empty. A nil after stands for removed code.
+func newChange(contract, function string, src ast.SrcNode, after ast.Node[ast.NodeType], rationale string, gasDelta int) Change {
+	change := Change{Contract: contract, Function: function, Rationale: rationale, GasDelta: gasDelta, src: src}
+	if !isNilNode(after) {
+		change.after = []ast.Node[ast.NodeType]{after}
+	}
+	return change
+}
+
+// insertionBefore returns the empty range right before the code at src, for code added in front of it
+func insertionBefore(src ast.SrcNode) ast.SrcNode {
+	return ast.SrcNode{Line: src.Line, Column: src.Column, Start: src.Start, End: src.Start - 1}
+}
+
+// insertionAtEnd returns the empty range right before the last character of the code at src,
+// for code added at the end of a block or a contract, before its closing brace
+func insertionAtEnd(src ast.SrcNode) ast.SrcNode {
+	return ast.SrcNode{Line: src.Line, Start: src.End, End: src.End - 1}
+}
+
+// functionName returns the name changes give to the function, modifier or constructor
+func functionName(node ast.Node[ast.NodeType]) string {
+	switch node := node.(type) {
+	case *ast.Constructor:
+		return "constructor"
+	case *ast.Fallback:
+		return "fallback"
+	case *ast.Receive:
+		return "receive"
+	case interface{ GetName() string }:
+		return node.GetName()
+	}
+	return ""
+}
+
+// printNode prints the node on its own, without the indentation of its place in the file
+func printNode(node ast.Node[ast.NodeType]) string {
+	printed, ok := ast_printer.Print(node)
+	if !ok {
+		zap.L().Debug("Failed to print node", zap.Int64("id", node.GetId()))
+	}
+	return strings.TrimSpace(printed)
+}
+
+// record fills in the pass name, source range, original text and rewritten text of the changes made by the pass,
+// and adds them to the result
+func (o *Optimizer) record(pass string, changes []Change) []Change {
+	for i := range changes {
+		change := &changes[i]
+		change.Pass = pass
+		if file := o.fileAt(change.src.Start); file != nil {
+			src := file.local(change.src)
+			if start, end, ok := byteRange(file.content, src); ok {
+				change.File = file.path
+				change.Src = SourceRange{Start: start, End: end, Line: int(src.Line)}
+				change.Before = file.content[start:end]
+				change.After = o.snippet(file, change.after, start)
+			}
+		}
+		zap.L().Debug("Recorded change", zap.String("pass", pass), zap.String("contract", change.Contract),
+			zap.String("function", change.Function), zap.String("rationale", change.Rationale), zap.Int("gas delta", change.GasDelta))
+	}
+	o.changes = append(o.changes, changes...)
+	return changes
+}
+
+// snippet returns the text the nodes of a change put in the file at offset, one statement per line, as Edits writes
+// it: the code kept from the file has its original text with the edits made to it, only the code made by a pass
+// is printed
+func (o *Optimizer) snippet(file *sourceFile, nodes []ast.Node[ast.NodeType], offset int) string {
+	texts := make([]string, 0, len(nodes))
+	indent := ""
+	for _, node := range nodes {
+		// a renderer renders every node once, so each node gets its own
+		r := newRenderer(o.original, file)
+		indent = r.lineIndent(offset)
+		texts = append(texts, r.textOf(node, len(nodes) > 1, indent))
+	}
+	return strings.Join(texts, "\n"+indent)
+}
+
+// byteRange converts the character range of src, whose end is included, into a range of bytes of the content.
+// An end right before the start is an empty range. It returns false for nodes made by a pass, which have no range.
+func byteRange(content string, src ast.SrcNode) (int, int, bool) {
+	if src.End < src.Start-1 || (src.Start == 0 && src.End == 0) {
+		return 0, 0, false
+	}
+	start, end := -1, -1
+	char := int64(0)
+	for offset := range content {
+		if char == src.Start {
+			start = offset
+		}
+		if char == src.End+1 {
+			end = offset
+			break
+		}
+		char++
+	}
+	if end < 0 && char == src.End+1 {
+		end = len(content)
+	}
+	if start < 0 || end < 0 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
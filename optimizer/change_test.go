@@ -0,0 +1,129 @@
+// test file for change.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const changesContract = `
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+// non-ASCII text such as café moves the byte offsets past the character offsets
+contract Changes {
+    uint256 public count;
+
+    function bump(uint256 times) public {
+        require(times > 0, "no times");
+        count++;
+        for (uint256 i = 0; i < times; i++) {
+            count += i;
+        }
+    }
+}
+`
+
+func TestChanges(t *testing.T) {
+	builder := setUpBuilder(t, changesContract)
+	opt := optimizer.NewOptimizer(builder)
+
+	changes := opt.UsePrefixIncrements()
+	assert.Len(t, changes, 2)
+	for _, change := range changes {
+		assert.Equal(t, "prefix-increments", change.Pass)
+		assert.Equal(t, "Changes", change.Contract)
+		assert.Equal(t, "bump", change.Function)
+		assert.NotEmpty(t, change.Rationale)
+		assert.Negative(t, change.GasDelta)
+		// the range points at the original text
+		assert.Equal(t, change.Before, changesContract[change.Src.Start:change.Src.End])
+	}
+	assert.Equal(t, "count++", changes[0].Before)
+	assert.Equal(t, "++count", changes[0].After)
+	assert.Equal(t, 11, changes[0].Src.Line)
+
+	// changes made before are still reported against the original text
+	changes = opt.UseCustomErrors()
+	assert.Len(t, changes, 2)
+	assert.Equal(t, `require(times > 0, "no times")`, changes[0].Before)
+	assert.Contains(t, changes[0].After, "revert NoTimes()")
+	// the declaration of the error is added before the first function
+	assert.Empty(t, changes[1].Function)
+	assert.Empty(t, changes[1].Before)
+	assert.Equal(t, changes[1].Src.Start, changes[1].Src.End)
+	assert.Equal(t, "error NoTimes();", changes[1].After)
+
+	result := opt.Result()
+	assert.Len(t, result.Changes, 4)
+	total := 0
+	for _, change := range result.Changes {
+		total += change.GasDelta
+	}
+	assert.Equal(t, total, result.GasDelta())
+}
+
+func TestChangesOfPipeline(t *testing.T) {
+	builder := setUpBuilder(t, changesContract)
+	opt := optimizer.NewOptimizer(builder)
+	passes, _ := optimizer.LevelPasses(1)
+	_, err := opt.RunPipeline(passes, optimizer.LevelOptions(1), 0)
+	assert.NoError(t, err)
+
+	// a change for every pass that changed the code, in the order they ran
+	names := make([]string, 0)
+	for _, change := range opt.Result().Changes {
+		names = append(names, change.Pass)
+	}
+	assert.Equal(t, []string{"prefix-increments", "prefix-increments", "unchecked-loop-increments"}, names)
+}
+
+const snippetsContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Snippets {
+    function cube(uint256 x) public pure returns (uint256) {
+        return x ** 3 + 1;
+    }
+
+    function sum(uint256 n) public pure returns (uint256 total) {
+        for (uint256 i = 0; i < n; ++i) {
+            total += i ** 2;
+        }
+    }
+}
+`
+
+func TestChangeSnippets(t *testing.T) {
+	builder := setUpBuilder(t, snippetsContract)
+	opt := optimizer.NewOptimizer(builder)
+
+	// the exponentiations the printer can not print keep their original text
+	changes := opt.PromoteExternalFunctions()
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, `function cube(uint256 x) external pure returns (uint256) {
+        return x ** 3 + 1;
+    }`, changes[0].After)
+	}
+	changes = opt.ExpandExponentiation(0)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "x ** 3", changes[0].Before)
+		assert.Equal(t, "(x * x * x)", changes[0].After)
+	}
+
+	// the snippet is the text of the edit, at the indentation of the file
+	changes = opt.UncheckLoopIncrements()
+	if assert.Len(t, changes, 1) {
+		code, err := opt.Edits()[0].Apply()
+		assert.NoError(t, err)
+		assert.Contains(t, code, changes[0].After)
+		assert.Equal(t, `for (uint256 i = 0; i < n;) {
+            total += i * i;
+            unchecked {
+                ++i;
+            }
+        }`, changes[0].After)
+	}
+}
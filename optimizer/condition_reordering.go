@@ -0,0 +1,266 @@
+// Reorders the operands of && and || so the cheapest ones are evaluated first
+package optimizer
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// rough gas costs of evaluating an operand, only their order matters
+const (
+	stackReadCost    = 3
+	environmentCost  = 2
+	storageReadCost  = 2100
+	keccakCost       = 42
+	balanceCost      = 2600
+	internalCallCost = 100
+	externalCallCost = 2600
+)
+
+func (o *Optimizer) optimizeConditionOrdering() []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	for _, contract := range o.builder.GetRoot().GetContracts() {
+		if !o.rewritable(contract) {
+			continue
+		}
+		visible := make(map[string]*ast.StateVariableDeclaration, 0)
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			visible = visibleStateVariables(tree, astContract)
+		}
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil {
+				continue
+			}
+			r := &conditionReorderer{tree: tree, visible: visible, locals: localNames(fn)}
+			// the inner operations of a chain are reordered together with its outermost one
+			inChain := make(map[ast.Node[ast.NodeType]]bool, 0)
+			walk(fn.GetBody(), func(node ast.Node[ast.NodeType]) bool {
+				if isShortCircuit(node) && !inChain[node] {
+					operations, operands := shortCircuitChain(node)
+					for _, operation := range operations {
+						inChain[operation] = true
+					}
+					if gasDelta, ok := r.reorder(operations, operands); ok {
+						changes = append(changes, newChange(contract.GetName(), fn.GetName(), node.GetSrc(), node,
+							"the cheapest operands are evaluated first, so the expensive ones are skipped more often", gasDelta))
+					}
+				}
+				return true
+			})
+		}
+	}
+	return changes
+}
+
+// conditionReorderer estimates the cost of the operands inside one function
+type conditionReorderer struct {
+	tree    *ast.Tree
+	visible map[string]*ast.StateVariableDeclaration
+	locals  map[string]bool
+}
+
+// isShortCircuit reports whether the node is a && or || operation
+func isShortCircuit(node ast.Node[ast.NodeType]) bool {
+	switch node := node.(type) {
+	case *ast.AndOperation:
+		return len(node.GetExpressions()) == 2
+	case *ast.BinaryOperation:
+		return node.GetOperator() == ast_pb.Operator_OR
+	}
+	return false
+}
+
+// sameOperator reports whether both nodes are && operations or both are || operations
+func sameOperator(a, b ast.Node[ast.NodeType]) bool {
+	return isShortCircuit(a) && isShortCircuit(b) && a.GetType() == b.GetType()
+}
+
+// operandsOf returns the left and right operand of a && or || operation
+func operandsOf(node ast.Node[ast.NodeType]) (ast.Node[ast.NodeType], ast.Node[ast.NodeType]) {
+	if and, ok := node.(*ast.AndOperation); ok {
+		return and.Expressions[0], and.Expressions[1]
+	}
+	or := node.(*ast.BinaryOperation)
+	return or.LeftExpression, or.RightExpression
+}
+
+// setOperands replaces the left and right operand of a && or || operation
+func setOperands(node ast.Node[ast.NodeType], left, right ast.Node[ast.NodeType]) {
+	if and, ok := node.(*ast.AndOperation); ok {
+		and.Expressions[0], and.Expressions[1] = left, right
+		return
+	}
+	or := node.(*ast.BinaryOperation)
+	or.LeftExpression, or.RightExpression = left, right
+}
+
+// shortCircuitChain flattens `a && b && c` into its operations, outermost first, and its operands in evaluation order.
+// Operands in parentheses are tuples and stay a single operand.
+func shortCircuitChain(root ast.Node[ast.NodeType]) ([]ast.Node[ast.NodeType], []ast.Node[ast.NodeType]) {
+	operations := make([]ast.Node[ast.NodeType], 0)
+	operands := make([]ast.Node[ast.NodeType], 0)
+	var flatten func(node ast.Node[ast.NodeType])
+	flatten = func(node ast.Node[ast.NodeType]) {
+		if !sameOperator(root, node) {
+			operands = append(operands, node)
+			return
+		}
+		operations = append(operations, node)
+		left, right := operandsOf(node)
+		flatten(left)
+		flatten(right)
+	}
+	flatten(root)
+	return operations, operands
+}
+
+// reorder sorts the operands of a chain by cost and rebuilds it left to right with the same operations.
+// An operand that may revert or has side effects keeps its place, and nothing is moved across it:
+// skipping it or evaluating it where it was skipped before would change what the condition does.
+// Returns the change in the expected cost of the chain, or false if it is already in order.
+func (r *conditionReorderer) reorder(operations []ast.Node[ast.NodeType], operands []ast.Node[ast.NodeType]) (int, bool) {
+	costs := make(map[ast.Node[ast.NodeType]]int, len(operands))
+	for _, operand := range operands {
+		costs[operand] = r.cost(operand)
+	}
+	sorted := make([]ast.Node[ast.NodeType], len(operands))
+	copy(sorted, operands)
+	for start := 0; start < len(sorted); start++ {
+		if !r.canReorder(sorted[start]) {
+			continue
+		}
+		end := start
+		for end < len(sorted) && r.canReorder(sorted[end]) {
+			end++
+		}
+		run := sorted[start:end]
+		sort.SliceStable(run, func(i, j int) bool {
+			return costs[run[i]] < costs[run[j]]
+		})
+		start = end
+	}
+
+	changed := false
+	for i := range operands {
+		changed = changed || operands[i] != sorted[i]
+	}
+	if !changed {
+		return 0, false
+	}
+	// the outermost operation stays where its parent points to, and gets the last operand
+	current := sorted[0]
+	for i := 1; i < len(sorted); i++ {
+		operation := operations[len(sorted)-1-i]
+		setOperands(operation, current, sorted[i])
+		current = operation
+	}
+	return expectedCost(sorted, costs) - expectedCost(operands, costs), true
+}
+
+// expectedCost estimates the cost of evaluating the operands of a chain in order,
+// where every operand is skipped with the chance that an earlier one decides the condition
+func expectedCost(operands []ast.Node[ast.NodeType], costs map[ast.Node[ast.NodeType]]int) int {
+	expected := 0.0
+	evaluated := 1.0
+	for _, operand := range operands {
+		expected += evaluated * float64(costs[operand])
+		evaluated *= DefaultGasModel.BranchProbability
+	}
+	return int(math.Round(expected))
+}
+
+// canReorder reports whether the operand can be evaluated earlier or later, or skipped, without any visible
+// difference. It may only read values and compare them: calls, checked arithmetic and array indexing can
+// revert, and assignments write.
+func (r *conditionReorderer) canReorder(operand ast.Node[ast.NodeType]) bool {
+	ok := true
+	walk(operand, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.PrimaryExpression, *ast.MemberAccessExpression, *ast.TupleExpression, *ast.AndOperation, *ast.TypeName:
+		case *ast.BinaryOperation:
+			switch node.GetOperator() {
+			case ast_pb.Operator_EQUAL, ast_pb.Operator_NOT_EQUAL, ast_pb.Operator_GREATER_THAN, ast_pb.Operator_GREATER_THAN_OR_EQUAL,
+				ast_pb.Operator_LESS_THAN, ast_pb.Operator_LESS_THAN_OR_EQUAL, ast_pb.Operator_OR:
+			default:
+				ok = false
+			}
+		case *ast.UnaryPrefix:
+			ok = node.GetOperator() == ast_pb.Operator_NOT
+		case *ast.IndexAccess:
+			// a missing mapping key reads as zero, an index out of bounds reverts
+			ok = isMapping(node.GetBaseExpression())
+		case *ast.FunctionCall:
+			// conversions between elementary types such as address(0) never revert
+			ok = isConversion(node)
+		default:
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+// isMapping reports whether the expression has a mapping type
+func isMapping(node ast.Node[ast.NodeType]) bool {
+	td := node.GetTypeDescription()
+	return td != nil && strings.HasPrefix(td.GetString(), "mapping(")
+}
+
+// isConversion reports whether the call converts a value to an elementary type, such as uint8(x) or address(x)
+func isConversion(call *ast.FunctionCall) bool {
+	ident, ok := call.GetExpression().(*ast.PrimaryExpression)
+	if !ok || len(call.GetArguments()) != 1 {
+		return false
+	}
+	_, ok = sizeMap[ident.GetName()]
+	return ok
+}
+
+// cost estimates the gas needed to evaluate the operand, counting a cold read for every storage access
+func (r *conditionReorderer) cost(node ast.Node[ast.NodeType]) int {
+	if isNilNode(node) {
+		return 0
+	}
+	switch node := node.(type) {
+	case *ast.PrimaryExpression:
+		switch {
+		case node.GetType() == ast_pb.NodeType_LITERAL:
+			return 0
+		case node.GetName() == "msg" || node.GetName() == "block" || node.GetName() == "tx" || node.GetName() == "this":
+			return environmentCost
+		case readsStorage(r.tree, node, r.visible, r.locals):
+			return storageReadCost
+		}
+		return stackReadCost
+	case *ast.MemberAccessExpression:
+		if node.GetMemberName() == "balance" {
+			return r.cost(node.GetExpression()) + balanceCost
+		}
+		return r.cost(node.GetExpression())
+	case *ast.IndexAccess:
+		return r.cost(node.GetBaseExpression()) + r.cost(node.GetIndexExpression()) + keccakCost
+	case *ast.FunctionCall:
+		callCost := internalCallCost
+		switch {
+		case isConversion(node):
+			callCost = 0
+		case isExternalCall(node):
+			callCost = externalCallCost
+		}
+		for _, arg := range node.GetArguments() {
+			callCost += r.cost(arg)
+		}
+		return callCost
+	}
+	total := stackReadCost
+	for _, child := range node.GetNodes() {
+		total += r.cost(child)
+	}
+	return total
+}
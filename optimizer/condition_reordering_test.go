@@ -0,0 +1,70 @@
+// test file for condition_reordering.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/printer/ast_printer"
+)
+
+const conditionContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Vault {
+    uint256 public total;
+    uint256[] public amounts;
+    mapping(address => bool) public allowed;
+    uint256 constant LIMIT = 10;
+
+    function storageFirst(uint256 n, bool ok) public view returns (bool) {
+        return total > n && n < LIMIT && ok;
+    }
+
+    function allowedOrEmpty(address to, bool ok) public view returns (bool) {
+        return allowed[to] || to == address(0) || (ok && total > 0);
+    }
+
+    function checked(uint256 n, bool ok) public view returns (bool) {
+        return total + n > 10 && ok;
+    }
+
+    function fromArray(uint256 n, bool ok) public view returns (bool) {
+        return amounts[n] > 0 && ok;
+    }
+
+    function aroundCall(uint256 n, bool ok) public view returns (bool) {
+        return total > n && isLarge(n) && total > 1 && ok;
+    }
+
+    function isLarge(uint256 n) internal pure returns (bool) {
+        return n > 100;
+    }
+}
+`
+
+// prints the expression returned by the function
+func printReturn(t *testing.T, fn *ast.Function) string {
+	statements := fn.GetBody().GetStatements()
+	ret, ok := statements[len(statements)-1].(*ast.ReturnStatement)
+	assert.True(t, ok)
+	s, ok := ast_printer.Print(ret.GetExpression())
+	assert.True(t, ok)
+	return s
+}
+
+func TestReorderConditions(t *testing.T) {
+	builder := setUpBuilder(t, conditionContract)
+	optimizer.NewOptimizer(builder).ReorderConditions()
+
+	assert.Equal(t, "ok && n < LIMIT && total > n", printReturn(t, findFunction(builder, "Vault", "storageFirst")))
+	// parenthesised operands are reordered on their own
+	assert.Equal(t, "to == address(0) || (ok && total > 0) || allowed[to]", printReturn(t, findFunction(builder, "Vault", "allowedOrEmpty")))
+	// checked arithmetic and array indexing may revert
+	assert.Equal(t, "total + n > 10 && ok", printReturn(t, findFunction(builder, "Vault", "checked")))
+	assert.Equal(t, "amounts[n] > 0 && ok", printReturn(t, findFunction(builder, "Vault", "fromArray")))
+	// nothing moves across a call
+	assert.Equal(t, "total > n && isLarge(n) && ok && total > 1", printReturn(t, findFunction(builder, "Vault", "aroundCall")))
+}
@@ -0,0 +1,272 @@
+// Turns state variables that are never written after construction into constants and immutables
+package optimizer
+
+import (
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+// immutable variables were added in 0.6.5
+var immutableVersion = solidityVersion{major: 0, minor: 6, patch: 5}
+
+func (o *Optimizer) optimizeConstantPromotion() []Change {
+	changes := make([]Change, 0)
+	// a read of a constant or an immutable is a push instead of a cold storage read
+	gasDelta := DefaultGasModel.StackRead - DefaultGasModel.ColdSload
+	tree := o.builder.GetAstBuilder().GetTree()
+	irContracts := make(map[int64]*ir.Contract, 0)
+	contracts := make([]*ast.Contract, 0)
+	for _, contract := range o.builder.GetRoot().GetContracts() {
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			irContracts[astContract.GetId()] = contract
+			contracts = append(contracts, astContract)
+		}
+	}
+	derived := derivedContracts(tree, contracts)
+
+	for _, contract := range contracts {
+		if !o.rewritable(irContracts[contract.GetId()]) {
+			continue
+		}
+		p := &constantPromoter{
+			contract: contract,
+			derived:  derived[contract.GetId()],
+			tree:     tree,
+			visible:  visibleStateVariables(tree, contract),
+		}
+		if p.hasAssembly() {
+			continue
+		}
+		p.countWrites()
+
+		candidates := make([]*ast.StateVariableDeclaration, 0)
+		for _, node := range contract.GetNodes() {
+			sv, ok := node.(*ast.StateVariableDeclaration)
+			// immutable variables can only hold value types, and constants are kept to the same
+			if !ok || !usesStorage(sv) || sv.GetTypeName() == nil {
+				continue
+			}
+			if _, ok := sizeMap[sv.GetTypeName().GetName()]; !ok || p.otherWrites[sv.GetName()] > 0 {
+				continue
+			}
+			candidates = append(candidates, sv)
+		}
+
+		// constants first, so immutable initialisers may read the new constants
+		for _, sv := range candidates {
+			if p.constructorWrites[sv.GetName()] == 0 && isLiteral(sv.GetInitialValue()) {
+				zap.L().Info("Making state variable constant", zap.String("contract", contract.GetName()), zap.String("variable", sv.GetName()))
+				sv.Constant = true
+				changes = append(changes, newChange(contract.GetName(), "", sv.GetSrc(), sv,
+					"the state variable only gets a literal in its declaration, so it can be a constant", gasDelta))
+			}
+		}
+		if !requiresSolidity(irContracts[contract.GetId()], immutableVersion) {
+			continue
+		}
+		for _, sv := range candidates {
+			if !sv.IsConstant() && p.canBeImmutable(sv) {
+				zap.L().Info("Making state variable immutable", zap.String("contract", contract.GetName()), zap.String("variable", sv.GetName()))
+				sv.StateMutability = ast_pb.Mutability_IMMUTABLE
+				changes = append(changes, newChange(contract.GetName(), "", sv.GetSrc(), sv,
+					"the state variable is only written during construction, so it can be immutable", gasDelta))
+			}
+		}
+	}
+	return changes
+}
+
+// constantPromoter holds what is known about the writes to the state variables of a contract
+type constantPromoter struct {
+	contract *ast.Contract
+	// the contract itself and every contract that inherits from it, which can all write its state variables
+	derived []*ast.Contract
+	tree    *ast.Tree
+	visible map[string]*ast.StateVariableDeclaration
+	// writes by name, inside the constructor of the contract and anywhere else
+	constructorWrites map[string]int
+	otherWrites       map[string]int
+}
+
+// derivedContracts returns, for every contract id, the contract itself and the contracts that inherit from it
+func derivedContracts(tree *ast.Tree, contracts []*ast.Contract) map[int64][]*ast.Contract {
+	derived := make(map[int64][]*ast.Contract, 0)
+	for _, contract := range contracts {
+		for _, base := range inheritedContracts(tree, contract) {
+			derived[base.GetId()] = append(derived[base.GetId()], contract)
+		}
+	}
+	return derived
+}
+
+// constructorOf returns the constructor of the contract, or nil if it has none
+func constructorOf(contract *ast.Contract) *ast.Constructor {
+	for _, node := range contract.GetNodes() {
+		if ctor, ok := node.(*ast.Constructor); ok {
+			return ctor
+		}
+	}
+	return nil
+}
+
+// hasAssembly reports whether any of the contracts uses assembly, which can write to any storage slot
+func (p *constantPromoter) hasAssembly() bool {
+	found := false
+	for _, contract := range p.derived {
+		walk(contract, func(node ast.Node[ast.NodeType]) bool {
+			if node.GetType() == ast_pb.NodeType_ASSEMBLY_STATEMENT {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// countWrites counts the writes to every name, telling the ones in the constructor of the contract apart.
+// Locals with the name of a state variable are counted as well, which only makes the pass more careful.
+func (p *constantPromoter) countWrites() {
+	p.constructorWrites = make(map[string]int, 0)
+	p.otherWrites = make(map[string]int, 0)
+	inConstructor := make(map[*ast.PrimaryExpression]bool, 0)
+	if ctor := constructorOf(p.contract); ctor != nil {
+		for ident := range writtenIdentifiers(ctor) {
+			inConstructor[ident] = true
+			p.constructorWrites[ident.GetName()]++
+		}
+	}
+	for _, contract := range p.derived {
+		for ident := range writtenIdentifiers(contract) {
+			if !inConstructor[ident] {
+				p.otherWrites[ident.GetName()]++
+			}
+		}
+	}
+}
+
+// isLiteral reports whether the value is a number or boolean literal
+func isLiteral(node ast.Node[ast.NodeType]) bool {
+	literal, ok := node.(*ast.PrimaryExpression)
+	if !ok || isNilNode(literal) {
+		return false
+	}
+	return literal.GetKind() == ast_pb.NodeType_NUMBER || literal.GetKind() == ast_pb.NodeType_BOOLEAN
+}
+
+// canBeImmutable checks that the state variable gets its value exactly once during construction and is not read
+// before that. It is either initialised in its declaration, or assigned by a top level statement of the constructor.
+// Immutable variables cannot be read while the contract is being created before 0.8.21, so the variable must not be
+// read by any constructor, by anything they call or by the initialiser of another state variable.
+func (p *constantPromoter) canBeImmutable(sv *ast.StateVariableDeclaration) bool {
+	name := sv.GetName()
+	writes := p.constructorWrites[name]
+	var assigned ast.Node[ast.NodeType]
+	switch {
+	case writes == 0 && sv.GetInitialValue() != nil:
+		assigned = sv.GetInitialValue()
+	case writes == 1 && sv.GetInitialValue() == nil:
+		assignment := p.constructorAssignment(name)
+		if assignment == nil {
+			return false
+		}
+		assigned = assignment.GetRightExpression()
+	default:
+		return false
+	}
+	if !p.readsOnlyConstants(assigned) {
+		return false
+	}
+
+	for _, contract := range p.derived {
+		for _, node := range contract.GetNodes() {
+			switch node := node.(type) {
+			case *ast.StateVariableDeclaration:
+				if node != sv && !isNilNode(node.GetInitialValue()) && countIdentifiers(node.GetInitialValue(), name) > 0 {
+					return false
+				}
+			case *ast.Constructor:
+				if !p.isSimpleConstructor(node) {
+					return false
+				}
+				// the only mention allowed is the assignment itself
+				mentions := countIdentifiers(node, name)
+				if contract == p.contract && writes == 1 {
+					mentions--
+				}
+				if mentions > 0 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// constructorAssignment returns the `name = value` assignment if it is a top level statement of the constructor
+func (p *constantPromoter) constructorAssignment(name string) *ast.Assignment {
+	ctor := constructorOf(p.contract)
+	if ctor == nil || ctor.GetBody() == nil {
+		return nil
+	}
+	for _, stmt := range ctor.GetBody().GetStatements() {
+		assignment, ok := stmt.(*ast.Assignment)
+		if !ok {
+			continue
+		}
+		// an assignment statement wraps the assignment expression
+		if inner, ok := assignment.GetExpression().(*ast.Assignment); ok {
+			assignment = inner
+		}
+		ident, ok := assignment.GetLeftExpression().(*ast.PrimaryExpression)
+		if ok && ident.GetName() == name && assignment.GetOperator() == ast_pb.Operator_EQUAL {
+			return assignment
+		}
+	}
+	return nil
+}
+
+// isSimpleConstructor reports whether the constructor runs no code of the contract other than its own body.
+// Modifiers and internal calls could read an immutable variable before it is assigned, while calls to the
+// constructors of the base contracts run before the body anyway.
+func (p *constantPromoter) isSimpleConstructor(ctor *ast.Constructor) bool {
+	bases := make(map[string]bool, 0)
+	for _, base := range inheritedContracts(p.tree, p.contract) {
+		if named, ok := base.(interface{ GetName() string }); ok {
+			bases[named.GetName()] = true
+		}
+	}
+	for _, modifier := range ctor.GetModifiers() {
+		if !bases[modifier.GetName()] {
+			return false
+		}
+	}
+	return ctor.GetBody() == nil || !containsStorageCall(p.tree, ctor.GetBody())
+}
+
+// readsOnlyConstants reports whether every state variable the expression reads is constant
+func (p *constantPromoter) readsOnlyConstants(node ast.Node[ast.NodeType]) bool {
+	ok := true
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if ident, isIdent := n.(*ast.PrimaryExpression); isIdent {
+			if sv, isState := p.visible[ident.GetName()]; isState && !sv.IsConstant() {
+				ok = false
+			}
+		}
+		return ok
+	})
+	return ok
+}
+
+// countIdentifiers counts the identifiers with the name inside the node
+func countIdentifiers(node ast.Node[ast.NodeType], name string) int {
+	count := 0
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if ident, ok := n.(*ast.PrimaryExpression); ok && ident.GetType() == ast_pb.NodeType_IDENTIFIER && ident.GetName() == name {
+			count++
+		}
+		return true
+	})
+	return count
+}
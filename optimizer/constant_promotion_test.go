@@ -0,0 +1,110 @@
+// test file for constant_promotion.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const constantContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Token {
+    uint256 public decimals = 18;
+    uint256 public fee = 3;
+    bool public paused = false;
+    address public owner;
+    address public creator = msg.sender;
+    uint256 public cap;
+    uint256 public limit;
+    uint256 public supply;
+
+    constructor(uint256 _cap, uint256 _limit) {
+        owner = msg.sender;
+        cap = _cap;
+        if (_limit > 0) {
+            limit = _limit;
+        }
+        supply = _cap;
+        supply = supply / 2;
+    }
+
+    function setFee(uint256 _fee) public {
+        fee = _fee;
+    }
+}
+
+contract PausableToken is Token {
+    constructor() Token(100, 10) {}
+
+    function pause() public {
+        paused = true;
+    }
+}
+`
+
+const oldConstantContract = `// SPDX-License-Identifier: MIT
+pragma solidity >=0.6.0 <0.9.0;
+
+contract Token {
+    uint256 public decimals = 18;
+    address public owner;
+
+    constructor() public {
+        owner = msg.sender;
+    }
+}
+`
+
+// finds the state variable declared in the contract
+func findStateVariable(builder *ir.Builder, contractName string, name string) *ast.StateVariableDeclaration {
+	for _, contract := range builder.GetRoot().GetContracts() {
+		if contract.GetName() != contractName {
+			continue
+		}
+		for _, node := range contract.GetAST().GetContract().GetNodes() {
+			if sv, ok := node.(*ast.StateVariableDeclaration); ok && sv.GetName() == name {
+				return sv
+			}
+		}
+	}
+	return nil
+}
+
+func isImmutable(sv *ast.StateVariableDeclaration) bool {
+	return sv.GetStateMutability() == ast_pb.Mutability_IMMUTABLE
+}
+
+func TestPromoteConstants(t *testing.T) {
+	builder := setUpBuilder(t, constantContract)
+	optimizer.NewOptimizer(builder).PromoteConstants()
+
+	assert.True(t, findStateVariable(builder, "Token", "decimals").IsConstant())
+	// written in a function
+	assert.False(t, findStateVariable(builder, "Token", "fee").IsConstant())
+	// written in a derived contract
+	assert.False(t, findStateVariable(builder, "Token", "paused").IsConstant())
+
+	assert.True(t, isImmutable(findStateVariable(builder, "Token", "owner")))
+	assert.True(t, isImmutable(findStateVariable(builder, "Token", "creator")))
+	assert.True(t, isImmutable(findStateVariable(builder, "Token", "cap")))
+	// only assigned in a branch
+	assert.False(t, isImmutable(findStateVariable(builder, "Token", "limit")))
+	// assigned twice and read in the constructor
+	assert.False(t, isImmutable(findStateVariable(builder, "Token", "supply")))
+	assert.False(t, isImmutable(findStateVariable(builder, "Token", "fee")))
+}
+
+func TestPromoteConstantsOldPragma(t *testing.T) {
+	builder := setUpBuilder(t, oldConstantContract)
+	optimizer.NewOptimizer(builder).PromoteConstants()
+
+	// constants work with any version, immutable variables need 0.6.5
+	assert.True(t, findStateVariable(builder, "Token", "decimals").IsConstant())
+	assert.False(t, isImmutable(findStateVariable(builder, "Token", "owner")))
+}
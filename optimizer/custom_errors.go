@@ -0,0 +1,280 @@
+// Replaces require and revert messages with custom errors declared in the contract
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+// custom errors were added in 0.8.4
+var customErrorsVersion = solidityVersion{major: 0, minor: 8, patch: 4}
+
+func (o *Optimizer) optimizeCustomErrors() []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	irContracts := make(map[int64]*ir.Contract, 0)
+	contracts := make([]*ast.Contract, 0)
+	for _, contract := range o.builder.GetRoot().GetContracts() {
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			irContracts[astContract.GetId()] = contract
+			contracts = append(contracts, astContract)
+		}
+	}
+	derived := derivedContracts(tree, contracts)
+
+	// bases first, so derived contracts reuse the errors generated for their bases
+	depth := make(map[int64]int, 0)
+	for _, contract := range contracts {
+		depth[contract.GetId()] = len(inheritedContracts(tree, contract))
+	}
+	sort.SliceStable(contracts, func(i, j int) bool {
+		return depth[contracts[i].GetId()] < depth[contracts[j].GetId()]
+	})
+
+	generated := make(map[int64]map[string]*ast.ErrorDefinition, 0)
+	for _, contract := range contracts {
+		if !o.rewritable(irContracts[contract.GetId()]) {
+			continue
+		}
+		if !requiresSolidity(irContracts[contract.GetId()], customErrorsVersion) {
+			zap.L().Info("Skipping contract, its pragma allows compilers older than 0.8.4", zap.String("contract", contract.GetName()))
+			continue
+		}
+		m := &errorMigrator{
+			builder:  o.builder.GetAstBuilder(),
+			contract: contract,
+			errors:   make(map[string]*ast.ErrorDefinition, 0),
+			// the built in errors cannot be redeclared
+			reserved: map[string]bool{"Error": true, "Panic": true},
+		}
+		for _, base := range inheritedContracts(tree, contract)[1:] {
+			for message, definition := range generated[base.GetId()] {
+				m.errors[message] = definition
+			}
+		}
+		// a new error must not clash with anything declared by the bases or by the contracts inheriting it
+		m.reserve(inheritedContracts(tree, contract)...)
+		for _, c := range derived[contract.GetId()] {
+			m.reserve(c)
+		}
+		changes = append(changes, m.migrate()...)
+		generated[contract.GetId()] = m.errors
+	}
+	return changes
+}
+
+// errorMigrator rewrites the revert messages of one contract
+type errorMigrator struct {
+	builder  *ast.ASTBuilder
+	contract *ast.Contract
+	// the error generated for every message, including the ones inherited from the bases
+	errors map[string]*ast.ErrorDefinition
+	// names already declared, which the generated errors must avoid
+	reserved map[string]bool
+	// errors generated for this contract, in the order of their first use
+	declared []*ast.ErrorDefinition
+}
+
+// reserve marks the names of the contracts and of everything they declare as taken
+func (m *errorMigrator) reserve(contracts ...ast.Node[ast.NodeType]) {
+	for _, contract := range contracts {
+		if named, ok := contract.(interface{ GetName() string }); ok {
+			m.reserved[named.GetName()] = true
+		}
+		for _, node := range contract.GetNodes() {
+			if named, ok := node.(interface{ GetName() string }); ok {
+				m.reserved[named.GetName()] = true
+			}
+		}
+	}
+}
+
+// migrate rewrites `require(cond, "message")` into `if (!cond) revert Message();` and
+// `revert("message")` into `revert Message();`, then declares the new errors in the contract.
+// Only calls that are statements of their own and have a literal message are rewritten.
+// Returns a change for every call rewritten and every error declared.
+func (m *errorMigrator) migrate() []Change {
+	changes := make([]Change, 0)
+	// the message no longer has to be deployed with the code
+	rewritten := func(function string, call *ast.FunctionCall, message string, replacement ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+		changes = append(changes, newChange(m.contract.GetName(), function, call.GetSrc(), replacement,
+			"a custom error is cheaper to deploy and to revert with than a revert message", -codeDepositCost*len(message)))
+		return replacement
+	}
+	for _, declaration := range m.contract.GetNodes() {
+		function := functionName(declaration)
+		m.migrateDeclaration(declaration, func(call *ast.FunctionCall, message string, replacement ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+			return rewritten(function, call, message, replacement)
+		})
+	}
+	if len(m.declared) == 0 {
+		return changes
+	}
+
+	// declare the errors before the first function, after the state variables, events and existing errors
+	position := len(m.contract.Nodes)
+	for i := len(m.contract.Nodes) - 1; i >= 0; i-- {
+		switch m.contract.Nodes[i].(type) {
+		case *ast.Function, *ast.Constructor, *ast.ModifierDefinition, *ast.Fallback, *ast.Receive:
+			position = i
+		}
+	}
+	src := insertionAtEnd(m.contract.GetSrc())
+	if position < len(m.contract.Nodes) {
+		src = insertionBefore(m.contract.Nodes[position].GetSrc())
+	}
+	nodes := make([]ast.Node[ast.NodeType], 0, len(m.contract.Nodes)+len(m.declared))
+	nodes = append(nodes, m.contract.Nodes[:position]...)
+	for _, definition := range m.declared {
+		nodes = append(nodes, definition)
+		changes = append(changes, newChange(m.contract.GetName(), "", src, definition, "declares the error used instead of a revert message", 0))
+	}
+	m.contract.Nodes = append(nodes, m.contract.Nodes[position:]...)
+	return changes
+}
+
+// migrateDeclaration rewrites the require and revert calls of a function, modifier or constructor,
+// passing every call to rewritten together with its message and replacement
+func (m *errorMigrator) migrateDeclaration(declaration ast.Node[ast.NodeType], rewritten func(call *ast.FunctionCall, message string, replacement ast.Node[ast.NodeType]) ast.Node[ast.NodeType]) {
+	rewriteNodes(declaration, func(node ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+		call, ok := node.(*ast.FunctionCall)
+		if !ok {
+			return node
+		}
+		if _, isStatement := parent.(*ast.BodyNode); !isStatement {
+			return node
+		}
+		ident, ok := call.GetExpression().(*ast.PrimaryExpression)
+		if !ok {
+			return node
+		}
+		args := call.GetArguments()
+		switch {
+		case ident.GetName() == "require" && len(args) == 2:
+			message, ok := stringLiteral(args[1])
+			if !ok {
+				return node
+			}
+			zap.L().Info("Replacing require message with a custom error", zap.String("contract", m.contract.GetName()), zap.String("message", message))
+			return rewritten(call, message, m.revertUnless(args[0], m.errorFor(message)))
+		case ident.GetName() == "revert" && len(args) == 1:
+			message, ok := stringLiteral(args[0])
+			if !ok {
+				return node
+			}
+			zap.L().Info("Replacing revert message with a custom error", zap.String("contract", m.contract.GetName()), zap.String("message", message))
+			return rewritten(call, message, m.revert(m.errorFor(message)))
+		}
+		return node
+	})
+}
+
+// stringLiteral returns the value of a string literal
+func stringLiteral(node ast.Node[ast.NodeType]) (string, bool) {
+	literal, ok := node.(*ast.PrimaryExpression)
+	if !ok || literal.GetType() != ast_pb.NodeType_LITERAL || literal.GetKind() != ast_pb.NodeType_STRING {
+		return "", false
+	}
+	return literal.GetValue(), true
+}
+
+// errorFor returns the error for the message, declaring a new one the first time the message is seen
+func (m *errorMigrator) errorFor(message string) *ast.ErrorDefinition {
+	if definition, ok := m.errors[message]; ok {
+		return definition
+	}
+	name := errorName(message)
+	for i := 2; m.reserved[name]; i++ {
+		name = errorName(message) + strconv.Itoa(i)
+	}
+	m.reserved[name] = true
+
+	id := m.builder.GetNextID()
+	definition := &ast.ErrorDefinition{
+		Id:         id,
+		NodeType:   ast_pb.NodeType_ERROR_DEFINITION,
+		Name:       name,
+		Parameters: &ast.ParameterList{NodeType: ast_pb.NodeType_PARAMETER_LIST, Parameters: []*ast.Parameter{}},
+		TypeDescription: &ast.TypeDescription{
+			TypeIdentifier: fmt.Sprintf("t_error$_%s_%s_$%d", m.contract.GetName(), name, id),
+			TypeString:     fmt.Sprintf("error %s.%s", m.contract.GetName(), name),
+		},
+	}
+	m.errors[message] = definition
+	m.declared = append(m.declared, definition)
+	return definition
+}
+
+// errorName turns a message such as "amount must be positive" into AmountMustBePositive
+func errorName(message string) string {
+	var sb strings.Builder
+	words := strings.FieldsFunc(message, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	for _, word := range words {
+		sb.WriteString(strings.ToUpper(word[:1]))
+		sb.WriteString(word[1:])
+	}
+	name := sb.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "Error" + name
+	}
+	return name
+}
+
+// revert builds `revert Error()`
+func (m *errorMigrator) revert(definition *ast.ErrorDefinition) *ast.RevertStatement {
+	return &ast.RevertStatement{
+		Id:       m.builder.GetNextID(),
+		NodeType: ast_pb.NodeType_REVERT_STATEMENT,
+		Expression: &ast.PrimaryExpression{
+			Id:                    m.builder.GetNextID(),
+			NodeType:              ast_pb.NodeType_IDENTIFIER,
+			Name:                  definition.GetName(),
+			ReferencedDeclaration: definition.GetId(),
+			TypeDescription:       definition.GetTypeDescription(),
+		},
+		Arguments: []ast.Node[ast.NodeType]{},
+	}
+}
+
+// revertUnless builds `if (!condition) { revert Error(); }`
+func (m *errorMigrator) revertUnless(condition ast.Node[ast.NodeType], definition *ast.ErrorDefinition) *ast.IfStatement {
+	boolType := &ast.TypeDescription{TypeIdentifier: "t_bool", TypeString: "bool"}
+	switch condition.(type) {
+	case *ast.PrimaryExpression, *ast.FunctionCall, *ast.MemberAccessExpression, *ast.IndexAccess, *ast.TupleExpression:
+	default:
+		// the negation binds tighter than any binary operator
+		condition = &ast.TupleExpression{
+			Id:              m.builder.GetNextID(),
+			NodeType:        ast_pb.NodeType_TUPLE_EXPRESSION,
+			Components:      []ast.Node[ast.NodeType]{condition},
+			TypeDescription: boolType,
+		}
+	}
+	return &ast.IfStatement{
+		Id:       m.builder.GetNextID(),
+		NodeType: ast_pb.NodeType_IF_STATEMENT,
+		Condition: &ast.UnaryPrefix{
+			Id:              m.builder.GetNextID(),
+			NodeType:        ast_pb.NodeType_UNARY_OPERATION,
+			Operator:        ast_pb.Operator_NOT,
+			Prefix:          true,
+			Expression:      condition,
+			TypeDescription: boolType,
+		},
+		Body: &ast.BodyNode{
+			Id:         m.builder.GetNextID(),
+			NodeType:   ast_pb.NodeType_BLOCK,
+			Statements: []ast.Node[ast.NodeType]{m.revert(definition)},
+		},
+	}
+}
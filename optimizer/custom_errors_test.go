@@ -0,0 +1,115 @@
+// test file for custom_errors.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const customErrorsContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Owned {
+    error Unauthorized();
+    address public owner;
+
+    function transferOwnership(address next) public {
+        require(msg.sender == owner, "unauthorized");
+        require(next != address(0), "zero address!");
+        owner = next;
+    }
+}
+
+contract Vault is Owned {
+    function withdraw(uint256 amount, string memory reason) public {
+        require(msg.sender == owner, "unauthorized");
+        require(amount > 0, reason);
+        require(amount < 100);
+        revert("zero address");
+    }
+}
+`
+
+const oldCustomErrorsContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.0;
+
+contract Owned {
+    address public owner;
+
+    function transferOwnership(address next) public {
+        require(msg.sender == owner, "unauthorized");
+        owner = next;
+    }
+}
+`
+
+// returns the names of the errors declared in the contract
+func errorNames(builder *ir.Builder, contractName string) []string {
+	names := make([]string, 0)
+	for _, contract := range builder.GetRoot().GetContracts() {
+		if contract.GetName() != contractName {
+			continue
+		}
+		for _, node := range contract.GetAST().GetContract().GetNodes() {
+			if definition, ok := node.(*ast.ErrorDefinition); ok {
+				names = append(names, definition.GetName())
+			}
+		}
+	}
+	return names
+}
+
+// returns the names of the errors reverted with in the function, and the number of require calls left
+func revertedErrors(fn *ast.Function) ([]string, int) {
+	names := make([]string, 0)
+	requires := 0
+	var visit func(node ast.Node[ast.NodeType])
+	visit = func(node ast.Node[ast.NodeType]) {
+		switch node := node.(type) {
+		case nil:
+			return
+		case *ast.RevertStatement:
+			names = append(names, node.GetExpression().(*ast.PrimaryExpression).GetName())
+		case *ast.FunctionCall:
+			if ident, ok := node.GetExpression().(*ast.PrimaryExpression); ok && ident.GetName() == "require" {
+				requires++
+			}
+		}
+		for _, child := range node.GetNodes() {
+			visit(child)
+		}
+	}
+	visit(fn.GetBody())
+	return names, requires
+}
+
+func TestUseCustomErrors(t *testing.T) {
+	builder := setUpBuilder(t, customErrorsContract)
+	optimizer.NewOptimizer(builder).UseCustomErrors()
+
+	// Unauthorized is taken by the existing error
+	assert.Equal(t, []string{"Unauthorized", "Unauthorized2", "ZeroAddress"}, errorNames(builder, "Owned"))
+	reverted, requires := revertedErrors(findFunction(builder, "Owned", "transferOwnership"))
+	assert.Equal(t, []string{"Unauthorized2", "ZeroAddress"}, reverted)
+	assert.Equal(t, 0, requires)
+
+	// the error for a message already migrated in the base is reused, a different message gets its own name
+	assert.Equal(t, []string{"ZeroAddress2"}, errorNames(builder, "Vault"))
+	reverted, requires = revertedErrors(findFunction(builder, "Vault", "withdraw"))
+	assert.Equal(t, []string{"Unauthorized2", "ZeroAddress2"}, reverted)
+	// a message that is not a literal, and a require without a message
+	assert.Equal(t, 2, requires)
+}
+
+func TestUseCustomErrorsOldPragma(t *testing.T) {
+	builder := setUpBuilder(t, oldCustomErrorsContract)
+	optimizer.NewOptimizer(builder).UseCustomErrors()
+
+	assert.Empty(t, errorNames(builder, "Owned"))
+	_, requires := revertedErrors(findFunction(builder, "Owned", "transferOwnership"))
+	assert.Equal(t, 1, requires)
+}
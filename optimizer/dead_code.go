@@ -0,0 +1,268 @@
+// Removes unused private and internal declarations and statements that can never run
+package optimizer
+
+import (
+	"fmt"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+func (o *Optimizer) optimizeDeadCode(keepStorageLayout bool) []Change {
+	tree := o.builder.GetAstBuilder().GetTree()
+	// the references are collected from every contract, but only the rewritable ones lose code
+	contracts := make([]*ir.Contract, 0)
+	for _, contract := range o.builder.GetRoot().GetContracts() {
+		if o.rewritable(contract) {
+			contracts = append(contracts, contract)
+		}
+	}
+
+	changes := make([]Change, 0)
+	for _, contract := range contracts {
+		changes = append(changes, removeUnreachableStatements(contract)...)
+	}
+	statements := len(changes)
+	// removing a declaration can leave the ones only it used unreferenced, so repeat until nothing changes
+	for {
+		refs := o.collectReferences(tree)
+		removed := make([]Change, 0)
+		for _, contract := range contracts {
+			removed = append(removed, removeUnusedDeclarations(contract, refs, keepStorageLayout)...)
+		}
+		if len(removed) == 0 {
+			break
+		}
+		changes = append(changes, removed...)
+	}
+	zap.L().Info("Removed dead code", zap.Int("declarations", len(changes)-statements), zap.Int("statements", statements))
+	return changes
+}
+
+// references holds what the code refers to, by declaration id and, for what the parser did not resolve, by name
+type references struct {
+	ids   map[int64]bool
+	names map[string]bool
+}
+
+// uses reports whether the declaration may be referenced anywhere
+func (r *references) uses(id int64, name string) bool {
+	return r.ids[id] || r.names[name]
+}
+
+// collectReferences gathers the referenced declarations of every source unit.
+// Calls to internal functions and struct constructors are often left unresolved by the parser, so the names of
+// unresolved identifiers and of every member access are kept as well, which keeps a declaration whenever in doubt.
+func (o *Optimizer) collectReferences(tree *ast.Tree) *references {
+	refs := &references{ids: make(map[int64]bool, 0), names: make(map[string]bool, 0)}
+	for _, unit := range o.builder.GetAstBuilder().GetRoot().GetSourceUnits() {
+		walk(unit, func(node ast.Node[ast.NodeType]) bool {
+			if member, ok := node.(*ast.MemberAccessExpression); ok {
+				refs.names[member.GetMemberName()] = true
+			}
+			ref, ok := node.(interface{ GetReferencedDeclaration() int64 })
+			if !ok {
+				return true
+			}
+			// local variables refer to themselves
+			id := ref.GetReferencedDeclaration()
+			if id != 0 && id != node.GetId() && !isNilNode(tree.GetById(id)) {
+				refs.ids[id] = true
+				return true
+			}
+			switch node := node.(type) {
+			case *ast.PrimaryExpression:
+				refs.names[node.GetName()] = true
+			case *ast.TypeName:
+				refs.names[node.GetName()] = true
+			case *ast.PathNode:
+				refs.names[node.GetName()] = true
+			}
+			return true
+		})
+	}
+	return refs
+}
+
+// removeUnusedDeclarations removes the unreferenced private and internal functions, private state variables,
+// structs, events and errors declared in the contract. The state variables living in storage are kept if
+// keepStorageLayout is set. Returns a change for every declaration removed.
+func removeUnusedDeclarations(contract *ir.Contract, refs *references, keepStorageLayout bool) []Change {
+	astContract := contract.GetAST().GetContract()
+	nodes, ok := contractNodes(astContract)
+	if !ok {
+		return nil
+	}
+	changes := make([]Change, 0)
+	removed := make(map[ast.Node[ast.NodeType]]bool, 0)
+	kept := make([]ast.Node[ast.NodeType], 0, len(*nodes))
+	for _, node := range *nodes {
+		kind, name, unused := unusedDeclaration(node, refs, keepStorageLayout)
+		if !unused {
+			kept = append(kept, node)
+			continue
+		}
+		zap.L().Info("Removing unused "+kind, zap.String("contract", contract.GetName()), zap.String("name", name))
+		removed[node] = true
+		// the bytecode gets smaller too, but only an initialised state variable has a known cost
+		gasDelta := 0
+		if sv, ok := node.(*ast.StateVariableDeclaration); ok && !isNilNode(sv.GetInitialValue()) {
+			gasDelta = -sstoreSetCost
+		}
+		changes = append(changes, newChange(contract.GetName(), "", node.GetSrc(), nil, fmt.Sprintf("the %s %s is never used", kind, name), gasDelta))
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	*nodes = kept
+
+	// later passes go through the IR, so it has to forget the removed declarations too
+	functions := make([]*ir.Function, 0, len(contract.Functions))
+	for _, f := range contract.Functions {
+		if !removed[f.GetAST()] {
+			functions = append(functions, f)
+		}
+	}
+	contract.Functions = functions
+	variables := make([]*ir.StateVariable, 0, len(contract.StateVariables))
+	for _, v := range contract.StateVariables {
+		if !removed[v.GetAST()] {
+			variables = append(variables, v)
+		}
+	}
+	contract.StateVariables = variables
+	structs := make([]*ir.Struct, 0, len(contract.Structs))
+	for _, s := range contract.Structs {
+		if !removed[s.GetAST()] {
+			structs = append(structs, s)
+		}
+	}
+	contract.Structs = structs
+	events := make([]*ir.Event, 0, len(contract.Events))
+	for _, e := range contract.Events {
+		if !removed[e.GetAST()] {
+			events = append(events, e)
+		}
+	}
+	contract.Events = events
+	errors := make([]*ir.Error, 0, len(contract.Errors))
+	for _, e := range contract.Errors {
+		if !removed[e.GetAST()] {
+			errors = append(errors, e)
+		}
+	}
+	contract.Errors = errors
+	return changes
+}
+
+// contractNodes returns the declarations of a contract, library or interface so they can be replaced
+func contractNodes(node ast.Node[ast.NodeType]) (*[]ast.Node[ast.NodeType], bool) {
+	switch node := node.(type) {
+	case *ast.Contract:
+		return &node.Nodes, true
+	case *ast.Library:
+		return &node.Nodes, true
+	case *ast.Interface:
+		return &node.Nodes, true
+	}
+	return nil, false
+}
+
+// unusedDeclaration reports whether the node is a declaration that can be removed, along with its kind and name
+func unusedDeclaration(node ast.Node[ast.NodeType], refs *references, keepStorageLayout bool) (string, string, bool) {
+	switch node := node.(type) {
+	case *ast.Function:
+		// virtual functions and overrides can be called through a function they override
+		if node.GetKind() != ast_pb.NodeType_KIND_FUNCTION || node.IsVirtual() || len(node.GetOverrides()) > 0 {
+			return "", "", false
+		}
+		if node.GetVisibility() != ast_pb.Visibility_PRIVATE && node.GetVisibility() != ast_pb.Visibility_INTERNAL {
+			return "", "", false
+		}
+		return "function", node.GetName(), !refs.uses(node.GetId(), node.GetName())
+	case *ast.StateVariableDeclaration:
+		// the initialiser may call a function that does more than compute the value
+		if node.GetVisibility() != ast_pb.Visibility_PRIVATE || hasCall(node.GetInitialValue()) {
+			return "", "", false
+		}
+		// removing a variable from storage moves the ones declared after it to other slots
+		if keepStorageLayout && usesStorage(node) {
+			return "", "", false
+		}
+		return "state variable", node.GetName(), !refs.uses(node.GetId(), node.GetName())
+	case *ast.StructDefinition:
+		return "struct", node.GetName(), !refs.uses(node.GetId(), node.GetName())
+	case *ast.EventDefinition:
+		return "event", node.GetName(), !refs.uses(node.GetId(), node.GetName())
+	case *ast.ErrorDefinition:
+		return "error", node.GetName(), !refs.uses(node.GetId(), node.GetName())
+	}
+	return "", "", false
+}
+
+// hasCall reports whether the expression calls anything other than a type conversion
+func hasCall(node ast.Node[ast.NodeType]) bool {
+	found := false
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if call, ok := n.(*ast.FunctionCall); ok && !isConversion(call) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// removeUnreachableStatements drops the statements that follow a return or a revert in the same block.
+// Returns a change for every statement removed.
+func removeUnreachableStatements(contract *ir.Contract) []Change {
+	changes := make([]Change, 0)
+	nodes, ok := contractNodes(contract.GetAST().GetContract())
+	if !ok {
+		return changes
+	}
+	for _, declaration := range *nodes {
+		function := functionName(declaration)
+		walk(declaration, func(node ast.Node[ast.NodeType]) bool {
+			switch node := node.(type) {
+			case *ast.BodyNode:
+				changes = append(changes, truncateAfterExit(contract, function, node)...)
+			case interface{ GetBody() *ast.BodyNode }:
+				// functions, modifiers and constructors list the statements of their body instead of the body itself
+				changes = append(changes, truncateAfterExit(contract, function, node.GetBody())...)
+			}
+			return true
+		})
+	}
+	return changes
+}
+
+// truncateAfterExit removes the statements after the first return or revert of the block
+func truncateAfterExit(contract *ir.Contract, function string, body *ast.BodyNode) []Change {
+	// a block without braces has no source range and may hold the else branch after the if branch,
+	// and unchecked blocks are not kept in their original position by the parser
+	if body == nil || body.GetType() != ast_pb.NodeType_BLOCK || body.GetSrc().End == 0 {
+		return nil
+	}
+	for _, stmt := range body.GetStatements() {
+		if stmt.GetType() == ast_pb.NodeType_UNCHECKED_BLOCK {
+			return nil
+		}
+	}
+	for i, stmt := range body.GetStatements() {
+		_, isReturn := stmt.(*ast.ReturnStatement)
+		if (!isReturn && !isRevert(stmt)) || i == len(body.GetStatements())-1 {
+			continue
+		}
+		unreachable := len(body.GetStatements()) - i - 1
+		zap.L().Info("Removing unreachable statements", zap.String("contract", contract.GetName()), zap.Int("statements", unreachable))
+		changes := make([]Change, 0, unreachable)
+		for _, dead := range body.Statements[i+1:] {
+			changes = append(changes, newChange(contract.GetName(), function, dead.GetSrc(), nil, "the statement follows a return or revert and never runs", 0))
+		}
+		body.Statements = body.Statements[:i+1]
+		return changes
+	}
+	return nil
+}
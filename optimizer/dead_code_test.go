@@ -0,0 +1,126 @@
+// test file for dead_code.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const deadCodeContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+library Math {
+    function double(uint256 a) internal pure returns (uint256) {
+        return a * 2;
+    }
+
+    function triple(uint256 a) internal pure returns (uint256) {
+        return a * 3;
+    }
+}
+
+contract Base {
+    using Math for uint256;
+
+    event Paid(uint256 amount);
+    error Unused();
+    uint256 private total;
+    uint256 private unusedTotal;
+
+    function pay(uint256 amount) external returns (uint256) {
+        total += amount.double();
+        if (amount == 0) return 0; else amount = 1;
+        return total;
+        total = 0;
+    }
+
+    function helper() private pure returns (uint256) {
+        return 1;
+    }
+
+    function unusedHelper() private pure returns (uint256) {
+        return helper();
+    }
+
+    function hook() internal virtual {}
+}
+
+contract Derived is Base {
+    function hook() internal override {
+        emit Paid(1);
+    }
+}
+`
+
+// returns the names of the declarations of the contract
+func declarationNames(builder *ir.Builder, contractName string) []string {
+	names := make([]string, 0)
+	for _, contract := range builder.GetRoot().GetContracts() {
+		if contract.GetName() != contractName {
+			continue
+		}
+		for _, node := range contract.GetAST().GetContract().GetNodes() {
+			if named, ok := node.(interface{ GetName() string }); ok {
+				names = append(names, named.GetName())
+			}
+		}
+	}
+	return names
+}
+
+func TestEliminateDeadCode(t *testing.T) {
+	builder := setUpBuilder(t, deadCodeContract)
+	optimizer.NewOptimizer(builder).EliminateDeadCode(false)
+
+	// triple is never called, double is called through using for
+	assert.Equal(t, []string{"double"}, declarationNames(builder, "Math"))
+	// helper is only used by unusedHelper, the event is emitted by the derived contract and hook is virtual
+	assert.Equal(t, []string{"Paid", "total", "pay", "hook"}, declarationNames(builder, "Base"))
+	assert.Nil(t, findFunction(builder, "Base", "helper"))
+	assert.Equal(t, []string{"hook"}, declarationNames(builder, "Derived"))
+
+	// the statement after the last return is dropped, the else branch of the if is kept
+	pay := findFunction(builder, "Base", "pay")
+	statements := pay.GetBody().GetStatements()
+	assert.Len(t, statements, 3)
+	_, ok := statements[2].(*ast.ReturnStatement)
+	assert.True(t, ok)
+	ifBody := statements[1].(*ast.IfStatement).GetBody().(*ast.BodyNode)
+	assert.Len(t, ifBody.GetStatements(), 2)
+}
+
+const lastMembersContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Last {
+    uint256 public count;
+
+    function bump() external {
+        count += 1;
+    }
+
+    function unusedOne() private {}
+
+    /// @notice never called either
+    function unusedTwo() private {}
+}
+`
+
+func TestEliminateLastMembers(t *testing.T) {
+	builder := setUpBuilder(t, lastMembersContract)
+	opt := optimizer.NewOptimizer(builder)
+	assert.Len(t, opt.EliminateDeadCode(false), 2)
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the blank lines above the removed members go with them
+	assert.Contains(t, code, `    function bump() external {
+        count += 1;
+    }
+}
+`)
+}
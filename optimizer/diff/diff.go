@@ -0,0 +1,193 @@
+// Package diff writes unified diffs of two versions of a file, in the format `git apply` and `patch -p1` read
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around every change
+const Context = 3
+
+// line is one line of the edit script: kept (' '), removed ('-') or added ('+'),
+// with the number of lines of both versions before it
+type line struct {
+	kind   byte
+	text   string
+	before int
+	after  int
+}
+
+// Unified returns the diff turning before into after, with headers naming the file a/path and b/path as git does.
+// An absolute path is named from the root of the file system, so the diff applies from there.
+// It returns an empty string if the two versions are the same.
+func Unified(path, before, after string) string {
+	if before == after {
+		return ""
+	}
+	path = strings.TrimLeft(filepath.ToSlash(strings.TrimPrefix(path, filepath.VolumeName(path))), "/")
+	script := editScript(splitLines(before), splitLines(after))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+	for start := 0; start < len(script); {
+		first := nextChange(script, start)
+		if first == len(script) {
+			break
+		}
+		// changes closer than twice the context share a hunk
+		last := first
+		for next := nextChange(script, last+1); next < len(script) && next-last <= 2*Context+1; next = nextChange(script, last+1) {
+			last = next
+		}
+		from, to := max(first-Context, start), min(last+Context+1, len(script))
+		writeHunk(&sb, script[from:to])
+		start = to
+	}
+	return sb.String()
+}
+
+// splitLines splits the text after every newline. The last line has none if the text does not end with one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func nextChange(script []line, from int) int {
+	for from < len(script) && script[from].kind == ' ' {
+		from++
+	}
+	return from
+}
+
+func writeHunk(sb *strings.Builder, hunk []line) {
+	beforeCount, afterCount := 0, 0
+	for _, l := range hunk {
+		if l.kind != '+' {
+			beforeCount++
+		}
+		if l.kind != '-' {
+			afterCount++
+		}
+	}
+	// an empty range starts at the line before it
+	beforeStart, afterStart := hunk[0].before, hunk[0].after
+	if beforeCount > 0 {
+		beforeStart++
+	}
+	if afterCount > 0 {
+		afterStart++
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", beforeStart, beforeCount, afterStart, afterCount)
+	for _, l := range hunk {
+		sb.WriteByte(l.kind)
+		sb.WriteString(l.text)
+		if !strings.HasSuffix(l.text, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// editScript returns the shortest edit script turning a into b, found with the algorithm of Myers.
+// The lines both versions start and end with are matched first, which leaves little to search for
+// in a file the optimizer only changed in places.
+func editScript(a, b []string) []line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	middle := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+
+	script := make([]line, 0, prefix+len(middle)+suffix)
+	x, y := 0, 0
+	emit := func(kind byte, text string) {
+		script = append(script, line{kind: kind, text: text, before: x, after: y})
+		if kind != '+' {
+			x++
+		}
+		if kind != '-' {
+			y++
+		}
+	}
+	for _, text := range a[:prefix] {
+		emit(' ', text)
+	}
+	for _, l := range middle {
+		emit(l.kind, l.text)
+	}
+	for _, text := range a[len(a)-suffix:] {
+		emit(' ', text)
+	}
+	return script
+}
+
+// myers returns the kinds and texts of the shortest edit script turning a into b
+func myers(a, b []string) []line {
+	n, m := len(a), len(b)
+	limit := n + m
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := make([][]int, 0)
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// walk back from the end through the furthest points of every round
+	reversed := make([]line, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		previousK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			previousK = k + 1
+		}
+		previousX := v[offset+previousK]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			reversed = append(reversed, line{kind: ' ', text: a[x-1]})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == previousX {
+			reversed = append(reversed, line{kind: '+', text: b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, line{kind: '-', text: a[x-1]})
+			x--
+		}
+	}
+	script := make([]line, len(reversed))
+	for i, l := range reversed {
+		script[len(reversed)-1-i] = l
+	}
+	return script
+}
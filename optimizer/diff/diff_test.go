@@ -0,0 +1,92 @@
+// test file for diff.go
+package diff_test
+
+import (
+	"optimizer/optimizer/optimizer/diff"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func numbered(from, to int) string {
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		sb.WriteString("line " + string(rune('a'+i%26)) + "\n")
+	}
+	return sb.String()
+}
+
+func TestUnified(t *testing.T) {
+	assert.Empty(t, diff.Unified("a.sol", "same\n", "same\n"))
+
+	before := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	after := "one\ntwo\nthree\nfour\n5\nsix\nseven\neight\nnine\nten\neleven\n"
+	assert.Equal(t, `diff --git a/src/a.sol b/src/a.sol
+--- a/src/a.sol
++++ b/src/a.sol
+@@ -2,9 +2,10 @@
+ two
+ three
+ four
+-five
++5
+ six
+ seven
+ eight
+ nine
+ ten
++eleven
+`, diff.Unified("src/a.sol", before, after))
+
+	// changes far apart get a hunk each
+	before = numbered(0, 20)
+	after = strings.Replace(strings.Replace(before, "line b\n", "line B\n", 1), "line t\n", "", 1)
+	patch := diff.Unified("a.sol", before, after)
+	assert.Equal(t, 2, strings.Count(patch, "@@ -"))
+	assert.Contains(t, patch, "@@ -1,5 +1,5 @@\n line a\n-line b\n+line B\n")
+	assert.Contains(t, patch, "@@ -17,5 +17,4 @@\n line q\n line r\n line s\n-line t\n line u\n")
+
+	// a missing newline at the end is marked
+	patch = diff.Unified("a.sol", "one\ntwo", "one\ntwo\n")
+	assert.Contains(t, patch, "-two\n\\ No newline at end of file\n+two\n")
+}
+
+func TestUnifiedApplies(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	before := "// SPDX-License-Identifier: MIT\n" + numbered(0, 40) + "contract A {\n    uint256 x;\n}"
+	after := strings.Replace(strings.Replace(before, "line e\n", "", 1), "uint256 x;", "uint256 constant x = 1;", 1)
+	after = "// header\n" + strings.Replace(after, "line w\n", "line w\nline w2\n", 1)
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "src", "A.sol"), []byte(before), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "change.patch"), []byte(diff.Unified("src/A.sol", before, after)), 0644))
+	cmd := exec.Command(git, "apply", "change.patch")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+	applied, err := os.ReadFile(filepath.Join(dir, "src", "A.sol"))
+	assert.NoError(t, err)
+	assert.Equal(t, after, string(applied))
+
+	// a file outside the working directory is named from the root
+	file := filepath.Join(dir, "src", "A.sol")
+	patch := diff.Unified(file, after, before)
+	assert.True(t, strings.HasPrefix(patch, "diff --git a/"+strings.TrimPrefix(filepath.ToSlash(file), "/")+" "))
+	assert.NotContains(t, patch, "a//")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "absolute.patch"), []byte(patch), 0644))
+	cmd = exec.Command(git, "apply", filepath.Join(dir, "absolute.patch"))
+	cmd.Dir = "/"
+	output, err = cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+	applied, err = os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, before, string(applied))
+}
@@ -0,0 +1,159 @@
+// Rewrites exponentiation with a small literal exponent into a chain of multiplications
+package optimizer
+
+import (
+	"strconv"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// DefaultMaxExponent is the largest exponent expanded into multiplications when no cap is given
+const DefaultMaxExponent = 4
+
+func (o *Optimizer) optimizeExponentiation(maxExponent int) []Change {
+	if maxExponent <= 0 {
+		maxExponent = DefaultMaxExponent
+	}
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	contracts := o.builder.GetRoot().GetContracts()
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		visible := make(map[string]*ast.StateVariableDeclaration, 0)
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			visible = visibleStateVariables(tree, astContract)
+		}
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil {
+				continue
+			}
+			locals := localNames(fn)
+			rewriteNodes(fn.GetBody(), func(node ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+				exp, ok := node.(*ast.ExprOperation)
+				if !ok {
+					return node
+				}
+				exponent, ok := literalExponent(exp.RightExpression)
+				if !ok || exponent < 2 || exponent > int64(maxExponent) {
+					return node
+				}
+				if !isSideEffectFree(exp.LeftExpression) || readsStorage(tree, exp.LeftExpression, visible, locals) {
+					return node
+				}
+				chain := o.multiplicationChain(exp.LeftExpression, exponent, parent)
+				changes = append(changes, newChange(contract.GetName(), fn.GetName(), exp.GetSrc(), chain,
+					"a few multiplications are cheaper than an exponentiation", int(exponent-1)*checkedMulCost-checkedExpCost))
+				return chain
+			})
+		}
+	}
+	return changes
+}
+
+// literalExponent returns the value of a plain number literal
+func literalExponent(node ast.Node[ast.NodeType]) (int64, bool) {
+	literal, ok := node.(*ast.PrimaryExpression)
+	if !ok || literal.GetKind() != ast_pb.NodeType_NUMBER {
+		return 0, false
+	}
+	// base 0 also takes care of hex literals and underscores
+	value, err := strconv.ParseInt(literal.GetValue(), 0, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// isSideEffectFree reports whether the expression is an identifier or a chain of member accesses on one,
+// so evaluating it more than once gives the same value and does nothing else
+func isSideEffectFree(node ast.Node[ast.NodeType]) bool {
+	switch node := node.(type) {
+	case *ast.PrimaryExpression:
+		return node.GetType() == ast_pb.NodeType_IDENTIFIER
+	case *ast.MemberAccessExpression:
+		return isSideEffectFree(node.GetExpression())
+	}
+	return false
+}
+
+// readsStorage reports whether the identifier or member access chain is rooted at a state variable.
+// Reading it again for every multiplication costs more than the exponentiation saves.
+func readsStorage(tree *ast.Tree, node ast.Node[ast.NodeType], visible map[string]*ast.StateVariableDeclaration, locals map[string]bool) bool {
+	for {
+		member, ok := node.(*ast.MemberAccessExpression)
+		if !ok {
+			break
+		}
+		node = member.GetExpression()
+	}
+	ident, ok := node.(*ast.PrimaryExpression)
+	if !ok {
+		return true
+	}
+	ref := ident.GetReferencedDeclaration()
+	if ref != 0 && ref != ident.GetId() {
+		if sv, ok := tree.GetById(ref).(*ast.StateVariableDeclaration); ok {
+			return usesStorage(sv)
+		}
+		return false
+	}
+	sv, ok := visible[ident.GetName()]
+	return ok && !locals[ident.GetName()] && usesStorage(sv)
+}
+
+// multiplicationChain builds `base * base * ... * base` with exponent operands.
+// No partial product is larger in magnitude than the power itself, so checked multiplication reverts
+// exactly when the exponentiation would, and unchecked multiplication wraps to the same value.
+func (o *Optimizer) multiplicationChain(base ast.Node[ast.NodeType], exponent int64, parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+	builder := o.builder.GetAstBuilder()
+	typeDescription := base.GetTypeDescription()
+	var chain ast.Node[ast.NodeType] = base
+	for i := int64(1); i < exponent; i++ {
+		chain = &ast.BinaryOperation{
+			Id:              builder.GetNextID(),
+			NodeType:        ast_pb.NodeType_BINARY_OPERATION,
+			Operator:        ast_pb.Operator_MULTIPLICATION,
+			LeftExpression:  chain,
+			RightExpression: cloneExpression(builder, base),
+			TypeDescription: typeDescription,
+		}
+	}
+	if !needsParentheses(parent) {
+		return chain
+	}
+	return &ast.TupleExpression{
+		Id:              builder.GetNextID(),
+		NodeType:        ast_pb.NodeType_TUPLE_EXPRESSION,
+		Components:      []ast.Node[ast.NodeType]{chain},
+		TypeDescription: typeDescription,
+	}
+}
+
+// needsParentheses reports whether a multiplication has to be wrapped to keep its precedence under the parent
+func needsParentheses(parent ast.Node[ast.NodeType]) bool {
+	switch parent.(type) {
+	case *ast.VariableDeclaration, *ast.Assignment, *ast.ReturnStatement, *ast.TupleExpression, *ast.FunctionCall, *ast.IndexAccess:
+		return false
+	}
+	return true
+}
+
+// cloneExpression copies an identifier or a member access chain so every operand is its own node
+func cloneExpression(builder *ast.ASTBuilder, node ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+	switch node := node.(type) {
+	case *ast.PrimaryExpression:
+		clone := *node
+		clone.Id = builder.GetNextID()
+		return &clone
+	case *ast.MemberAccessExpression:
+		clone := *node
+		clone.Id = builder.GetNextID()
+		clone.Expression = cloneExpression(builder, node.GetExpression())
+		return &clone
+	}
+	return node
+}
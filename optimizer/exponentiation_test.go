@@ -0,0 +1,118 @@
+// test file for exponentiation.go
+package optimizer_test
+
+import (
+	"context"
+	"optimizer/optimizer/optimizer"
+	"optimizer/optimizer/printer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"github.com/unpackdev/solgo/printer/ast_printer"
+)
+
+const exponentiationContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Exponentiation {
+    struct Point {
+        uint256 x;
+    }
+    uint256 total;
+
+    function square(uint256 a) public pure returns (uint256) {
+        uint256 b = a ** 2;
+        return b;
+    }
+
+    function cube(Point memory p) public pure returns (uint256) {
+        return 10 / p.x ** 3;
+    }
+
+    function high(uint256 a) public pure returns (uint256) {
+        return a ** 10;
+    }
+
+    function stored() public view returns (uint256) {
+        return total ** 2;
+    }
+}
+`
+
+// builds the contract from source
+func setUpBuilder(t *testing.T, code string) *ir.Builder {
+	builder, err := printer.GetBuilderCode(context.Background(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := builder.Parse(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if err := builder.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if errs := builder.GetAstBuilder().ResolveReferences(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return builder
+}
+
+// counts the exponentiations left in the function
+func countExponentiations(builder *ir.Builder, name string) int {
+	count := 0
+	for _, contract := range builder.GetRoot().GetContracts() {
+		for _, f := range contract.GetFunctions() {
+			if f.GetName() != name {
+				continue
+			}
+			builder.GetAstBuilder().GetTree().ExecuteCustomTypeVisit(f.GetAST().GetNodes(), ast_pb.NodeType_EXPRESSION_OPERATION, func(node ast.Node[ast.NodeType]) (bool, error) {
+				count++
+				return true, nil
+			})
+		}
+	}
+	return count
+}
+
+func TestExpandExponentiation(t *testing.T) {
+	builder := setUpBuilder(t, exponentiationContract)
+	optimizer.NewOptimizer(builder).ExpandExponentiation(optimizer.DefaultMaxExponent)
+
+	assert.Equal(t, 0, countExponentiations(builder, "square"))
+	assert.Equal(t, 0, countExponentiations(builder, "cube"))
+	// above the cap
+	assert.Equal(t, 1, countExponentiations(builder, "high"))
+	// reading storage again for every multiplication is not worth it
+	assert.Equal(t, 1, countExponentiations(builder, "stored"))
+}
+
+func TestExpandExponentiationOutput(t *testing.T) {
+	builder := setUpBuilder(t, exponentiationContract)
+	optimizer.NewOptimizer(builder).ExpandExponentiation(10)
+
+	assert.Equal(t, 0, countExponentiations(builder, "high"))
+	assert.Equal(t, 1, countExponentiations(builder, "stored"))
+
+	// the printer cannot print the exponentiation left in stored, so only look at the other functions
+	for _, contract := range builder.GetRoot().GetContracts() {
+		for _, f := range contract.GetFunctions() {
+			if f.GetName() == "stored" {
+				continue
+			}
+			output, ok := ast_printer.Print(f.GetAST())
+			assert.True(t, ok)
+			switch f.GetName() {
+			case "square":
+				assert.Contains(t, output, "uint256 b = a * a;")
+			case "cube":
+				// the multiplication keeps the precedence of the exponentiation
+				assert.Contains(t, output, "return 10 / (p.x * p.x * p.x)")
+			case "high":
+				assert.Contains(t, output, "return a * a * a * a * a * a * a * a * a * a")
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Promotes public functions that are never called from inside the contract to external
+package optimizer
+
+import (
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+func (o *Optimizer) optimizeExternalFunctions() []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	contracts := make([]*ast.Contract, 0)
+	irContracts := make(map[*ast.Contract]*ir.Contract, 0)
+	for _, contract := range o.builder.GetRoot().GetContracts() {
+		// interface functions are external already and library functions are called through the library
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			contracts = append(contracts, astContract)
+			irContracts[astContract] = contract
+		}
+	}
+	used := internallyUsedFunctions(tree, contracts)
+	graph := newCallGraph(o.builder.GetRoot().GetContracts())
+	callables := make(map[ast.Node[ast.NodeType]]*callable, len(graph.callables))
+	for _, c := range graph.callables {
+		callables[c.node] = c
+	}
+
+	for _, contract := range contracts {
+		if !o.rewritable(irContracts[contract]) {
+			continue
+		}
+		bases := inheritedContracts(tree, contract)[1:]
+		for _, node := range contract.GetNodes() {
+			fn, ok := node.(*ast.Function)
+			if !ok || fn.GetKind() != ast_pb.NodeType_KIND_FUNCTION || fn.GetVisibility() != ast_pb.Visibility_PUBLIC {
+				continue
+			}
+			if used[contract.GetId()][fn.GetName()] || !canBeExternal(fn, bases) {
+				continue
+			}
+			zap.L().Info("Making function external", zap.String("contract", contract.GetName()), zap.String("function", fn.GetName()))
+			fn.Visibility = ast_pb.Visibility_EXTERNAL
+			converted := 0
+			if c, ok := callables[fn]; ok {
+				// the parameters are converted as optimize-call-data would convert the ones of an external function
+				candidates := make(map[*ast.Parameter]bool, 0)
+				for _, param := range callDataCandidates(tree, graph, c) {
+					candidates[param] = true
+				}
+				keepConsistentCandidates(graph, candidates)
+				for _, param := range c.params {
+					if candidates[param] {
+						param.StorageLocation = ast_pb.StorageLocation_CALLDATA
+						converted++
+					}
+				}
+			}
+			rationale := "the function is never called from inside the contract, so it can be external"
+			if converted > 0 {
+				rationale += " and read its parameters from calldata"
+			}
+			changes = append(changes, newChange(contract.GetName(), fn.GetName(), fn.GetSrc(), fn, rationale, -converted*memoryCopyCost))
+		}
+	}
+	return changes
+}
+
+// internallyUsedFunctions returns, for every contract id, the names of the functions that are used from inside the
+// contract or from a contract that inherits from it. That covers plain calls, calls through `this.` and `super.`,
+// calls qualified with the name of a base contract and functions passed around as values.
+// Overloads are not told apart, so a name that is used keeps all of its functions public.
+func internallyUsedFunctions(tree *ast.Tree, contracts []*ast.Contract) map[int64]map[string]bool {
+	used := make(map[int64]map[string]bool, 0)
+	for _, contract := range contracts {
+		inherited := inheritedContracts(tree, contract)
+		qualifiers := map[string]bool{"this": true, "super": true}
+		for _, c := range inherited {
+			if named, ok := c.(interface{ GetName() string }); ok {
+				qualifiers[named.GetName()] = true
+			}
+		}
+
+		names := make(map[string]bool, 0)
+		walk(contract, func(node ast.Node[ast.NodeType]) bool {
+			switch node := node.(type) {
+			case *ast.PrimaryExpression:
+				if node.GetType() == ast_pb.NodeType_IDENTIFIER {
+					names[node.GetName()] = true
+				}
+			case *ast.MemberAccessExpression:
+				if ident, ok := node.GetExpression().(*ast.PrimaryExpression); ok && qualifiers[ident.GetName()] {
+					names[node.GetMemberName()] = true
+				}
+			}
+			return true
+		})
+
+		// a function is used internally by every contract that inherits it
+		for _, c := range inherited {
+			if used[c.GetId()] == nil {
+				used[c.GetId()] = make(map[string]bool, 0)
+			}
+			for name := range names {
+				used[c.GetId()][name] = true
+			}
+		}
+	}
+	return used
+}
+
+// canBeExternal checks that the function stays compatible with the functions it overrides.
+// An external function can be overridden by a public one but not the other way around, so every inherited
+// function with the same name has to be external, as the ones declared in interfaces are.
+func canBeExternal(fn *ast.Function, bases []ast.Node[ast.NodeType]) bool {
+	found := false
+	for _, base := range bases {
+		for _, node := range base.GetNodes() {
+			inherited, ok := node.(*ast.Function)
+			if !ok || inherited.GetName() != fn.GetName() {
+				continue
+			}
+			if inherited.GetVisibility() != ast_pb.Visibility_EXTERNAL {
+				return false
+			}
+			found = true
+		}
+	}
+	// the overridden function is declared somewhere we cannot see
+	if len(fn.Overrides) > 0 && !found {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+// test file for external_functions.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const externalContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+interface IVault {
+    function deposit(uint256[] calldata amounts) external returns (uint256);
+}
+
+contract Vault is IVault {
+    uint256 public balance;
+
+    function deposit(uint256[] memory amounts) public override returns (uint256) {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            balance += amounts[i];
+        }
+        return balance;
+    }
+
+    function reset(uint256[] memory amounts) public {
+        amounts[0] = 0;
+        balance = amounts[0];
+    }
+
+    function fee(uint256 amount) public virtual returns (uint256) {
+        return amount / 100;
+    }
+
+    function price(uint256 amount) public pure returns (uint256) {
+        return amount * 2;
+    }
+
+    function quote(uint256 amount) public view returns (uint256) {
+        return this.price(amount);
+    }
+
+    function label(string memory name) public pure returns (uint256) {
+        return bytes(name).length;
+    }
+
+    function limit() public pure returns (uint256) {
+        return 10;
+    }
+}
+
+contract FeeVault is Vault {
+    function fee(uint256 amount) public override returns (uint256) {
+        return super.fee(amount) + limit();
+    }
+}
+`
+
+// finds the function declared in the contract
+func findFunction(builder *ir.Builder, contractName string, name string) *ast.Function {
+	for _, contract := range builder.GetRoot().GetContracts() {
+		if contract.GetName() != contractName {
+			continue
+		}
+		for _, f := range contract.GetFunctions() {
+			if f.GetName() == name {
+				return f.GetAST()
+			}
+		}
+	}
+	return nil
+}
+
+func TestPromoteExternalFunctions(t *testing.T) {
+	builder := setUpBuilder(t, externalContract)
+	optimizer.NewOptimizer(builder).PromoteExternalFunctions()
+
+	// implements an interface function, which is external already
+	deposit := findFunction(builder, "Vault", "deposit")
+	assert.Equal(t, ast_pb.Visibility_EXTERNAL, deposit.GetVisibility())
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, deposit.GetParameters().GetParameters()[0].GetStorageLocation())
+
+	// the parameter is written to, so it has to stay in memory
+	reset := findFunction(builder, "Vault", "reset")
+	assert.Equal(t, ast_pb.Visibility_EXTERNAL, reset.GetVisibility())
+	assert.Equal(t, ast_pb.StorageLocation_MEMORY, reset.GetParameters().GetParameters()[0].GetStorageLocation())
+
+	// only read through a conversion
+	label := findFunction(builder, "Vault", "label")
+	assert.Equal(t, ast_pb.Visibility_EXTERNAL, label.GetVisibility())
+	assert.Equal(t, ast_pb.StorageLocation_CALLDATA, label.GetParameters().GetParameters()[0].GetStorageLocation())
+
+	// called through super
+	assert.Equal(t, ast_pb.Visibility_PUBLIC, findFunction(builder, "Vault", "fee").GetVisibility())
+	// overrides a public function
+	assert.Equal(t, ast_pb.Visibility_PUBLIC, findFunction(builder, "FeeVault", "fee").GetVisibility())
+	// called through this
+	assert.Equal(t, ast_pb.Visibility_PUBLIC, findFunction(builder, "Vault", "price").GetVisibility())
+	assert.Equal(t, ast_pb.Visibility_EXTERNAL, findFunction(builder, "Vault", "quote").GetVisibility())
+	// called from a derived contract
+	assert.Equal(t, ast_pb.Visibility_PUBLIC, findFunction(builder, "Vault", "limit").GetVisibility())
+}
@@ -0,0 +1,140 @@
+// Estimates the gas saved by caching a state variable in a local variable, and by the other rewrites
+package optimizer
+
+import (
+	"fmt"
+	"math"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// GasModel holds the gas costs and execution assumptions that decide whether caching pays off.
+// Costs follow EIP-2929: the first read of a slot in a transaction is cold, later ones are warm.
+type GasModel struct {
+	ColdSload  int
+	WarmSload  int
+	Sstore     int // writing a slot that was already written in the transaction
+	StackRead  int
+	StackWrite int
+	// LoopIterations is the assumed trip count of every loop
+	LoopIterations int
+	// BranchProbability is the assumed chance that a branch of an if, a conditional or a && or || is taken
+	BranchProbability float64
+}
+
+// DefaultGasModel is used when no model is given
+var DefaultGasModel = GasModel{
+	ColdSload:         2100,
+	WarmSload:         100,
+	Sstore:            100,
+	StackRead:         3,
+	StackWrite:        3,
+	LoopIterations:    10,
+	BranchProbability: 0.5,
+}
+
+// Validate returns an error for a model the estimates make no sense with: a negative cost or trip count,
+// or a branch probability outside of 0 to 1
+func (m GasModel) Validate() error {
+	names := []string{"cold SLOAD", "warm SLOAD", "SSTORE", "stack read", "stack write", "loop iterations"}
+	for i, value := range []int{m.ColdSload, m.WarmSload, m.Sstore, m.StackRead, m.StackWrite, m.LoopIterations} {
+		if value < 0 {
+			return fmt.Errorf("the %s of the gas model is negative: %d", names[i], value)
+		}
+	}
+	if m.BranchProbability < 0 || m.BranchProbability > 1 {
+		return fmt.Errorf("the branch probability of the gas model is not between 0 and 1: %g", m.BranchProbability)
+	}
+	return nil
+}
+
+// rough gas costs of the rewrites whose savings do not depend on the gas model
+const (
+	sstoreSetCost        = 20000 // writing a slot that was zero, paid once for every slot packing frees
+	postfixCost          = 5     // keeping the old value of i++ on the stack
+	checkedIncrementCost = 30    // the overflow check of ++i
+	memoryCopyCost       = 100   // copying a short calldata argument to memory
+	checkedExpCost       = 200   // a checked exponentiation
+	checkedMulCost       = 30    // a checked multiplication
+	codeDepositCost      = 200   // deploying a byte of code, such as a byte of a revert message
+)
+
+// weights returns how many times each node of the body is expected to run, relative to one call of the function.
+// Loops multiply by the assumed trip count and branches by the chance of being taken.
+func (m GasModel) weights(body *ast.BodyNode) map[ast.Node[ast.NodeType]]float64 {
+	weights := make(map[ast.Node[ast.NodeType]]float64, 0)
+	var visit func(node ast.Node[ast.NodeType], weight float64)
+	visitAll := func(nodes []ast.Node[ast.NodeType], weight float64) {
+		for _, node := range nodes {
+			visit(node, weight)
+		}
+	}
+	visit = func(node ast.Node[ast.NodeType], weight float64) {
+		if isNilNode(node) {
+			return
+		}
+		// the same node can be reached through more than one parent
+		if _, seen := weights[node]; seen {
+			return
+		}
+		weights[node] = weight
+		taken := weight * m.BranchProbability
+		switch node := node.(type) {
+		case *ast.ForStatement:
+			visit(node.Initialiser, weight)
+			visitAll(node.GetNodes(), weight*float64(m.LoopIterations))
+		case *ast.WhileStatement, *ast.DoWhileStatement:
+			visitAll(node.GetNodes(), weight*float64(m.LoopIterations))
+		case *ast.IfStatement:
+			visit(node.GetCondition(), weight)
+			body, ok := node.GetBody().(*ast.BodyNode)
+			// without braces the else statement is appended to the if body
+			if ok && body.GetSrc().End == 0 && len(body.GetStatements()) > 1 {
+				weights[body] = weight
+				visit(body.GetStatements()[0], taken)
+				visitAll(body.GetStatements()[1:], weight-taken)
+				return
+			}
+			visit(node.GetBody(), taken)
+		case *ast.Conditional:
+			if len(node.Expressions) == 3 {
+				visit(node.Expressions[0], weight)
+				visit(node.Expressions[1], taken)
+				visit(node.Expressions[2], weight-taken)
+				return
+			}
+			visitAll(node.GetNodes(), weight)
+		case *ast.AndOperation:
+			if len(node.GetExpressions()) == 2 {
+				visit(node.GetExpressions()[0], weight)
+				visit(node.GetExpressions()[1], taken)
+				return
+			}
+			visitAll(node.GetNodes(), weight)
+		case *ast.BinaryOperation:
+			if node.GetOperator() == ast_pb.Operator_OR {
+				visit(node.LeftExpression, weight)
+				visit(node.RightExpression, taken)
+				return
+			}
+			visitAll(node.GetNodes(), weight)
+		default:
+			visitAll(node.GetNodes(), weight)
+		}
+	}
+	visit(body, 1)
+	return weights
+}
+
+// cachingSavings estimates the gas saved by caching a value that takes a single storage slot in a local variable,
+// given the expected number of times it is read and written. The estimate is negative when caching costs more than it saves.
+//
+// Without caching, the first read is cold and later ones warm, and every write is a storage write.
+// With caching, the slot is read cold once and copied to the stack, reads and writes go to the copy,
+// and a written value is stored back once.
+func (m GasModel) cachingSavings(reads, writes float64) int {
+	uncached := math.Min(reads, 1)*float64(m.ColdSload) + math.Max(reads-1, 0)*float64(m.WarmSload) + writes*float64(m.Sstore)
+	cached := float64(m.ColdSload+m.StackWrite) + reads*float64(m.StackRead) + writes*float64(m.StackWrite) + math.Min(writes, 1)*float64(m.Sstore)
+	return int(math.Round(uncached - cached))
+}
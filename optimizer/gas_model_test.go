@@ -0,0 +1,21 @@
+// test file for gas_model.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasModelValidate(t *testing.T) {
+	assert.NoError(t, optimizer.DefaultGasModel.Validate())
+
+	model := optimizer.DefaultGasModel
+	model.WarmSload = -1
+	assert.ErrorContains(t, model.Validate(), "warm SLOAD")
+
+	model = optimizer.DefaultGasModel
+	model.BranchProbability = 1.5
+	assert.ErrorContains(t, model.Validate(), "branch probability")
+}
@@ -0,0 +1,216 @@
+// Hoists compound assignments to state variables out of loops into a local accumulator
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+func (o *Optimizer) optimizeLoopAccumulators() []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
+	contracts := o.builder.GetRoot().GetContracts()
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		astContract, ok := contract.GetAST().GetContract().(*ast.Contract)
+		if !ok {
+			continue
+		}
+		visible := visibleStateVariables(tree, astContract)
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil || fn.GetStateMutability() == ast_pb.Mutability_VIEW || fn.GetStateMutability() == ast_pb.Mutability_PURE {
+				continue
+			}
+			stateVariables, references := collectStateVariableReferences(fn, visible)
+			h := &loopHoister{
+				contract:       contract.GetName(),
+				function:       fn.GetName(),
+				body:           fn.GetBody(),
+				stateVariables: stateVariables,
+				references:     references,
+				names:          localNames(fn),
+			}
+			h.hoistLoops(fn.GetBody())
+			changes = append(changes, h.changes...)
+		}
+	}
+	return changes
+}
+
+// loopHoister holds the state of a function while its loops are rewritten
+type loopHoister struct {
+	contract       string
+	function       string
+	body           *ast.BodyNode
+	stateVariables map[int64]*ast.StateVariableDeclaration
+	references     map[int64][]*ast.PrimaryExpression
+	// names already declared in the function, so accumulators of sibling loops do not clash
+	names map[string]bool
+	// changes holds a change for every loop rewritten
+	changes []Change
+}
+
+// hoistLoops looks for loops in the block, outermost first, and hoists the state variables
+// that are accumulated inside them
+func (h *loopHoister) hoistLoops(block *ast.BodyNode) {
+	statements := make([]ast.Node[ast.NodeType], 0, len(block.GetStatements()))
+	for _, stmt := range block.GetStatements() {
+		if isLoop(stmt) {
+			before, after := h.hoistLoop(stmt)
+			if len(before) > 0 {
+				// every accumulator is read and written once per iteration
+				iterations := float64(DefaultGasModel.LoopIterations)
+				change := newChange(h.contract, h.function, stmt.GetSrc(), nil,
+					"the loop accumulates into a local variable that is written to storage once after the loop",
+					-len(before)*DefaultGasModel.cachingSavings(iterations, iterations))
+				change.after = append(append(append([]ast.Node[ast.NodeType]{}, before...), stmt), after...)
+				h.changes = append(h.changes, change)
+			}
+			statements = append(statements, before...)
+			statements = append(statements, stmt)
+			statements = append(statements, after...)
+		} else {
+			statements = append(statements, stmt)
+		}
+
+		// look for loops further down as well
+		switch stmt := stmt.(type) {
+		case *ast.IfStatement:
+			if body, ok := stmt.GetBody().(*ast.BodyNode); ok {
+				h.hoistLoops(body)
+			}
+		case *ast.BodyNode:
+			h.hoistLoops(stmt)
+		case *ast.ForStatement, *ast.WhileStatement, *ast.DoWhileStatement:
+			h.hoistLoops(loopBody(stmt))
+		}
+	}
+	block.Statements = statements
+}
+
+// hoistLoop replaces every state variable that is accumulated inside the loop by a local variable.
+// It returns the declarations to put before the loop and the writes to storage to put after it.
+func (h *loopHoister) hoistLoop(loop ast.Node[ast.NodeType]) ([]ast.Node[ast.NodeType], []ast.Node[ast.NodeType]) {
+	before := make([]ast.Node[ast.NodeType], 0)
+	after := make([]ast.Node[ast.NodeType], 0)
+	if !canHoistLoop(h.body.GetTree(), loop) {
+		return before, after
+	}
+
+	inLoop := make(map[*ast.PrimaryExpression]bool, 0)
+	walk(loop, func(node ast.Node[ast.NodeType]) bool {
+		if ident, ok := node.(*ast.PrimaryExpression); ok {
+			inLoop[ident] = true
+		}
+		return true
+	})
+	accumulated := accumulatedIdentifiers(loop)
+
+	// go through the variables in declaration order so the output is deterministic
+	ids := make([]int64, 0, len(h.references))
+	for id := range h.references {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		sv := h.stateVariables[id]
+		if !usesStorage(sv) {
+			continue
+		}
+		// only value types fit in a local accumulator
+		if _, ok := sizeMap[sv.GetTypeName().GetName()]; !ok {
+			continue
+		}
+		loopReferences := make([]*ast.PrimaryExpression, 0)
+		otherReferences := make([]*ast.PrimaryExpression, 0)
+		isAccumulated := false
+		for _, ref := range h.references[id] {
+			if inLoop[ref] {
+				loopReferences = append(loopReferences, ref)
+				isAccumulated = isAccumulated || accumulated[ref]
+			} else {
+				otherReferences = append(otherReferences, ref)
+			}
+		}
+		if !isAccumulated {
+			continue
+		}
+		// the loop references now point at the accumulator, so inner loops must not hoist them again
+		h.references[id] = otherReferences
+
+		accumulator := &ast.Declaration{
+			Id:       nextID(h.body),
+			Name:     uniqueName(h.names, fmt.Sprintf("accumulated_%s", sv.GetName())),
+			NodeType: ast_pb.NodeType_VARIABLE_DECLARATION,
+			TypeName: sv.GetTypeName(),
+		}
+		before = append(before, &ast.VariableDeclaration{
+			Id:           nextID(h.body),
+			Declarations: []*ast.Declaration{accumulator},
+			NodeType:     ast_pb.NodeType_VARIABLE_DECLARATION,
+			InitialValue: newStateVariableIdentifier(sv),
+		})
+		renameReferences(loopReferences, accumulator)
+		after = append(after, &ast.Assignment{
+			Id:              nextID(h.body),
+			NodeType:        ast_pb.NodeType_ASSIGNMENT,
+			Operator:        ast_pb.Operator_EQUAL,
+			LeftExpression:  newStateVariableIdentifier(sv),
+			RightExpression: newLocalIdentifier(accumulator, sv),
+			TypeDescription: sv.GetTypeDescription(),
+		})
+	}
+	return before, after
+}
+
+// canHoistLoop checks that every way out of the loop goes past the write after it.
+// A `break` still lands right after the loop, but a `return` skips the write and a call that may touch
+// storage could observe the accumulator instead of the state variable.
+func canHoistLoop(tree *ast.Tree, loop ast.Node[ast.NodeType]) bool {
+	if hasUnsupportedStatement(loopBody(loop)) || containsReturn(loop) || containsStorageCall(tree, loop) {
+		return false
+	}
+	hiddenElse := false
+	walk(loop, func(node ast.Node[ast.NodeType]) bool {
+		if stmt, ok := node.(*ast.IfStatement); ok && hasElseBranch(stmt) {
+			hiddenElse = true
+		}
+		return !hiddenElse
+	})
+	return !hiddenElse
+}
+
+// accumulatedIdentifiers returns the identifiers that are updated in place inside the node,
+// either by a compound assignment such as `+=` or by an increment or decrement
+func accumulatedIdentifiers(node ast.Node[ast.NodeType]) map[*ast.PrimaryExpression]bool {
+	accumulated := make(map[*ast.PrimaryExpression]bool, 0)
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		var target ast.Node[ast.NodeType]
+		switch n := n.(type) {
+		case *ast.Assignment:
+			if n.Expression == nil && n.Operator != ast_pb.Operator_EQUAL {
+				target = n.LeftExpression
+			}
+		case *ast.UnaryPrefix:
+			if isIncrementOrDecrement(n.GetOperator()) {
+				target = n.GetExpression()
+			}
+		case *ast.UnarySuffix:
+			if isIncrementOrDecrement(n.GetOperator()) {
+				target = n.GetExpression()
+			}
+		}
+		if ident, ok := target.(*ast.PrimaryExpression); ok {
+			accumulated[ident] = true
+		}
+		return true
+	})
+	return accumulated
+}
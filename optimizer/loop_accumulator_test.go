@@ -0,0 +1,101 @@
+// test file for loop_accumulator.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const accumulatorContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+interface IFeed {
+    function price() external returns (uint256);
+}
+
+contract Accumulator {
+    uint256 public total;
+    uint256 public count;
+    IFeed public feed;
+
+    function add(uint256[] calldata amounts) external {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            total += amounts[i];
+            count++;
+        }
+        uint256 j = 0;
+        while (j < amounts.length) {
+            total -= amounts[j];
+            j++;
+        }
+    }
+
+    function withReturn(uint256[] calldata amounts) external {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            if (amounts[i] == 0) {
+                return;
+            }
+            total += amounts[i];
+        }
+    }
+
+    function withCall(uint256[] calldata amounts) external {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            total += amounts[i] * feed.price();
+        }
+    }
+
+    function withElse(uint256[] calldata amounts) external {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            if (amounts[i] > 10) {
+                total += amounts[i];
+            } else {
+                count++;
+            }
+        }
+    }
+}
+`
+
+func TestHoistLoopAccumulators(t *testing.T) {
+	builder := setUpBuilder(t, accumulatorContract)
+	opt := optimizer.NewOptimizer(builder)
+	changes := opt.HoistLoopAccumulators()
+	if assert.Len(t, changes, 2) {
+		for _, change := range changes {
+			assert.Equal(t, "add", change.Function)
+			assert.Negative(t, change.GasDelta)
+		}
+	}
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// every accumulated state variable gets a local variable before the loop and is written back after it,
+	// and the accumulator of the second loop gets a name of its own
+	assert.Contains(t, code, `    function add(uint256[] calldata amounts) external {
+        uint256 accumulated_total = total;
+        uint256 accumulated_count = count;
+        for (uint256 i = 0; i < amounts.length; i++) {
+            accumulated_total += amounts[i];
+            accumulated_count++;
+        }
+        total = accumulated_total;
+        count = accumulated_count;
+        uint256 j = 0;
+        uint256 accumulated_total_2 = total;
+        while (j < amounts.length) {
+            accumulated_total_2 -= amounts[j];
+            j++;
+        }
+        total = accumulated_total_2;
+    }`)
+
+	// a return skips the write after the loop, a call may read the state variable and the else branch
+	// is not kept apart by the parser
+	for _, name := range []string{"withReturn", "withCall", "withElse"} {
+		assert.Empty(t, localDeclarations(builder, "Accumulator", name), name)
+	}
+	assert.Empty(t, opt.HoistLoopAccumulators())
+}
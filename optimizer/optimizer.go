@@ -1,26 +1,112 @@
 package optimizer
 
 import (
+	"github.com/unpackdev/solgo/ast"
 	"github.com/unpackdev/solgo/ir"
 	"go.uber.org/zap"
 )
 
+// Optimizer rewrites the AST of a built contract. Every method running a pass returns the changes it made,
+// which are also added to the Result.
 type Optimizer struct {
 	builder *ir.Builder
+	changes []Change
+	// original is the tree as it was built, which Edits compares the rewritten tree with
+	original map[ast.Node[ast.NodeType]]*nodeSnapshot
+	files    []*sourceFile
 }
 
+// NewOptimizer takes a builder that has already built the AST and resolved its references
 func NewOptimizer(builder *ir.Builder) *Optimizer {
 	return &Optimizer{
-		builder: builder,
+		builder:  builder,
+		original: snapshotTree(builder.GetAstBuilder().GetRoot()),
+		files:    sourceFiles(builder),
 	}
 }
 
-func (o *Optimizer) PackStructs() {
+// EliminateDeadCode removes unreferenced private and internal functions, private state variables, structs,
+// events and errors, and the statements that follow a return or revert. Everything removed is logged.
+// With keepStorageLayout, the state variables that take a storage slot are kept, so the others keep their slots.
+func (o *Optimizer) EliminateDeadCode(keepStorageLayout bool) []Change {
+	zap.L().Info("Eliminating dead code")
+	return o.record("eliminate-dead-code", o.optimizeDeadCode(keepStorageLayout))
+}
+
+func (o *Optimizer) PackStructs() []Change {
 	zap.L().Info("Packing structs")
-	o.optimizeStructPacking()
+	return o.record("pack-structs", o.optimizeStructPacking())
+}
+
+// PromoteConstants makes state variables that only get a literal in their declaration constant,
+// and the ones that are only written during construction immutable
+func (o *Optimizer) PromoteConstants() []Change {
+	zap.L().Info("Promoting state variables to constant and immutable")
+	return o.record("promote-constants", o.optimizeConstantPromotion())
+}
+
+func (o *Optimizer) PackStateVariables() []Change {
+	zap.L().Info("Packing state variables")
+	return o.record("pack-state-variables", o.optimizeStateVariablePacking())
+}
+
+func (o *Optimizer) PromoteExternalFunctions() []Change {
+	zap.L().Info("Promoting public functions to external")
+	return o.record("promote-external-functions", o.optimizeExternalFunctions())
+}
+
+func (o *Optimizer) HoistLoopAccumulators() []Change {
+	zap.L().Info("Hoisting loop accumulators")
+	return o.record("hoist-loop-accumulators", o.optimizeLoopAccumulators())
+}
+
+// ExpandExponentiation rewrites `a ** k` into multiplications for literal exponents up to maxExponent,
+// or DefaultMaxExponent if maxExponent is not positive
+func (o *Optimizer) ExpandExponentiation(maxExponent int) []Change {
+	zap.L().Info("Expanding exponentiation", zap.Int("max exponent", maxExponent))
+	return o.record("expand-exponentiation", o.optimizeExponentiation(maxExponent))
+}
+
+// ReorderConditions moves the cheapest operands of && and || chains to the front, so that the expensive ones
+// are skipped more often. Operands that may revert or have side effects are never moved.
+func (o *Optimizer) ReorderConditions() []Change {
+	zap.L().Info("Reordering short-circuit conditions")
+	return o.record("reorder-conditions", o.optimizeConditionOrdering())
+}
+
+func (o *Optimizer) UsePrefixIncrements() []Change {
+	zap.L().Info("Rewriting postfix increments to prefix")
+	return o.record("prefix-increments", o.optimizePrefixIncrements())
+}
+
+// UseCustomErrors replaces require and revert messages with custom errors named after the message.
+// Contracts whose pragma allows compilers older than 0.8.4 are left untouched.
+func (o *Optimizer) UseCustomErrors() []Change {
+	zap.L().Info("Replacing revert messages with custom errors")
+	return o.record("custom-errors", o.optimizeCustomErrors())
+}
+
+// CacheStorageVariables caches state variables read or written more than once in a function in local variables,
+// when the gas model estimates that it saves gas. Arrays, structs and mappings are never cached as a whole,
+// only their elements and fields are. The zero model stands for DefaultGasModel.
+func (o *Optimizer) CacheStorageVariables(model GasModel) []Change {
+	if model == (GasModel{}) {
+		model = DefaultGasModel
+	}
+	zap.L().Info("Caching storage variables", zap.Int("loop iterations", model.LoopIterations))
+	return o.record("cache-storage-variables", o.optimizeStorageVariableCaching(model))
+}
+
+// CacheArrayLengths reads the length of a storage or memory array once before a loop whose condition reads it,
+// when neither the loop nor the functions it calls can push to, pop from or reassign the array
+func (o *Optimizer) CacheArrayLengths() []Change {
+	zap.L().Info("Caching array lengths in loop conditions")
+	return o.record("cache-array-lengths", o.optimizeArrayLengthCaching())
 }
 
-func (o *Optimizer) CacheStorageVariables() {
-	zap.L().Info("Caching storage variables")
-	o.optimizeStorageVariableCaching()
+// UncheckLoopIncrements moves the increment of bounded for loop counters into an unchecked block.
+// Contracts whose pragma allows compilers older than 0.8.0 are left untouched.
+func (o *Optimizer) UncheckLoopIncrements() []Change {
+	zap.L().Info("Unchecking loop increments")
+	return o.record("unchecked-loop-increments", o.optimizeUncheckedLoopIncrements())
 }
@@ -0,0 +1,135 @@
+// Registry of the optimization passes, so callers can select them by name
+package optimizer
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// Pass is an optimization that rewrites the AST held by an Optimizer
+type Pass interface {
+	// Name selects the pass, it is also the name of its command line flag
+	Name() string
+	Description() string
+	// MinSolidityVersion is the oldest compiler every rewrite of the pass compiles with, such as "0.8.4",
+	// or "" if they all compile with any version. It is for display, the runners do not check it: the pass itself
+	// only makes the rewrites that the compilers allowed by the pragma of a contract accept, if any.
+	MinSolidityVersion() string
+	// Run applies the pass and returns the changes it made
+	Run(o *Optimizer, options PassOptions) []Change
+}
+
+// PassOptions holds the settings of the passes that take any. The zero value gives every pass its defaults.
+type PassOptions struct {
+	// AggressiveCallData splits public functions called internally with memory arguments
+	AggressiveCallData bool
+	// MaxExponent is the largest literal exponent expanded into multiplications
+	MaxExponent int
+	// GasModel decides which storage variables are worth caching
+	GasModel GasModel
+	// KeepStorageLayout leaves every state variable in its storage slot, for contracts behind a proxy
+	KeepStorageLayout bool
+}
+
+var (
+	passes    = make(map[string]Pass, 0)
+	passOrder = make([]string, 0)
+)
+
+// Register adds the pass to the registry. Passes run in the order they are registered unless the caller picks one.
+// It panics if a pass with the same name is already registered.
+func Register(pass Pass) {
+	if _, ok := passes[pass.Name()]; ok {
+		panic(fmt.Sprintf("optimizer: pass %s registered twice", pass.Name()))
+	}
+	passes[pass.Name()] = pass
+	passOrder = append(passOrder, pass.Name())
+}
+
+// Passes returns the registered passes in registration order
+func Passes() []Pass {
+	registered := make([]Pass, 0, len(passOrder))
+	for _, name := range passOrder {
+		registered = append(registered, passes[name])
+	}
+	return registered
+}
+
+// LookupPass returns the pass registered under the name
+func LookupPass(name string) (Pass, bool) {
+	pass, ok := passes[name]
+	return pass, ok
+}
+
+// RunPasses runs the named passes in the given order. Nothing is run if one of the names is not registered.
+func (o *Optimizer) RunPasses(names []string, options PassOptions) error {
+	selected := make([]Pass, 0, len(names))
+	for _, name := range names {
+		pass, ok := LookupPass(name)
+		if !ok {
+			return fmt.Errorf("unknown optimization pass %q", name)
+		}
+		selected = append(selected, pass)
+	}
+	for _, pass := range selected {
+		zap.L().Debug("Running pass", zap.String("pass", pass.Name()))
+		pass.Run(o, options)
+	}
+	return nil
+}
+
+// builtinPass is a Pass backed by one of the Optimizer methods
+type builtinPass struct {
+	name        string
+	description string
+	minVersion  solidityVersion
+	run         func(o *Optimizer, options PassOptions) []Change
+}
+
+func (p *builtinPass) Name() string        { return p.name }
+func (p *builtinPass) Description() string { return p.description }
+
+func (p *builtinPass) MinSolidityVersion() string {
+	if p.minVersion == (solidityVersion{}) {
+		return ""
+	}
+	return p.minVersion.String()
+}
+
+func (p *builtinPass) Run(o *Optimizer, options PassOptions) []Change {
+	return p.run(o, options)
+}
+
+func (v solidityVersion) String() string {
+	return strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+}
+
+// the built in passes, in the order they are run when more than one is selected:
+// dead code goes first so the later passes have less to look at, and functions are promoted to external
+// before their parameters are moved to calldata
+func init() {
+	for _, pass := range []*builtinPass{
+		{name: "eliminate-dead-code", description: "Remove unused private and internal declarations and unreachable statements", run: func(o *Optimizer, options PassOptions) []Change {
+			return o.EliminateDeadCode(options.KeepStorageLayout)
+		}},
+		{name: "pack-structs", description: "Pack structs", run: func(o *Optimizer, _ PassOptions) []Change { return o.PackStructs() }},
+		{name: "promote-constants", description: "Make state variables that are never written after construction constant or immutable", minVersion: immutableVersion, run: func(o *Optimizer, _ PassOptions) []Change { return o.PromoteConstants() }},
+		{name: "pack-state-variables", description: "Pack state variables", run: func(o *Optimizer, _ PassOptions) []Change { return o.PackStateVariables() }},
+		{name: "promote-external-functions", description: "Make public functions that are never called internally external", run: func(o *Optimizer, _ PassOptions) []Change { return o.PromoteExternalFunctions() }},
+		{name: "optimize-call-data", description: "Optimize call data", minVersion: calldataVersion, run: func(o *Optimizer, options PassOptions) []Change {
+			return o.OptimizeCallData(options.AggressiveCallData)
+		}},
+		{name: "hoist-loop-accumulators", description: "Hoist storage writes out of loops", run: func(o *Optimizer, _ PassOptions) []Change { return o.HoistLoopAccumulators() }},
+		{name: "expand-exponentiation", description: "Rewrite exponentiation with a small literal exponent into multiplications", run: func(o *Optimizer, options PassOptions) []Change { return o.ExpandExponentiation(options.MaxExponent) }},
+		{name: "reorder-conditions", description: "Evaluate the cheapest operands of && and || first", run: func(o *Optimizer, _ PassOptions) []Change { return o.ReorderConditions() }},
+		{name: "prefix-increments", description: "Rewrite unused postfix increments and decrements into prefix form", run: func(o *Optimizer, _ PassOptions) []Change { return o.UsePrefixIncrements() }},
+		{name: "custom-errors", description: "Replace require and revert messages with custom errors", minVersion: customErrorsVersion, run: func(o *Optimizer, _ PassOptions) []Change { return o.UseCustomErrors() }},
+		{name: "cache-storage-variables", description: "Cache storage variables", run: func(o *Optimizer, options PassOptions) []Change { return o.CacheStorageVariables(options.GasModel) }},
+		{name: "unchecked-loop-increments", description: "Increment bounded for loop counters in an unchecked block", minVersion: uncheckedVersion, run: func(o *Optimizer, _ PassOptions) []Change { return o.UncheckLoopIncrements() }},
+		{name: "cache-array-lengths", description: "Read the length of arrays once before loops whose condition reads it", run: func(o *Optimizer, _ PassOptions) []Change { return o.CacheArrayLengths() }},
+	} {
+		Register(pass)
+	}
+}
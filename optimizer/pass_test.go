@@ -0,0 +1,57 @@
+// test file for pass.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasses(t *testing.T) {
+	names := make([]string, 0)
+	for _, pass := range optimizer.Passes() {
+		names = append(names, pass.Name())
+		assert.NotEmpty(t, pass.Description())
+	}
+	// dead code goes first and functions are made external before their parameters move to calldata
+	assert.Equal(t, "eliminate-dead-code", names[0])
+	assert.Less(t, indexOf(names, "promote-external-functions"), indexOf(names, "optimize-call-data"))
+
+	pass, ok := optimizer.LookupPass("custom-errors")
+	assert.True(t, ok)
+	assert.Equal(t, "0.8.4", pass.MinSolidityVersion())
+	// the version every rewrite needs, immutable for promote-constants and calldata parameters of public functions
+	pass, ok = optimizer.LookupPass("promote-constants")
+	assert.True(t, ok)
+	assert.Equal(t, "0.6.5", pass.MinSolidityVersion())
+	pass, ok = optimizer.LookupPass("optimize-call-data")
+	assert.True(t, ok)
+	assert.Equal(t, "0.6.9", pass.MinSolidityVersion())
+	pass, ok = optimizer.LookupPass("pack-structs")
+	assert.True(t, ok)
+	assert.Empty(t, pass.MinSolidityVersion())
+	_, ok = optimizer.LookupPass("pack-everything")
+	assert.False(t, ok)
+}
+
+func TestRunPasses(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	opt := optimizer.NewOptimizer(builder)
+
+	// nothing runs when a name is unknown
+	assert.Error(t, opt.RunPasses([]string{"cache-storage-variables", "pack-everything"}, optimizer.PassOptions{}))
+	assert.Empty(t, cachedVariables(builder, "twice"))
+
+	assert.NoError(t, opt.RunPasses([]string{"cache-storage-variables"}, optimizer.PassOptions{}))
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "twice"))
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+// Runs ordered lists of passes until they stop changing the code, with preset lists for each optimization level
+package optimizer
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// DefaultMaxIterations is the number of rounds a pipeline runs at most when no cap is given
+const DefaultMaxIterations = 10
+
+// Levels holds the passes of each optimization level, from -O0 to -O3, in the order they run.
+// Each level adds to the one before it:
+//   - -O1 only makes local rewrites that keep the behaviour of every function
+//   - -O2 also changes visibility and parameter locations, and caches storage reads based on the gas model
+//   - -O3 also changes the storage layout, which breaks upgradeable contracts: it removes unused private state
+//     variables, makes state variables constant or immutable and reorders them. It also replaces revert messages
+//     with custom errors, which changes the revert data, and splits public functions called internally.
+//
+// Below -O3 every state variable stays in its storage slot, see LevelOptions.
+var Levels = [][]string{
+	{},
+	{"eliminate-dead-code", "prefix-increments", "unchecked-loop-increments", "cache-array-lengths"},
+	{"eliminate-dead-code", "promote-external-functions", "optimize-call-data", "hoist-loop-accumulators",
+		"expand-exponentiation", "reorder-conditions", "prefix-increments", "cache-storage-variables", "unchecked-loop-increments", "cache-array-lengths"},
+	{"eliminate-dead-code", "pack-structs", "promote-constants", "pack-state-variables", "promote-external-functions", "optimize-call-data",
+		"hoist-loop-accumulators", "expand-exponentiation", "reorder-conditions", "prefix-increments", "custom-errors",
+		"cache-storage-variables", "unchecked-loop-increments", "cache-array-lengths"},
+}
+
+// LevelOptions returns the pass options used at the optimization level. -O1 and -O2 keep the storage layout,
+// so dead code elimination leaves the unused state variables in place.
+func LevelOptions(level int) PassOptions {
+	return PassOptions{
+		AggressiveCallData: level >= 3,
+		MaxExponent:        DefaultMaxExponent,
+		GasModel:           DefaultGasModel,
+		KeepStorageLayout:  level == 1 || level == 2,
+	}
+}
+
+// LevelPasses returns the passes of the optimization level in the order they run
+func LevelPasses(level int) ([]string, error) {
+	if level < 0 || level >= len(Levels) {
+		return nil, fmt.Errorf("unknown optimization level %d, expected 0 to %d", level, len(Levels)-1)
+	}
+	return Levels[level], nil
+}
+
+// PipelinePasses returns the passes of the optimization level together with the extra ones, in registration order
+func PipelinePasses(level int, extra []string) ([]string, error) {
+	names, err := LevelPasses(level)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool, 0)
+	for _, list := range [][]string{names, extra} {
+		for _, name := range list {
+			if _, ok := LookupPass(name); !ok {
+				return nil, fmt.Errorf("unknown optimization pass %q", name)
+			}
+			selected[name] = true
+		}
+	}
+	ordered := make([]string, 0, len(selected))
+	for _, name := range passOrder {
+		if selected[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered, nil
+}
+
+// RunPipeline runs the named passes in order, and runs them all again as long as one of them changes the code:
+// promoting a function to external lets its parameters move to calldata, and removing dead code leaves less
+// to cache. It stops after maxIterations rounds, or DefaultMaxIterations if maxIterations is not positive,
+// and returns the number of rounds run. Nothing is run if one of the names is not registered.
+func (o *Optimizer) RunPipeline(names []string, options PassOptions, maxIterations int) (int, error) {
+	if maxIterations <= 0 {
+		maxIterations = DefaultMaxIterations
+	}
+	for _, name := range names {
+		if _, ok := LookupPass(name); !ok {
+			return 0, fmt.Errorf("unknown optimization pass %q", name)
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	for round := 1; round <= maxIterations; round++ {
+		changed := false
+		for _, name := range names {
+			pass, _ := LookupPass(name)
+			if changes := pass.Run(o, options); len(changes) > 0 {
+				zap.L().Debug("Pass changed the code", zap.String("pass", name), zap.Int("round", round), zap.Int("changes", len(changes)))
+				changed = true
+			}
+		}
+		if !changed {
+			zap.L().Info("Pipeline reached a fixed point", zap.Int("rounds", round))
+			return round, nil
+		}
+	}
+	zap.L().Warn("Pipeline still changes the code after the last round", zap.Int("rounds", maxIterations))
+	return maxIterations, nil
+}
@@ -0,0 +1,53 @@
+// test file for pipeline.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelPasses(t *testing.T) {
+	passes, err := optimizer.LevelPasses(0)
+	assert.NoError(t, err)
+	assert.Empty(t, passes)
+	_, err = optimizer.LevelPasses(4)
+	assert.Error(t, err)
+
+	// every level runs the passes of the one below it
+	for level := 1; level < len(optimizer.Levels); level++ {
+		passes, err := optimizer.LevelPasses(level)
+		assert.NoError(t, err)
+		for _, name := range optimizer.Levels[level-1] {
+			assert.Contains(t, passes, name)
+		}
+	}
+
+	// extra passes are merged in registration order
+	passes, err = optimizer.PipelinePasses(1, []string{"pack-structs", "prefix-increments"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eliminate-dead-code", "pack-structs", "prefix-increments", "unchecked-loop-increments", "cache-array-lengths"}, passes)
+	_, err = optimizer.PipelinePasses(1, []string{"pack-everything"})
+	assert.Error(t, err)
+}
+
+func TestRunPipeline(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	opt := optimizer.NewOptimizer(builder)
+	passes, _ := optimizer.LevelPasses(3)
+
+	// the second round finds nothing left to change
+	rounds, err := opt.RunPipeline(passes, optimizer.LevelOptions(3), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rounds)
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "twice"))
+	for _, name := range passes {
+		pass, _ := optimizer.LookupPass(name)
+		assert.Empty(t, pass.Run(opt, optimizer.LevelOptions(3)), name)
+	}
+
+	rounds, err = opt.RunPipeline(nil, optimizer.LevelOptions(0), 0)
+	assert.NoError(t, err)
+	assert.Zero(t, rounds)
+}
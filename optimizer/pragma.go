@@ -0,0 +1,134 @@
+// Reads the compiler version a contract is written for from its version pragma
+package optimizer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+// solidityVersion is a compiler version such as 0.8.4
+type solidityVersion struct {
+	major int
+	minor int
+	patch int
+}
+
+func (v solidityVersion) less(other solidityVersion) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	if v.minor != other.minor {
+		return v.minor < other.minor
+	}
+	return v.patch < other.patch
+}
+
+var versionComparator = regexp.MustCompile(`(\^|~|>=|<=|>|<|=)?v?(\d+)(?:\.(\d+|x|X|\*))?(?:\.(\d+|x|X|\*))?`)
+
+// minimumSolidityVersion returns the lowest compiler version accepted by the version pragmas of the source unit.
+// It returns false if there is no version pragma or if it does not put a lower bound on the version.
+func minimumSolidityVersion(unit *ast.SourceUnit[ast.Node[ast_pb.SourceUnit]]) (solidityVersion, bool) {
+	minimum := solidityVersion{}
+	found := false
+	for _, node := range unit.GetNodes() {
+		pragma, ok := node.(*ast.Pragma)
+		if !ok {
+			continue
+		}
+		// the parser keeps the text of the directive without the whitespace between its tokens
+		text := strings.Join(strings.Fields(pragma.GetText()), "")
+		if !strings.HasPrefix(text, "pragmasolidity") {
+			continue
+		}
+		lower, ok := lowerBound(strings.TrimSuffix(strings.TrimPrefix(text, "pragmasolidity"), ";"))
+		if !ok {
+			return solidityVersion{}, false
+		}
+		// every pragma has to hold, so the highest lower bound wins
+		if !found || minimum.less(lower) {
+			minimum = lower
+		}
+		found = true
+	}
+	return minimum, found
+}
+
+// lowerBound returns the lowest version accepted by a version constraint such as `>=0.7.0 <0.9.0 || ^0.8.4`.
+// A `>` bound is treated like `>=`, which can only make the bound lower than it really is.
+func lowerBound(constraint string) (solidityVersion, bool) {
+	// a hyphen range `a - b` is the same as `>=a <=b`
+	constraint = strings.ReplaceAll(strings.Join(strings.Fields(constraint), ""), "-", "<=")
+	minimum := solidityVersion{}
+	for i, alternative := range strings.Split(constraint, "||") {
+		lower := solidityVersion{}
+		bounded := false
+		for _, match := range versionComparator.FindAllStringSubmatch(alternative, -1) {
+			if match[1] == "<" || match[1] == "<=" {
+				continue
+			}
+			version := solidityVersion{major: versionPart(match[2]), minor: versionPart(match[3]), patch: versionPart(match[4])}
+			if !bounded || lower.less(version) {
+				lower = version
+			}
+			bounded = true
+		}
+		if !bounded {
+			return solidityVersion{}, false
+		}
+		// any alternative may be picked, so the lowest one wins
+		if i == 0 || lower.less(minimum) {
+			minimum = lower
+		}
+	}
+	return minimum, true
+}
+
+// versionPart parses one number of a version, where a missing number or a wildcard counts as 0
+func versionPart(part string) int {
+	value, err := strconv.Atoi(part)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// requiresSolidity reports whether the source unit of the contract only compiles with the given version or a later one
+func requiresSolidity(contract *ir.Contract, version solidityVersion) bool {
+	unit := contract.GetAST()
+	if unit == nil {
+		return false
+	}
+	minimum, ok := minimumSolidityVersion(unit)
+	return ok && !minimum.less(version)
+}
+
+// abiCoderV2Version is the first version that uses ABI coder v2 by default
+var abiCoderV2Version = solidityVersion{major: 0, minor: 8, patch: 0}
+
+// usesABICoderV2 reports whether the source unit of the contract is compiled with ABI coder v2,
+// either by default or through `pragma abicoder v2` or `pragma experimental ABIEncoderV2`
+func usesABICoderV2(contract *ir.Contract) bool {
+	unit := contract.GetAST()
+	if unit == nil {
+		return false
+	}
+	enabled := requiresSolidity(contract, abiCoderV2Version)
+	for _, node := range unit.GetNodes() {
+		pragma, ok := node.(*ast.Pragma)
+		if !ok {
+			continue
+		}
+		switch strings.Join(strings.Fields(pragma.GetText()), "") {
+		case "pragmaabicoderv2;", "pragmaexperimentalABIEncoderV2;":
+			enabled = true
+		case "pragmaabicoderv1;":
+			return false
+		}
+	}
+	return enabled
+}
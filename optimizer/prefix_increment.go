@@ -0,0 +1,78 @@
+// Rewrites postfix increments and decrements whose value is not used into prefix form
+package optimizer
+
+import (
+	"github.com/unpackdev/solgo/ast"
+)
+
+func (o *Optimizer) optimizePrefixIncrements() []Change {
+	changes := make([]Change, 0)
+	rationale := "the value of the increment is not used, and the prefix form does not keep the old value"
+	contracts := o.builder.GetRoot().GetContracts()
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil {
+				continue
+			}
+			// a statement on its own throws its value away
+			prefixStatements := func(body *ast.BodyNode, gasDelta int) {
+				for i, stmt := range body.Statements {
+					if prefix, ok := toPrefix(stmt); ok {
+						body.Statements[i] = prefix
+						changes = append(changes, newChange(contract.GetName(), fn.GetName(), stmt.GetSrc(), prefix, rationale, gasDelta))
+					}
+				}
+			}
+			walk(fn.GetBody(), func(node ast.Node[ast.NodeType]) bool {
+				switch node := node.(type) {
+				case *ast.BodyNode:
+					prefixStatements(node, -postfixCost)
+				case *ast.ForStatement, *ast.WhileStatement, *ast.DoWhileStatement:
+					// loops list the statements of their body rather than the body itself
+					if body := loopBody(node); body != nil {
+						prefixStatements(body, -postfixCost*DefaultGasModel.LoopIterations)
+					}
+					// the update expression of a for loop throws its value away too
+					if loop, ok := node.(*ast.ForStatement); ok {
+						if prefix, ok := toPrefix(loop.GetClosure()); ok {
+							changes = append(changes, newChange(contract.GetName(), fn.GetName(), loop.GetClosure().GetSrc(), prefix, rationale,
+								-postfixCost*DefaultGasModel.LoopIterations))
+							loop.Closure = prefix
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return changes
+}
+
+// toPrefix turns `i++` into `++i` and `i--` into `--i`.
+// The prefix form does not keep the old value around, which saves a few gas when it is not used anyway.
+func toPrefix(node ast.Node[ast.NodeType]) (*ast.UnaryPrefix, bool) {
+	suffix, ok := node.(*ast.UnarySuffix)
+	if !ok || !isIncrementOrDecrement(suffix.GetOperator()) {
+		return nil, false
+	}
+	return &ast.UnaryPrefix{
+		ASTBuilder:            suffix.ASTBuilder,
+		Id:                    suffix.GetId(),
+		NodeType:              suffix.GetType(),
+		Kind:                  suffix.Kind,
+		Src:                   suffix.GetSrc(),
+		Operator:              suffix.GetOperator(),
+		Prefix:                true,
+		Constant:              suffix.Constant,
+		LValue:                suffix.LValue,
+		Pure:                  suffix.Pure,
+		LValueRequested:       suffix.LValueRequested,
+		ReferencedDeclaration: suffix.ReferencedDeclaration,
+		Expression:            suffix.GetExpression(),
+		TypeDescription:       suffix.GetTypeDescription(),
+	}, true
+}
@@ -0,0 +1,79 @@
+// test file for prefix_increment.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const incrementContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Increments {
+    uint256 public count;
+    uint256 public last;
+
+    function loops(uint256 n) public {
+        for (uint256 i = 0; i < n; i++) {
+            count++;
+        }
+        while (n > 0) {
+            n--;
+        }
+        do {
+            count--;
+            if (count > 10) {
+                last++;
+            }
+        } while (count > 5);
+        last--;
+    }
+
+    function used() public returns (uint256) {
+        last = count++;
+        for (uint256 i = 0; i < count; i++) {
+            last = i--;
+        }
+        return count--;
+    }
+}
+`
+
+func TestPrefixIncrements(t *testing.T) {
+	builder := setUpBuilder(t, incrementContract)
+	opt := optimizer.NewOptimizer(builder)
+	changes := opt.UsePrefixIncrements()
+	assert.Len(t, changes, 7)
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the increments on their own, also directly inside loop bodies, are rewritten
+	assert.Contains(t, code, `    function loops(uint256 n) public {
+        for (uint256 i = 0; i < n; ++i) {
+            ++count;
+        }
+        while (n > 0) {
+            --n;
+        }
+        do {
+            --count;
+            if (count > 10) {
+                ++last;
+            }
+        } while (count > 5);
+        --last;
+    }`)
+	// the ones whose value is used are left alone
+	assert.Contains(t, code, `    function used() public returns (uint256) {
+        last = count++;
+        for (uint256 i = 0; i < count; ++i) {
+            last = i--;
+        }
+        return count--;
+    }`)
+
+	// nothing is left to rewrite
+	assert.Empty(t, opt.UsePrefixIncrements())
+}
@@ -0,0 +1,931 @@
+// Turns the rewritten AST into text edits of the original source files, so the code the passes did not touch,
+// its comments and its formatting come out as they went in
+package optimizer
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"go.uber.org/zap"
+)
+
+// Edit replaces the bytes from Start to End, End excluded, of a source file with Text.
+// Start and End are equal for inserted text.
+type Edit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// SourceEdits holds the edits to make to one source file
+type SourceEdits struct {
+	Path     string `json:"path"`
+	Original string `json:"-"`
+	Edits    []Edit `json:"edits"`
+}
+
+// Apply returns the original text with the edits made to it
+func (s SourceEdits) Apply() (string, error) {
+	edits := make([]Edit, len(s.Edits))
+	copy(edits, s.Edits)
+	// insertions come before a replacement starting at the same place
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].Start != edits[j].Start {
+			return edits[i].Start < edits[j].Start
+		}
+		return edits[i].End < edits[j].End
+	})
+	var sb strings.Builder
+	last := 0
+	for _, edit := range edits {
+		if edit.Start < last || edit.End < edit.Start || edit.End > len(s.Original) {
+			return "", fmt.Errorf("edit of %s from %d to %d overlaps another edit or leaves the file", s.Path, edit.Start, edit.End)
+		}
+		sb.WriteString(s.Original[last:edit.Start])
+		sb.WriteString(edit.Text)
+		last = edit.End
+	}
+	sb.WriteString(s.Original[last:])
+	return sb.String(), nil
+}
+
+// Edits returns the edits that turn every source file into its optimized version.
+// A file the passes did not change has no edits, and read only files are left out.
+func (o *Optimizer) Edits() []SourceEdits {
+	root := o.builder.GetAstBuilder().GetRoot()
+	if root == nil {
+		return nil
+	}
+	// a file declaring several contracts has a source unit for each of them
+	renderers := make(map[*sourceFile]*renderer, 0)
+	// code that was not read from a file is named after its source unit
+	paths := make(map[*sourceFile]string, 0)
+	for _, unit := range root.GetSourceUnits() {
+		file := o.fileOf(unit)
+		if file == nil || file.content == "" {
+			zap.L().Warn("Missing the source of a source unit, it is not rewritten", zap.String("path", unit.GetAbsolutePath()))
+			continue
+		}
+		if file.readOnly {
+			continue
+		}
+		r, ok := renderers[file]
+		if !ok {
+			r = newRenderer(o.original, file)
+			renderers[file] = r
+			paths[file] = file.path
+			if file.path == "" {
+				paths[file] = unit.GetAbsolutePath()
+			}
+		}
+		r.renderChild(unit, false)
+	}
+	files := make([]SourceEdits, 0, len(renderers))
+	for _, file := range o.files {
+		if r, ok := renderers[file]; ok {
+			files = append(files, SourceEdits{Path: paths[file], Original: r.content, Edits: r.edits})
+		}
+	}
+	return files
+}
+
+// nodeSnapshot is what a node looked like before the passes ran
+type nodeSnapshot struct {
+	src      ast.SrcNode
+	scalars  map[string]interface{}
+	children []childField
+}
+
+// childField holds the children of a node kept in one of its fields, at most one unless the field is a list
+type childField struct {
+	name  string
+	list  bool
+	nodes []ast.Node[ast.NodeType]
+}
+
+// textFields are the fields besides the children that show in the source text of a node
+var textFields = []string{"Name", "Visibility", "StateMutability", "StorageLocation", "Constant", "Operator", "Prefix",
+	"Value", "MemberName", "Virtual", "Indexed", "Text"}
+
+var parameterListType = reflect.TypeOf((*ast.ParameterList)(nil))
+
+// lineLists are the fields listing children one per line, such as statements or the declarations of a contract
+var lineLists = map[string]bool{"Statements": true, "Nodes": true, "Members": true}
+
+// snapshotTree records every node below the source units of the builder
+func snapshotTree(root *ast.RootNode) map[ast.Node[ast.NodeType]]*nodeSnapshot {
+	snapshots := make(map[ast.Node[ast.NodeType]]*nodeSnapshot, 0)
+	if root == nil {
+		return snapshots
+	}
+	var visit func(node ast.Node[ast.NodeType])
+	visit = func(node ast.Node[ast.NodeType]) {
+		if isNilNode(node) || snapshots[node] != nil {
+			return
+		}
+		snapshot := &nodeSnapshot{src: node.GetSrc(), scalars: scalarFields(node), children: childFields(node)}
+		snapshots[node] = snapshot
+		for _, field := range snapshot.children {
+			for _, child := range field.nodes {
+				visit(child)
+			}
+		}
+	}
+	for _, unit := range root.GetSourceUnits() {
+		visit(unit)
+	}
+	return snapshots
+}
+
+// scalarFields returns the text fields of the node by name
+func scalarFields(node ast.Node[ast.NodeType]) map[string]interface{} {
+	fields := make(map[string]interface{}, 0)
+	value := reflect.ValueOf(node)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fields
+	}
+	value = value.Elem()
+	for _, name := range textFields {
+		field := value.FieldByName(name)
+		if field.IsValid() && field.Type().Comparable() && field.Kind() != reflect.Ptr && field.Kind() != reflect.Interface {
+			fields[name] = field.Interface()
+		}
+	}
+	return fields
+}
+
+// childFields returns the children of the node field by field, skipping the same fields as rewriteNodes
+func childFields(node ast.Node[ast.NodeType]) []childField {
+	fields := make([]childField, 0)
+	value := reflect.ValueOf(node)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fields
+	}
+	value = value.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := value.Type().Field(i).Name
+		if !field.CanSet() || value.Type().Field(i).Anonymous || strings.HasPrefix(name, "Parent") {
+			continue
+		}
+		child := childField{name: name, nodes: make([]ast.Node[ast.NodeType], 0)}
+		switch {
+		case field.Type() == nodeInterface || field.Type().Implements(nodeInterface):
+			if n, ok := field.Interface().(ast.Node[ast.NodeType]); ok && !isNilNode(n) {
+				child.nodes = append(child.nodes, n)
+			}
+		case field.Type() == parameterListType:
+			// parameter lists are not nodes of their own, so their parameters count as children of the function
+			child.list = true
+			if list, ok := field.Interface().(*ast.ParameterList); ok && list != nil {
+				for _, param := range list.GetParameters() {
+					if !isNilNode(param) {
+						child.nodes = append(child.nodes, param)
+					}
+				}
+			}
+		case field.Kind() == reflect.Slice && (field.Type().Elem() == nodeInterface || field.Type().Elem().Implements(nodeInterface)):
+			child.list = true
+			for j := 0; j < field.Len(); j++ {
+				if n, ok := field.Index(j).Interface().(ast.Node[ast.NodeType]); ok && !isNilNode(n) {
+					child.nodes = append(child.nodes, n)
+				}
+			}
+		default:
+			continue
+		}
+		fields = append(fields, child)
+	}
+	return fields
+}
+
+// renderer collects the edits of one source file
+type renderer struct {
+	original map[ast.Node[ast.NodeType]]*nodeSnapshot
+	content  string
+	// start is the character index of the parser the file starts at
+	start int64
+	// offsets maps the character indexes of the file to byte offsets
+	offsets []int
+	// indentUnit is one level of indentation of the file
+	indentUnit string
+	edits      []Edit
+	// seen holds the nodes rendered so far in the order they were, as some nodes are children of two fields
+	seen  map[ast.Node[ast.NodeType]]bool
+	order []ast.Node[ast.NodeType]
+}
+
+func newRenderer(original map[ast.Node[ast.NodeType]]*nodeSnapshot, file *sourceFile) *renderer {
+	content := file.content
+	offsets := make([]int, 0, len(content)+1)
+	for offset := range content {
+		offsets = append(offsets, offset)
+	}
+	offsets = append(offsets, len(content))
+	return &renderer{
+		original:   original,
+		content:    content,
+		start:      file.start,
+		offsets:    offsets,
+		indentUnit: indentUnit(content),
+		edits:      make([]Edit, 0),
+		seen:       make(map[ast.Node[ast.NodeType]]bool, 0),
+	}
+}
+
+// indentUnit guesses one level of indentation from the first indented line, four spaces if there is none
+func indentUnit(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || len(trimmed) == len(line) || strings.HasPrefix(trimmed, "*") {
+			continue
+		}
+		return line[:len(line)-len(trimmed)]
+	}
+	return "    "
+}
+
+// rangeOf returns the bytes the node took in the original file. Nodes made by a pass and some nodes of the parser,
+// such as the body of an if statement without braces, have none.
+func (r *renderer) rangeOf(node ast.Node[ast.NodeType]) (int, int, bool) {
+	snapshot, ok := r.original[node]
+	if !ok {
+		return 0, 0, false
+	}
+	src := snapshot.src
+	if src.End < src.Start || (src.Start == 0 && src.End == 0) {
+		return 0, 0, false
+	}
+	start, end := src.Start-r.start, src.End-r.start+1
+	if start < 0 || end >= int64(len(r.offsets)) {
+		return 0, 0, false
+	}
+	return r.offsets[start], r.offsets[end], true
+}
+
+// extentOf is the range of an item of a list. Items listed one per line take their semicolon with them,
+// which the parser leaves out of expression statements.
+func (r *renderer) extentOf(node ast.Node[ast.NodeType], lineList bool) (int, int, bool) {
+	start, end, ok := r.rangeOf(node)
+	if ok && lineList {
+		next := end
+		for next < len(r.content) && (r.content[next] == ' ' || r.content[next] == '\t') {
+			next++
+		}
+		if next < len(r.content) && r.content[next] == ';' {
+			end = next + 1
+		}
+	}
+	return start, end, ok
+}
+
+func (r *renderer) edit(start, end int, text string) {
+	r.edits = append(r.edits, Edit{Start: start, End: end, Text: text})
+}
+
+// renderChild makes the edits of an original node, or replaces it with its printed form
+// if its changes can not be made in place. It returns false for a node without a range it could not render,
+// which has to be rendered as part of its parent.
+func (r *renderer) renderChild(node ast.Node[ast.NodeType], lineList bool) bool {
+	if r.seen[node] {
+		return true
+	}
+	if r.render(node) {
+		return true
+	}
+	start, end, ok := r.extentOf(node, lineList)
+	if !ok {
+		return false
+	}
+	r.replace(start, end, r.printed(node, lineList, r.lineIndent(start)))
+	r.seen[node] = true
+	r.order = append(r.order, node)
+	return true
+}
+
+// render makes the edits turning the original text of the node into its current form.
+// Nothing is changed and it returns false if that is not possible.
+func (r *renderer) render(node ast.Node[ast.NodeType]) bool {
+	snapshot, ok := r.original[node]
+	if !ok {
+		return false
+	}
+	r.seen[node] = true
+	r.order = append(r.order, node)
+	mark, seen := len(r.edits), len(r.order)-1
+	_, _, ranged := r.rangeOf(node)
+	current := childFields(node)
+	if ok = len(current) == len(snapshot.children) && r.renderKeywords(node, snapshot); ok {
+		for i := 0; ok && i < len(current); i++ {
+			before := snapshot.children[i]
+			if current[i].list {
+				ok = r.renderList(before.nodes, current[i].nodes, lineLists[current[i].name], ranged)
+			} else {
+				ok = r.renderField(before.nodes, current[i].nodes)
+			}
+		}
+	}
+	if !ok {
+		r.edits = r.edits[:mark]
+		for _, n := range r.order[seen:] {
+			delete(r.seen, n)
+		}
+		r.order = r.order[:seen]
+	}
+	return ok
+}
+
+// renderField renders a field holding a single child
+func (r *renderer) renderField(before, after []ast.Node[ast.NodeType]) bool {
+	switch {
+	case len(before) == 0 && len(after) == 0:
+		return true
+	case len(before) == 0:
+		// nothing tells where the new child goes
+		return false
+	case len(after) == 0:
+		start, end, ok := r.rangeOf(before[0])
+		if !ok {
+			return false
+		}
+		// such as the update expression of a for loop, `i < n; i++)` becomes `i < n;)`
+		for start > 0 && (r.content[start-1] == ' ' || r.content[start-1] == '\t') {
+			start--
+		}
+		r.edit(start, end, "")
+		return true
+	case before[0] == after[0]:
+		return r.renderChild(after[0], false)
+	}
+	start, end, ok := r.rangeOf(before[0])
+	if !ok {
+		return false
+	}
+	r.replace(start, end, r.textOf(after[0], false, r.lineIndent(start)))
+	return true
+}
+
+// renderList renders a field listing children. Items keep their place and the text between them,
+// new items take the place of removed ones or are inserted next to the ones around them,
+// and the removed items left are taken out with their line.
+func (r *renderer) renderList(before, after []ast.Node[ast.NodeType], lineList, ranged bool) bool {
+	if len(before) == len(after) {
+		// the same items, or items replaced or moved one by one such as packed declarations
+		for i := range after {
+			if before[i] == after[i] {
+				if !r.renderChild(after[i], lineList) {
+					return false
+				}
+				continue
+			}
+			start, end, ok := r.extentOf(before[i], lineList)
+			if !ok {
+				return false
+			}
+			text := r.textOf(after[i], lineList, r.lineIndent(start))
+			// a declaration moved elsewhere in the list takes its comments with it
+			if movedStart, movedEnd, moved := r.extentOf(after[i], lineList); moved && lineList {
+				start, end = r.commentStart(start), r.commentEnd(end)
+				text = r.content[r.commentStart(movedStart):movedStart] + text + r.content[movedEnd:r.commentEnd(movedEnd)]
+			}
+			r.replace(start, end, text)
+		}
+		return true
+	}
+	index := make(map[ast.Node[ast.NodeType]]int, len(before))
+	for i, node := range before {
+		index[node] = i
+		if _, _, ok := r.rangeOf(node); !ok {
+			return false
+		}
+	}
+	// the items kept in the list, by their index before and after, ending with the end of both lists
+	kept := make([][2]int, 0)
+	for j, node := range after {
+		if i, ok := index[node]; ok {
+			if len(kept) > 0 && i < kept[len(kept)-1][0] {
+				// items moved around and items added or removed at the same time
+				return false
+			}
+			kept = append(kept, [2]int{i, j})
+		}
+	}
+	kept = append(kept, [2]int{len(before), len(after)})
+	previousBefore, previousAfter := -1, -1
+	for _, k := range kept {
+		// between two kept items, the items that were there are replaced one by one with the new ones,
+		// and the rest is removed or inserted after them
+		removed, added := before[previousBefore+1:k[0]], after[previousAfter+1:k[1]]
+		// a list without braces around it, such as the body of an if statement, can not grow or shrink in place
+		if len(removed) != len(added) && !ranged {
+			return false
+		}
+		replaced := len(removed)
+		if len(added) < replaced {
+			replaced = len(added)
+		}
+		for j := 0; j < replaced; j++ {
+			start, end, _ := r.extentOf(removed[j], lineList)
+			r.replace(start, end, r.textOf(added[j], lineList, r.lineIndent(start)))
+		}
+		for j := replaced; j < len(removed); j++ {
+			r.remove(removed[j], lineList, previousBefore+1+j == 0)
+		}
+		if len(added) > replaced {
+			var anchor ast.Node[ast.NodeType]
+			switch {
+			case replaced > 0:
+				anchor = removed[replaced-1]
+			case previousBefore >= 0:
+				anchor = before[previousBefore]
+			}
+			if anchor != nil {
+				_, end, _ := r.extentOf(anchor, lineList)
+				indent := r.lineIndent(end - 1)
+				// the comment ending the line of the anchor stays with it
+				if lineList {
+					end = r.commentEnd(end)
+				}
+				for _, node := range added[replaced:] {
+					if lineList {
+						r.edit(end, end, "\n"+indent+r.textOf(node, true, indent))
+					} else {
+						r.edit(end, end, ", "+r.textOf(node, false, indent))
+					}
+				}
+			} else if k[0] < len(before) {
+				// new items at the start of the list go before the first kept item
+				start, _, _ := r.extentOf(before[k[0]], lineList)
+				indent := r.lineIndent(start)
+				// and before the comments above it, which stay with it
+				if lineList {
+					start = r.commentStart(start)
+				}
+				for _, node := range added {
+					if lineList {
+						r.edit(start, start, r.textOf(node, true, indent)+"\n"+indent)
+					} else {
+						r.edit(start, start, r.textOf(node, false, indent)+", ")
+					}
+				}
+			} else {
+				// nothing tells where the items of an empty list go
+				return false
+			}
+		}
+		if k[0] < len(before) && !r.renderChild(before[k[0]], lineList) {
+			return false
+		}
+		previousBefore, previousAfter = k[0], k[1]
+	}
+	return true
+}
+
+// remove takes an item out of a list. An item alone on its line goes with the line, its trailing comment
+// and the documentation comments above it. An item of an inline list goes with its comma.
+func (r *renderer) remove(node ast.Node[ast.NodeType], lineList, first bool) {
+	start, end, _ := r.extentOf(node, lineList)
+	if !lineList {
+		if first {
+			for end < len(r.content) && strings.ContainsRune(" \t\n,", rune(r.content[end])) {
+				end++
+			}
+		} else {
+			for start > 0 && strings.ContainsRune(" \t\n,", rune(r.content[start-1])) {
+				start--
+			}
+		}
+		r.edit(start, end, "")
+		return
+	}
+	lineStart := strings.LastIndex(r.content[:start], "\n") + 1
+	lineEnd := strings.IndexByte(r.content[end:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(r.content)
+	} else {
+		lineEnd += end
+	}
+	rest := strings.TrimSpace(r.content[end:lineEnd])
+	if strings.TrimSpace(r.content[lineStart:start]) != "" || (rest != "" && !strings.HasPrefix(rest, "//")) {
+		for end < len(r.content) && (r.content[end] == ' ' || r.content[end] == '\t') {
+			end++
+		}
+		r.edit(start, end, "")
+		return
+	}
+	start, end = lineStart, lineEnd
+	if end < len(r.content) {
+		end++
+	}
+	for start > 0 {
+		previous := strings.LastIndex(r.content[:start-1], "\n") + 1
+		line := strings.TrimSpace(r.content[previous : start-1])
+		if strings.HasPrefix(line, "///") {
+			start = previous
+			continue
+		}
+		if strings.HasSuffix(line, "*/") {
+			if open := strings.LastIndex(r.content[:start], "/**"); open >= 0 && strings.Count(r.content[open:start], "*/") == 1 {
+				if openLine := strings.LastIndex(r.content[:open], "\n") + 1; strings.TrimSpace(r.content[openLine:open]) == "" {
+					start = openLine
+				}
+			}
+		}
+		break
+	}
+	// the last item takes the blank lines above it, and the items removed right above them, so no blank line is
+	// left before the closing brace
+	if next := strings.TrimSpace(r.content[end:]); strings.HasPrefix(next, "}") {
+		start = r.removedAbove(start)
+		r.edit(start, end, "")
+		return
+	}
+	// do not leave two blank lines where the item was
+	if start > 0 && end < len(r.content) && r.isBlankLineBefore(start) {
+		if next := strings.IndexByte(r.content[end:], '\n'); next >= 0 && strings.TrimSpace(r.content[end:end+next]) == "" {
+			end += next + 1
+		}
+	}
+	r.edit(start, end, "")
+}
+
+// removedAbove returns the start of the blank lines and removed code right above the line starting at start,
+// merging the edits that removed that code into the one made from there
+func (r *renderer) removedAbove(start int) int {
+	for start > 0 {
+		if r.isBlankLineBefore(start) && !r.editedAt(strings.LastIndex(r.content[:start-1], "\n")+1, start) {
+			start = strings.LastIndex(r.content[:start-1], "\n") + 1
+			continue
+		}
+		merged := false
+		for i, edit := range r.edits {
+			if edit.Text == "" && edit.End == start && edit.Start < start {
+				start = edit.Start
+				r.edits = append(r.edits[:i], r.edits[i+1:]...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			break
+		}
+	}
+	return start
+}
+
+// editedAt reports whether an edit touches the bytes from start to end
+func (r *renderer) editedAt(start, end int) bool {
+	for _, edit := range r.edits {
+		if edit.Start < end && edit.End > start || edit.Start == edit.End && edit.Start >= start && edit.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlankLineBefore reports whether the line before the line starting at start is blank
+func (r *renderer) isBlankLineBefore(start int) bool {
+	previous := strings.LastIndex(r.content[:start-1], "\n") + 1
+	return strings.TrimSpace(r.content[previous:start-1]) == ""
+}
+
+// textOf returns the text of a node put in place of another one. An original node moved there keeps its text
+// with its own edits made to it, a node made by a pass is printed.
+func (r *renderer) textOf(node ast.Node[ast.NodeType], lineList bool, indent string) string {
+	start, end, ok := r.extentOf(node, lineList)
+	if !ok {
+		return r.printed(node, lineList, indent)
+	}
+	mark := len(r.edits)
+	if !r.render(node) {
+		return r.printed(node, lineList, indent)
+	}
+	moved := SourceEdits{Original: r.content[:end], Edits: r.edits[mark:]}
+	r.edits = r.edits[:mark]
+	text, err := moved.Apply()
+	if err != nil {
+		return r.printed(node, lineList, indent)
+	}
+	return text[start:]
+}
+
+// commentStart returns the start of the comment lines right above the code starting a line at start,
+// or start if there are none
+func (r *renderer) commentStart(start int) int {
+	lineStart := strings.LastIndex(r.content[:start], "\n") + 1
+	if strings.TrimSpace(r.content[lineStart:start]) != "" {
+		return start
+	}
+	first := start
+	for lineStart > 0 {
+		previous := strings.LastIndex(r.content[:lineStart-1], "\n") + 1
+		line := r.content[previous : lineStart-1]
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			break
+		}
+		first = previous + len(line) - len(strings.TrimLeft(line, " \t"))
+		lineStart = previous
+	}
+	return first
+}
+
+// commentEnd returns the end of the comment following the code ending at end on the same line, or end if there is none
+func (r *renderer) commentEnd(end int) int {
+	next := end
+	for next < len(r.content) && (r.content[next] == ' ' || r.content[next] == '\t') {
+		next++
+	}
+	if !strings.HasPrefix(r.content[next:], "//") {
+		return end
+	}
+	if newline := strings.IndexByte(r.content[next:], '\n'); newline >= 0 {
+		return next + newline
+	}
+	return len(r.content)
+}
+
+// printed prints the node at the indentation of the line it goes on, ending the items of a list one per line
+// with a semicolon unless they are blocks. The original children of the node keep their text, which the printer
+// can not always reproduce, such as array types of structs or `delete`.
+func (r *renderer) printed(node ast.Node[ast.NodeType], lineList bool, indent string) string {
+	originals := make(map[string]string, 0)
+	restore := r.holdOriginals(node, originals)
+	text := printSource(node)
+	restore()
+	if lineList && !strings.HasSuffix(text, ";") && !strings.HasSuffix(text, "}") {
+		text += ";"
+	}
+	text = r.indent(text, indent)
+	for placeholder, original := range originals {
+		// the printer ends the statements it prints with a semicolon, which declarations already have
+		if strings.HasSuffix(original, ";") || strings.HasSuffix(original, "}") {
+			text = strings.ReplaceAll(text, placeholder+";", original)
+		}
+		text = strings.ReplaceAll(text, placeholder, original)
+	}
+	return text
+}
+
+var typeNamePointer = reflect.TypeOf(&ast.TypeName{})
+
+// holdOriginals puts placeholders in place of the original children below the node that the printer would print,
+// and adds their original text to originals by placeholder. It returns a function putting the children back.
+// Only children held in fields that also take an identifier or a type name can be held.
+func (r *renderer) holdOriginals(node ast.Node[ast.NodeType], originals map[string]string) func() {
+	restores := make([]func(), 0)
+	hold := func(field reflect.Value, child ast.Node[ast.NodeType]) bool {
+		if _, _, ok := r.rangeOf(child); !ok || (field.Type() != nodeInterface && field.Type() != typeNamePointer) {
+			return false
+		}
+		name := fmt.Sprintf("__original_%d__", len(originals))
+		originals[name] = r.textOf(child, false, "")
+		var placeholder reflect.Value
+		if field.Type() == typeNamePointer {
+			placeholder = reflect.ValueOf(&ast.TypeName{Name: name})
+		} else {
+			var identifier ast.Node[ast.NodeType] = &ast.PrimaryExpression{Name: name, NodeType: ast_pb.NodeType_IDENTIFIER}
+			placeholder = reflect.ValueOf(&identifier).Elem()
+		}
+		previous := reflect.ValueOf(field.Interface())
+		field.Set(placeholder)
+		restores = append(restores, func() { field.Set(previous) })
+		return true
+	}
+	var visit func(ast.Node[ast.NodeType], bool)
+	visit = func(n ast.Node[ast.NodeType], top bool) {
+		value := reflect.ValueOf(n)
+		if isNilNode(n) || value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+			return
+		}
+		// the original nodes below a node made by a pass, or the children of an original node printed again
+		if _, original := r.original[n]; original && !top {
+			return
+		}
+		value = value.Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Field(i)
+			if !field.CanSet() || value.Type().Field(i).Anonymous || strings.HasPrefix(value.Type().Field(i).Name, "Parent") {
+				continue
+			}
+			switch {
+			case field.Type().Implements(nodeInterface) || field.Type() == nodeInterface:
+				if child, ok := field.Interface().(ast.Node[ast.NodeType]); ok && !isNilNode(child) && !hold(field, child) {
+					visit(child, false)
+				}
+			case field.Kind() == reflect.Slice && (field.Type().Elem() == nodeInterface || field.Type().Elem().Implements(nodeInterface)):
+				for j := 0; j < field.Len(); j++ {
+					if child, ok := field.Index(j).Interface().(ast.Node[ast.NodeType]); ok && !isNilNode(child) && !hold(field.Index(j), child) {
+						visit(child, false)
+					}
+				}
+			}
+		}
+	}
+	visit(node, true)
+	return func() {
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i]()
+		}
+	}
+}
+
+// printSource prints the node like printNode, writing out the unchecked blocks the printer leaves as plain blocks
+func printSource(node ast.Node[ast.NodeType]) string {
+	if body, ok := node.(*ast.BodyNode); ok && body.GetType() == ast_pb.NodeType_UNCHECKED_BLOCK {
+		block := *body
+		block.NodeType = ast_pb.NodeType_BLOCK
+		return "unchecked " + printNode(&block)
+	}
+	return printNode(node)
+}
+
+// replace puts text in place of the original range, keeping a semicolon that ends it
+func (r *renderer) replace(start, end int, text string) {
+	if strings.HasSuffix(r.content[start:end], ";") && !strings.HasSuffix(text, ";") && !strings.HasSuffix(text, "}") {
+		text += ";"
+	}
+	r.edit(start, end, text)
+}
+
+// indent moves the lines of printed code after the first one to the indentation of the line it goes on,
+// in the indentation unit of the file rather than the two spaces of the printer
+func (r *renderer) indent(text, indent string) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if trimmed == "" {
+			lines[i] = ""
+			continue
+		}
+		depth := (len(lines[i]) - len(trimmed)) / 2
+		lines[i] = indent + strings.Repeat(r.indentUnit, depth) + trimmed
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lineIndent returns the indentation of the line holding the byte at offset
+func (r *renderer) lineIndent(offset int) string {
+	if offset > len(r.content) {
+		offset = len(r.content)
+	}
+	start := strings.LastIndex(r.content[:offset], "\n") + 1
+	end := start
+	for end < len(r.content) && (r.content[end] == ' ' || r.content[end] == '\t') {
+		end++
+	}
+	return r.content[start:end]
+}
+
+// renderKeywords edits the keywords of the node whose text fields changed, such as the visibility of a function
+// or the data location of a parameter. It returns false for changes it can not make in place.
+func (r *renderer) renderKeywords(node ast.Node[ast.NodeType], snapshot *nodeSnapshot) bool {
+	current := scalarFields(node)
+	changed := make([]string, 0)
+	for _, name := range textFields {
+		if current[name] != snapshot.scalars[name] {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return true
+	}
+	start, end, ok := r.rangeOf(node)
+	if !ok {
+		return false
+	}
+	for _, name := range changed {
+		before, after := keyword(snapshot.scalars[name]), keyword(current[name])
+		switch node := node.(type) {
+		case *ast.Function:
+			headerStart, headerEnd, ok := r.functionHeader(node, snapshot, start, end)
+			if !ok {
+				return false
+			}
+			switch name {
+			case "Name":
+				open := strings.IndexByte(r.content[start:end], '(')
+				if open < 0 || !r.replaceWord(start, start+open, fmt.Sprint(snapshot.scalars[name]), fmt.Sprint(current[name])) {
+					return false
+				}
+			case "Visibility", "StateMutability":
+				if !r.replaceWord(headerStart, headerEnd, before, after) {
+					return false
+				}
+			default:
+				return false
+			}
+		case *ast.Parameter:
+			if name != "StorageLocation" {
+				return false
+			}
+			from := start
+			if typeName := node.GetTypeName(); typeName != nil {
+				if _, typeEnd, ok := r.rangeOf(typeName); ok {
+					from = typeEnd
+				}
+			}
+			if before == "" {
+				r.edit(from, from, " "+after)
+			} else if !r.replaceWord(from, end, before, after) {
+				return false
+			}
+		case *ast.StateVariableDeclaration:
+			typeName := node.GetTypeName()
+			if typeName == nil || before != "" {
+				return false
+			}
+			_, typeEnd, ok := r.rangeOf(typeName)
+			if !ok {
+				return false
+			}
+			switch {
+			case name == "Constant" && node.IsConstant():
+				r.edit(typeEnd, typeEnd, " constant")
+			case name == "StateMutability" && after == "immutable":
+				r.edit(typeEnd, typeEnd, " immutable")
+			default:
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// functionHeader returns the part of a function between its parameters and its return parameters or body,
+// where its visibility, mutability and modifiers are written
+func (r *renderer) functionHeader(fn *ast.Function, snapshot *nodeSnapshot, start, end int) (int, int, bool) {
+	from := start
+	if params := fn.GetParameters(); params != nil {
+		for _, param := range params.GetParameters() {
+			if _, paramEnd, ok := r.rangeOf(param); ok && paramEnd > from {
+				from = paramEnd
+			}
+		}
+	}
+	paren := strings.IndexByte(r.content[from:end], ')')
+	if paren < 0 {
+		return 0, 0, false
+	}
+	from += paren + 1
+	to := end
+	for _, field := range snapshot.children {
+		if field.name == "Body" && len(field.nodes) > 0 {
+			// a function without a body gets one ranging over the function
+			if bodyStart, _, ok := r.rangeOf(field.nodes[0]); ok && bodyStart >= from {
+				to = bodyStart
+			}
+		}
+	}
+	if returns := regexp.MustCompile(`\breturns\b`).FindStringIndex(r.content[from:to]); returns != nil {
+		to = from + returns[0]
+	}
+	return from, to, true
+}
+
+// replaceWord replaces the keyword in the range, removing it with the space before it if the new keyword is empty
+func (r *renderer) replaceWord(from, to int, before, after string) bool {
+	if before == "" {
+		return false
+	}
+	found := regexp.MustCompile(`\b` + regexp.QuoteMeta(before) + `\b`).FindStringIndex(r.content[from:to])
+	if found == nil {
+		return false
+	}
+	start, end := from+found[0], from+found[1]
+	if after == "" {
+		for start > from && (r.content[start-1] == ' ' || r.content[start-1] == '\t') {
+			start--
+		}
+	}
+	r.edit(start, end, after)
+	return true
+}
+
+// keyword returns how a visibility, mutability or data location is written, empty for the ones left out
+func keyword(value interface{}) string {
+	switch value := value.(type) {
+	case ast_pb.Visibility:
+		if value == ast_pb.Visibility_V_DEFAULT {
+			return ""
+		}
+		return strings.ToLower(value.String())
+	case ast_pb.Mutability:
+		if value == ast_pb.Mutability_M_DEFAULT || value == ast_pb.Mutability_MUTABLE || value == ast_pb.Mutability_NONPAYABLE {
+			return ""
+		}
+		return strings.ToLower(value.String())
+	case ast_pb.StorageLocation:
+		if value == ast_pb.StorageLocation_ST_UNKNOWN || value == ast_pb.StorageLocation_DEFAULT {
+			return ""
+		}
+		return strings.ToLower(value.String())
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+// test file for rewrite.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rewriteContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+/// @title Keeps a tally
+/// @notice the comments and the odd formatting below must survive
+contract Tally {
+    struct Entry {
+        uint256 amount; // the amount
+        bool open;      // still counting
+        uint256 since;  // when it opened
+    }
+
+    uint256 public count;   // aligned comment
+    uint256[] values;
+
+    /* a block comment */
+    function bump(uint256 times)   public {
+        require(times > 0, "no times");
+        count++; // count the call
+        for (uint256 i = 0; i < times; i++) {
+            // add every index
+            count += i;
+        }
+    }
+
+    function sum() public view returns (uint256 total) {
+        for (uint256 i = 0; i < values.length; i++) {
+            total += values[i];
+        }
+    }
+}
+`
+
+func TestEditsKeepSource(t *testing.T) {
+	builder := setUpBuilder(t, rewriteContract)
+	opt := optimizer.NewOptimizer(builder)
+
+	// nothing changed, nothing to edit
+	files := opt.Edits()
+	assert.Len(t, files, 1)
+	assert.Empty(t, files[0].Edits)
+	code, err := files[0].Apply()
+	assert.NoError(t, err)
+	assert.Equal(t, rewriteContract, code)
+
+	opt.UsePrefixIncrements()
+	opt.UncheckLoopIncrements()
+	opt.UseCustomErrors()
+	opt.PromoteExternalFunctions()
+	files = opt.Edits()
+	assert.Len(t, files, 1)
+	code, err = files[0].Apply()
+	assert.NoError(t, err)
+	assert.Equal(t, `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+/// @title Keeps a tally
+/// @notice the comments and the odd formatting below must survive
+contract Tally {
+    struct Entry {
+        uint256 amount; // the amount
+        bool open;      // still counting
+        uint256 since;  // when it opened
+    }
+
+    uint256 public count;   // aligned comment
+    uint256[] values;
+    error NoTimes();
+
+    /* a block comment */
+    function bump(uint256 times)   external {
+        if (!(times > 0)) {
+            revert NoTimes();
+        }
+        ++count; // count the call
+        for (uint256 i = 0; i < times;) {
+            // add every index
+            count += i;
+            unchecked {
+                ++i;
+            }
+        }
+    }
+
+    function sum() external view returns (uint256 total) {
+        for (uint256 i = 0; i < values.length;) {
+            total += values[i];
+            unchecked {
+                ++i;
+            }
+        }
+    }
+}
+`, code)
+}
+
+func TestEditsMoveComments(t *testing.T) {
+	builder := setUpBuilder(t, rewriteContract)
+	opt := optimizer.NewOptimizer(builder)
+	opt.PackStructs()
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the packed members take their comments with them
+	assert.Contains(t, code, `        uint256 amount; // the amount
+        uint256 since;  // when it opened
+        bool open;      // still counting
+`)
+}
+
+func TestEditsKeepLineComments(t *testing.T) {
+	builder := setUpBuilder(t, `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Ledger {
+    uint256 total;
+    uint256[] amounts;
+
+    function add(uint256 amount) public {
+        total += amount; // first
+        total += amount; // second
+    }
+
+    function addAll(uint256[] memory values) public {
+        // sum them
+        for (uint256 i = 0; i < values.length; i++) {
+            total += values[i];
+        } // done
+    }
+
+    function sum() public view returns (uint256) {
+        uint256 s; // running sum
+        for (uint256 i = 0; i < amounts.length; i++) {
+            s += amounts[i];
+        }
+        return s;
+    }
+}
+`)
+	opt := optimizer.NewOptimizer(builder)
+	opt.HoistLoopAccumulators()
+	opt.CacheStorageVariables(optimizer.DefaultGasModel)
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the statements added after a line or before its comments leave the comments where they were
+	assert.Contains(t, code, `        cached_total += amount; // second
+        total = cached_total;
+`)
+	assert.Contains(t, code, `        uint256 accumulated_total = total;
+        // sum them
+`)
+	assert.Contains(t, code, `        } // done
+        total = accumulated_total;
+`)
+	assert.Contains(t, code, `        uint256 s; // running sum
+        uint256 cached_amounts_length = amounts.length;
+`)
+}
+
+func TestEditsOfPipeline(t *testing.T) {
+	builder := setUpBuilder(t, rewriteContract)
+	opt := optimizer.NewOptimizer(builder)
+	passes, _ := optimizer.LevelPasses(3)
+	_, err := opt.RunPipeline(passes, optimizer.LevelOptions(3), 0)
+	assert.NoError(t, err)
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the struct is not used and goes with its comments
+	assert.NotContains(t, code, "struct Entry")
+	for _, comment := range []string{"/// @title Keeps a tally", "// aligned comment", "/* a block comment */",
+		"// count the call", "// add every index"} {
+		assert.Contains(t, code, comment)
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	file := optimizer.SourceEdits{Path: "a.sol", Original: "uint256 a = 1;", Edits: []optimizer.Edit{
+		{Start: 12, End: 13, Text: "2"},
+		{Start: 0, End: 0, Text: "// two\n"},
+	}}
+	code, err := file.Apply()
+	assert.NoError(t, err)
+	assert.Equal(t, "// two\nuint256 a = 2;", code)
+
+	file.Edits = append(file.Edits, optimizer.Edit{Start: 10, End: 13, Text: "3"})
+	_, err = file.Apply()
+	assert.Error(t, err)
+}
+
+func TestEditsOfParameters(t *testing.T) {
+	code := `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Greeter {
+    function greet(string memory name, uint256[] memory ids) public pure returns (uint256) {
+        return bytes(name).length + ids.length;
+    }
+}
+`
+	builder := setUpBuilder(t, code)
+	opt := optimizer.NewOptimizer(builder)
+	passes, _ := optimizer.LevelPasses(3)
+	_, err := opt.RunPipeline(passes, optimizer.LevelOptions(3), 0)
+	assert.NoError(t, err)
+
+	files := opt.Edits()
+	assert.Len(t, files, 1)
+	rewritten, err := files[0].Apply()
+	assert.NoError(t, err)
+	// the parameters are rewritten in place, next to the promoted visibility
+	assert.Contains(t, rewritten, "function greet(string calldata name, uint256[] calldata ids) external pure returns (uint256) {")
+	assert.NotContains(t, rewritten, "memory")
+}
@@ -0,0 +1,93 @@
+// Maps the positions the parser reports back to the source files they are in
+package optimizer
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+// sourceFile is one of the files the builder parsed. The parser reads the files joined by a blank line,
+// so the positions it reports count from the start of the first file.
+type sourceFile struct {
+	path    string
+	content string
+	// start is the character the file starts at in the joined files, and lines the number of lines before it
+	start int64
+	lines int64
+	// readOnly files are analyzed by the passes but never changed
+	readOnly bool
+}
+
+// sourceFiles lists the files of the builder in the order the parser read them
+func sourceFiles(builder *ir.Builder) []*sourceFile {
+	files := make([]*sourceFile, 0)
+	start, lines := int64(0), int64(0)
+	for _, unit := range builder.GetSources().SourceUnits {
+		files = append(files, &sourceFile{path: unit.Path, content: unit.Content, start: start, lines: lines})
+		// the files are joined with "\n\n"
+		start += int64(utf8.RuneCountInString(unit.Content)) + 2
+		lines += int64(strings.Count(unit.Content, "\n")) + 2
+	}
+	return files
+}
+
+// fileAt returns the file holding the character at offset in the joined files, nil if there is none
+func (o *Optimizer) fileAt(offset int64) *sourceFile {
+	var file *sourceFile
+	for _, f := range o.files {
+		if f.start > offset {
+			break
+		}
+		file = f
+	}
+	return file
+}
+
+// fileOf returns the file the node was parsed from, nil for a node made by a pass
+func (o *Optimizer) fileOf(node ast.Node[ast.NodeType]) *sourceFile {
+	if isNilNode(node) {
+		return nil
+	}
+	src := node.GetSrc()
+	if snapshot, ok := o.original[node]; ok {
+		src = snapshot.src
+	}
+	if src.Start == 0 && src.End == 0 && len(o.files) > 1 {
+		return nil
+	}
+	return o.fileAt(src.Start)
+}
+
+// local returns src with its positions counted from the start of the file
+func (f *sourceFile) local(src ast.SrcNode) ast.SrcNode {
+	src.Start -= f.start
+	src.End -= f.start
+	src.Line -= f.lines
+	return src
+}
+
+// ReadOnly marks the source files at the paths as read only, such as the libraries a project imports.
+// The passes still read them, to know how the code they change is used, but change nothing declared in them
+// and Edits leaves them out.
+func (o *Optimizer) ReadOnly(paths ...string) {
+	for _, path := range paths {
+		for _, file := range o.files {
+			if file.path != "" && filepath.Clean(file.path) == filepath.Clean(path) {
+				file.readOnly = true
+			}
+		}
+	}
+}
+
+// rewritable reports whether the passes may change the contract, which they may unless it is declared in a read only file
+func (o *Optimizer) rewritable(contract *ir.Contract) bool {
+	if contract == nil || contract.GetAST() == nil {
+		return true
+	}
+	file := o.fileOf(contract.GetAST())
+	return file == nil || !file.readOnly
+}
@@ -0,0 +1,92 @@
+// test file for sources.go
+package optimizer_test
+
+import (
+	"context"
+	"optimizer/optimizer/optimizer"
+	"optimizer/optimizer/printer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectEdits(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"remappings.txt": "@lib/=lib/counter/\n",
+		"src/Base.sol": `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Base {
+    address public owner;
+}
+`,
+		"src/Token.sol": `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+import "./Base.sol";
+import {Counter} from "@lib/Counter.sol";
+
+contract Token is Base {
+    uint256 public total;
+
+    function mint(uint256 amount) external {
+        total += amount;
+        total++;
+    }
+}
+`,
+		"lib/counter/Counter.sol": `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Counter {
+    uint256 public hits;
+
+    function hit() external {
+        hits++;
+    }
+}
+`,
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	project, err := printer.NewProject("", filepath.Join(dir, "src"))
+	assert.NoError(t, err)
+	builder, err := printer.GetProjectBuilder(context.Background(), project)
+	assert.NoError(t, err)
+	assert.Empty(t, builder.Parse())
+	assert.NoError(t, builder.Build())
+	assert.Empty(t, builder.GetAstBuilder().ResolveReferences())
+
+	opt := optimizer.NewOptimizer(builder)
+	for _, unit := range builder.GetSources().SourceUnits {
+		if project.IsLibrary(unit.Path) {
+			opt.ReadOnly(unit.Path)
+		}
+	}
+	changes := opt.UsePrefixIncrements()
+	// the library is read but left alone
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, filepath.Join(dir, "src", "Token.sol"), changes[0].File)
+		assert.Equal(t, 12, changes[0].Src.Line)
+		assert.Equal(t, "total++", changes[0].Before)
+	}
+
+	edited := make(map[string]string, 0)
+	for _, file := range opt.Edits() {
+		code, err := file.Apply()
+		assert.NoError(t, err)
+		rel, _ := filepath.Rel(dir, file.Path)
+		edited[filepath.ToSlash(rel)] = code
+	}
+	assert.NotContains(t, edited, "lib/counter/Counter.sol")
+	assert.Equal(t, files["src/Base.sol"], edited["src/Base.sol"])
+	assert.Equal(t, strings.Replace(files["src/Token.sol"], "total++", "++total", 1), edited["src/Token.sol"])
+}
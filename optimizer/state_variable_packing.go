@@ -0,0 +1,99 @@
+// Optimizes the contract storage layout by packing state variables using optimal bin packing
+package optimizer
+
+import (
+	"fmt"
+	"optimizer/optimizer/optimizer/binpack"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+func (o *Optimizer) optimizeStateVariablePacking() []Change {
+	changes := make([]Change, 0)
+	contracts := o.builder.GetRoot().GetContracts()
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		// interfaces and libraries do not have storage
+		astContract, ok := contract.GetAST().GetContract().(*ast.Contract)
+		if !ok {
+			continue
+		}
+
+		// positions in contract.Nodes that hold a variable living in storage
+		positions := make([]int, 0)
+		variables := make([]*ast.StateVariableDeclaration, 0)
+		for i, node := range astContract.GetNodes() {
+			sv, ok := node.(*ast.StateVariableDeclaration)
+			if !ok || !usesStorage(sv) {
+				continue
+			}
+			positions = append(positions, i)
+			variables = append(variables, sv)
+		}
+		if len(variables) < 2 {
+			continue
+		}
+
+		items := stateVariablesToItems(variables)
+		currentSlots := countSlots(items)
+		optimalSlots := binpack.OptimalBinPacking(items, SLOT_SIZE)
+		// only touch the layout if it actually saves a slot
+		if len(optimalSlots) >= currentSlots {
+			continue
+		}
+
+		// re-arrange the state variables in place of the original ones, the first change saves the slots
+		rationale := fmt.Sprintf("reordered so the state variables take %d storage slots instead of %d", len(optimalSlots), currentSlots)
+		gasDelta := -(currentSlots - len(optimalSlots)) * sstoreSetCost
+		idx := 0
+		for _, slot := range optimalSlots {
+			for _, item := range slot {
+				astContract.Nodes[positions[idx]] = variables[item.Idx]
+				if item.Idx != idx {
+					changes = append(changes, newChange(contract.GetName(), "", variables[idx].GetSrc(), variables[item.Idx], rationale, gasDelta))
+					gasDelta = 0
+				}
+				idx++
+			}
+		}
+	}
+	return changes
+}
+
+// constant and immutable variables are inlined into the bytecode and take no storage slot
+func usesStorage(sv *ast.StateVariableDeclaration) bool {
+	if sv.IsConstant() || sv.GetStateMutability() == ast_pb.Mutability_IMMUTABLE {
+		return false
+	}
+	return sv.GetTypeName() != nil
+}
+
+// Converts the state variables to items for bin packing
+func stateVariablesToItems(variables []*ast.StateVariableDeclaration) []binpack.Item {
+	items := make([]binpack.Item, len(variables))
+	for i, sv := range variables {
+		items[i] = binpack.Item{
+			Idx:  i,
+			Size: sizeOf(sv.GetTypeName().GetName()),
+		}
+	}
+	return items
+}
+
+// countSlots returns the number of slots the items take up in declaration order,
+// which is how the compiler lays out storage
+func countSlots(items []binpack.Item) int {
+	slots := 0
+	used := SLOT_SIZE
+	for _, item := range items {
+		if used+item.Size > SLOT_SIZE {
+			slots++
+			used = 0
+		}
+		used += item.Size
+	}
+	return slots
+}
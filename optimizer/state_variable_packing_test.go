@@ -0,0 +1,73 @@
+// test file for state_variable_packing.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const statePackingContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Loose {
+    uint128 public a;
+    uint256 public b;
+    // the owner of the contract
+    address public owner;
+    uint256 public constant LIMIT = 10;
+    uint128 public c;
+    bool public paused;
+
+    function set(uint128 value) external {
+        a = value;
+        c = value;
+    }
+}
+
+contract Packed {
+    uint128 public a;
+    uint128 public b;
+    uint256 public c;
+}
+
+contract Single {
+    uint256 public a;
+}
+`
+
+func TestPackStateVariables(t *testing.T) {
+	builder := setUpBuilder(t, statePackingContract)
+	opt := optimizer.NewOptimizer(builder)
+	changes := opt.PackStateVariables()
+	// only the contract whose layout saves a slot is touched, the first change holds the saving
+	if assert.NotEmpty(t, changes) {
+		for _, change := range changes {
+			assert.Equal(t, "Loose", change.Contract)
+		}
+		assert.Equal(t, -20000, changes[0].GasDelta)
+	}
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the variables fill three slots instead of four, the constant stays where it was
+	// and the comments move with their declarations
+	assert.Contains(t, code, `contract Loose {
+    uint256 public b;
+    // the owner of the contract
+    address public owner;
+    bool public paused;
+    uint256 public constant LIMIT = 10;
+    uint128 public a;
+    uint128 public c;
+`)
+	assert.Contains(t, code, `contract Packed {
+    uint128 public a;
+    uint128 public b;
+    uint256 public c;
+}`)
+
+	// the layout is already optimal
+	assert.Empty(t, opt.PackStateVariables())
+}
@@ -0,0 +1,513 @@
+// Caches repeated reads of mapping and array elements and struct members of state variables
+package optimizer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"go.uber.org/zap"
+)
+
+// elementAccess is an access to a part of a state variable such as `balances[msg.sender]` or `orders[id].amount`
+type elementAccess struct {
+	node ast.Node[ast.NodeType]
+	key  string
+	sv   *ast.StateVariableDeclaration
+	// the type of the element, a value type or a struct
+	typeName *ast.TypeName
+	// an index of an array is taken on the way, which reverts when it is out of bounds
+	mayRevert bool
+	// the access ends at a struct, which is cached as a storage pointer
+	isStruct bool
+}
+
+// elementCacher caches the element accesses of a single function
+type elementCacher struct {
+	contract string
+	tree     *ast.Tree
+	fn       *ast.Function
+	body     *ast.BodyNode
+	model    GasModel
+	visible  map[string]*ast.StateVariableDeclaration
+	weights  map[ast.Node[ast.NodeType]]float64
+	// parameters and variables declared at the top of the body, the only ones an index may use
+	scoped map[string]bool
+	// variables assigned to anywhere in the function
+	written map[string]bool
+	names   map[string]bool
+	// state variables by the identifiers referring to them
+	stateVariables map[*ast.PrimaryExpression]*ast.StateVariableDeclaration
+	// changes holds a change for every access cached
+	changes []Change
+}
+
+// cacheStorageElements hoists repeated element and member accesses of state variables into local variables.
+// Value types are copied into a local variable and structs are held by a storage pointer.
+// Indices may only be literals, `msg`, `tx` and `block` members and variables that are never assigned to.
+// A value is cached until a statement writes to the same state variable or makes a call that may touch storage,
+// a storage pointer until the element itself or its container is written to or such a call is made.
+// Returns a change for every access cached.
+func cacheStorageElements(contract string, f *ast.Function, visible map[string]*ast.StateVariableDeclaration, model GasModel) []Change {
+	body := f.GetBody()
+	if body == nil || hasUnsupportedStatement(body) {
+		return nil
+	}
+	c := &elementCacher{
+		contract: contract,
+		tree:     f.GetTree(),
+		fn:       f,
+		body:     body,
+		model:    model,
+		visible:  visible,
+		weights:  model.weights(body),
+		scoped:   make(map[string]bool, 0),
+		written:  make(map[string]bool, 0),
+		names:    localNames(f),
+	}
+	for _, list := range []*ast.ParameterList{f.GetParameters(), f.GetReturnParameters()} {
+		if list == nil {
+			continue
+		}
+		for _, p := range list.GetParameters() {
+			c.scoped[p.GetName()] = true
+		}
+	}
+	for _, stmt := range body.GetStatements() {
+		if declaration, ok := stmt.(*ast.VariableDeclaration); ok {
+			for _, d := range declaration.GetDeclarations() {
+				c.scoped[d.GetName()] = true
+			}
+		}
+	}
+	for ident := range writtenIdentifiers(body) {
+		c.written[ident.GetName()] = true
+	}
+	// every hoisted access changes the statements, so the accesses are collected again
+	for c.cacheNext() {
+	}
+	return c.changes
+}
+
+// cacheNext caches the first access worth caching and reports whether there was one.
+// Value types go first, so a struct member read more than once ends up in a local variable
+// rather than being read through a pointer to its struct.
+func (c *elementCacher) cacheNext() bool {
+	stateVariables, references := collectStateVariableReferences(c.fn, c.visible)
+	c.stateVariables = make(map[*ast.PrimaryExpression]*ast.StateVariableDeclaration, 0)
+	for id, refs := range references {
+		if !usesStorage(stateVariables[id]) {
+			continue
+		}
+		for _, ref := range refs {
+			c.stateVariables[ref] = stateVariables[id]
+		}
+	}
+
+	statements := c.body.GetStatements()
+	accesses := make([][]*elementAccess, len(statements))
+	keys := make([]*elementAccess, 0)
+	seenKeys := make(map[string]bool, 0)
+	for i, stmt := range statements {
+		accesses[i] = c.accessesIn(stmt)
+		for _, access := range accesses[i] {
+			if !seenKeys[access.key] {
+				seenKeys[access.key] = true
+				keys = append(keys, access)
+			}
+		}
+	}
+	for _, structs := range []bool{false, true} {
+		for _, first := range keys {
+			if first.isStruct == structs && c.cacheRuns(first, statements, accesses) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheRuns splits the statements into runs in which the access keeps its value and caches the first run
+// worth caching. Statements that invalidate the access are left out of every run.
+func (c *elementCacher) cacheRuns(first *elementAccess, statements []ast.Node[ast.NodeType], accesses [][]*elementAccess) bool {
+	start := -1
+	run := make([]*elementAccess, 0)
+	for i := 0; i <= len(statements); i++ {
+		if i < len(statements) && !c.invalidates(statements[i], first) {
+			for _, access := range accesses[i] {
+				if access.key == first.key {
+					if start == -1 {
+						start = i
+					}
+					run = append(run, access)
+				}
+			}
+			continue
+		}
+		if start == -1 {
+			continue
+		}
+		if savings, ok := c.worthCaching(statements[start], run); ok {
+			c.hoist(start, run, savings)
+			return true
+		}
+		start = -1
+		run = run[:0]
+	}
+	return false
+}
+
+// worthCaching reports whether caching the accesses of a run saves gas, and how much. An access that may revert
+// is only hoisted out of the first statement of the run if that statement always evaluates it.
+func (c *elementCacher) worthCaching(first ast.Node[ast.NodeType], run []*elementAccess) (int, bool) {
+	reads := 0.0
+	for _, access := range run {
+		weight, ok := c.weights[access.node]
+		if !ok {
+			weight = 1
+		}
+		reads += weight
+	}
+	if run[0].mayRevert {
+		evaluated := alwaysEvaluated(first)
+		unconditional := false
+		for _, access := range run {
+			unconditional = unconditional || evaluated[access.node]
+		}
+		if !unconditional {
+			return 0, false
+		}
+	}
+	if run[0].isStruct {
+		// a storage pointer saves computing the slot again, it is worth it as soon as the element is used twice
+		return int(reads-1) * keccakCost, reads >= 2
+	}
+	savings := c.model.cachingSavings(reads, 0)
+	return savings, savings > 0
+}
+
+// hoist declares the local variable before the first statement of the run and points the accesses at it
+func (c *elementCacher) hoist(start int, run []*elementAccess, savings int) {
+	first := run[0]
+	location := ast_pb.StorageLocation_DEFAULT
+	if first.isStruct {
+		location = ast_pb.StorageLocation_STORAGE
+	}
+	declaration := &ast.Declaration{
+		Id:              nextID(c.body),
+		Name:            uniqueName(c.names, cachedElementName(first.key)),
+		NodeType:        ast_pb.NodeType_VARIABLE_DECLARATION,
+		TypeName:        first.typeName,
+		StorageLocation: location,
+	}
+	replaced := make(map[ast.Node[ast.NodeType]]bool, len(run))
+	for _, access := range run {
+		replaced[access.node] = true
+	}
+	for _, stmt := range c.body.GetStatements()[start:] {
+		rewriteNodes(stmt, func(node ast.Node[ast.NodeType], parent ast.Node[ast.NodeType]) ast.Node[ast.NodeType] {
+			if !replaced[node] {
+				return node
+			}
+			return &ast.PrimaryExpression{
+				Id:                    nextID(c.body),
+				NodeType:              ast_pb.NodeType_IDENTIFIER,
+				Name:                  declaration.GetName(),
+				ReferencedDeclaration: declaration.GetId(),
+				TypeName:              first.typeName,
+				TypeDescription:       node.GetTypeDescription(),
+			}
+		})
+	}
+	// the first access moves into the declaration, where it is read once
+	c.weights[first.node] = 1
+	statement := &ast.VariableDeclaration{
+		Id:           nextID(c.body),
+		Declarations: []*ast.Declaration{declaration},
+		NodeType:     ast_pb.NodeType_VARIABLE_DECLARATION,
+		InitialValue: first.node,
+	}
+	statements := make([]ast.Node[ast.NodeType], 0, len(c.body.GetStatements())+1)
+	statements = append(statements, c.body.GetStatements()[:start]...)
+	statements = append(statements, statement)
+	statements = append(statements, c.body.GetStatements()[start:]...)
+	c.body.Statements = statements
+	c.changes = append(c.changes, newChange(c.contract, c.fn.GetName(), c.body.GetSrc(), c.body,
+		fmt.Sprintf("%s is read %d times without changing, so it is read once into %s", first.key, len(run), declaration.GetName()), -savings))
+	zap.L().Info("Cached state variable element", zap.String("function", c.fn.GetName()), zap.String("element", first.key), zap.String("local", declaration.GetName()))
+}
+
+// accessesIn returns the element accesses read in the statement that can be cached
+func (c *elementCacher) accessesIn(stmt ast.Node[ast.NodeType]) []*elementAccess {
+	accesses := make([]*elementAccess, 0)
+	callees := make(map[ast.Node[ast.NodeType]]bool, 0)
+	walk(stmt, func(node ast.Node[ast.NodeType]) bool {
+		if call, ok := node.(*ast.FunctionCall); ok {
+			callees[call.GetExpression()] = true
+		}
+		return true
+	})
+	seen := make(map[ast.Node[ast.NodeType]]bool, 0)
+	walk(stmt, func(node ast.Node[ast.NodeType]) bool {
+		if seen[node] || callees[node] {
+			return true
+		}
+		seen[node] = true
+		if node.GetType() != ast_pb.NodeType_INDEX_ACCESS && node.GetType() != ast_pb.NodeType_MEMBER_ACCESS {
+			return true
+		}
+		access, ok := c.access(node)
+		if ok && (access.isStruct || isValueType(access.typeName)) {
+			accesses = append(accesses, access)
+		}
+		return true
+	})
+	return accesses
+}
+
+// access resolves an index or member access of a state variable, the type of what it reads and its key.
+// Returns false if it is not one or if an index can not be cached.
+func (c *elementCacher) access(node ast.Node[ast.NodeType]) (*elementAccess, bool) {
+	switch current := node.(type) {
+	case *ast.IndexAccess:
+		base, ok := c.access(current.GetBaseExpression())
+		if !ok || base.typeName == nil {
+			return nil, false
+		}
+		index, ok := c.indexKey(current.GetIndexExpression())
+		if !ok {
+			return nil, false
+		}
+		typeName, isArray := elementTypeName(base.typeName)
+		if typeName == nil {
+			return nil, false
+		}
+		return &elementAccess{node: node, key: fmt.Sprintf("%s[%s]", base.key, index), sv: base.sv, typeName: typeName, mayRevert: base.mayRevert || isArray, isStruct: c.isStruct(typeName)}, true
+	case *ast.MemberAccessExpression:
+		base, ok := c.access(current.GetExpression())
+		if !ok || base.typeName == nil {
+			return nil, false
+		}
+		typeName := c.memberTypeName(base.typeName, current.GetMemberName())
+		if typeName == nil {
+			return nil, false
+		}
+		return &elementAccess{node: node, key: base.key + "." + current.GetMemberName(), sv: base.sv, typeName: typeName, mayRevert: base.mayRevert, isStruct: c.isStruct(typeName)}, true
+	case *ast.PrimaryExpression:
+		// the state variable itself is the root of the access, not an access to cache
+		if sv, ok := c.stateVariables[current]; ok {
+			return &elementAccess{key: current.GetName(), sv: sv, typeName: sv.GetTypeName()}, true
+		}
+	}
+	return nil, false
+}
+
+// indexKey returns the text of an index that keeps its value for the whole function
+func (c *elementCacher) indexKey(node ast.Node[ast.NodeType]) (string, bool) {
+	switch node := node.(type) {
+	case *ast.PrimaryExpression:
+		if node.GetType() == ast_pb.NodeType_LITERAL {
+			return node.GetValue(), node.GetValue() != ""
+		}
+		return node.GetName(), c.scoped[node.GetName()] && !c.written[node.GetName()]
+	case *ast.MemberAccessExpression:
+		// msg.sender, block.timestamp and the like do not change during a call
+		ident, ok := node.GetExpression().(*ast.PrimaryExpression)
+		if ok && (ident.GetName() == "msg" || ident.GetName() == "tx" || ident.GetName() == "block") && !c.scoped[ident.GetName()] {
+			return ident.GetName() + "." + node.GetMemberName(), true
+		}
+	case *ast.FunctionCall:
+		if isConversion(node) {
+			argument, ok := c.indexKey(node.GetArguments()[0])
+			return fmt.Sprintf("%s(%s)", node.GetExpression().(*ast.PrimaryExpression).GetName(), argument), ok
+		}
+	}
+	return "", false
+}
+
+// invalidates reports whether the statement may change the value of the access or where it points to
+func (c *elementCacher) invalidates(stmt ast.Node[ast.NodeType], access *elementAccess) bool {
+	if containsStorageCall(c.tree, stmt) {
+		return true
+	}
+	for _, target := range c.writeTargets(stmt) {
+		root, key := c.targetKey(target)
+		if root == nil || c.stateVariables[root] != access.sv {
+			continue
+		}
+		// writing to a member or an element of the struct leaves the pointer to it untouched
+		if access.isStruct && (strings.HasPrefix(key, access.key+".") || strings.HasPrefix(key, access.key+"[")) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// writeTargets returns the expressions assigned to, incremented, decremented, deleted, pushed to or popped from
+func (c *elementCacher) writeTargets(stmt ast.Node[ast.NodeType]) []ast.Node[ast.NodeType] {
+	targets := make([]ast.Node[ast.NodeType], 0)
+	var add func(ast.Node[ast.NodeType])
+	add = func(target ast.Node[ast.NodeType]) {
+		if tuple, ok := target.(*ast.TupleExpression); ok {
+			for _, component := range tuple.GetComponents() {
+				add(component)
+			}
+			return
+		}
+		targets = append(targets, target)
+	}
+	walk(stmt, func(node ast.Node[ast.NodeType]) bool {
+		switch node := node.(type) {
+		case *ast.Assignment:
+			if node.LeftExpression != nil {
+				add(node.LeftExpression)
+			}
+		case *ast.UnaryPrefix:
+			if isIncrementOrDecrement(node.GetOperator()) {
+				add(node.GetExpression())
+			}
+		case *ast.UnarySuffix:
+			if isIncrementOrDecrement(node.GetOperator()) {
+				add(node.GetExpression())
+			}
+		case *ast.FunctionCall:
+			member, ok := node.GetExpression().(*ast.MemberAccessExpression)
+			if ok && (member.GetMemberName() == "push" || member.GetMemberName() == "pop") {
+				add(member.GetExpression())
+			}
+		}
+		return true
+	})
+	return targets
+}
+
+// targetKey returns the identifier a write target starts from and its text, with `?` for indices that can not be named
+func (c *elementCacher) targetKey(target ast.Node[ast.NodeType]) (*ast.PrimaryExpression, string) {
+	switch target := target.(type) {
+	case *ast.PrimaryExpression:
+		return target, target.GetName()
+	case *ast.IndexAccess:
+		root, key := c.targetKey(target.GetBaseExpression())
+		index, ok := c.indexKey(target.GetIndexExpression())
+		if !ok {
+			index = "?"
+		}
+		return root, fmt.Sprintf("%s[%s]", key, index)
+	case *ast.MemberAccessExpression:
+		root, key := c.targetKey(target.GetExpression())
+		return root, key + "." + target.GetMemberName()
+	case *ast.TupleExpression:
+		if len(target.GetComponents()) == 1 {
+			return c.targetKey(target.GetComponents()[0])
+		}
+	}
+	return nil, ""
+}
+
+// memberTypeName returns the type of a member of a struct, or of the length of an array
+func (c *elementCacher) memberTypeName(typeName *ast.TypeName, member string) *ast.TypeName {
+	if typeName.ValueType != nil {
+		return nil
+	}
+	if strings.HasSuffix(typeName.GetName(), "]") {
+		if member != "length" {
+			return nil
+		}
+		return &ast.TypeName{ASTBuilder: typeName.ASTBuilder, NodeType: ast_pb.NodeType_ELEMENTARY_TYPE_NAME, Name: "uint256"}
+	}
+	structDef, ok := c.tree.GetById(typeName.GetReferencedDeclaration()).(*ast.StructDefinition)
+	if !ok {
+		return nil
+	}
+	for _, m := range structDef.GetMembers() {
+		if m.GetName() == member {
+			return m.GetTypeName()
+		}
+	}
+	return nil
+}
+
+// elementTypeName returns the type of the values of a mapping or the elements of an array,
+// and whether it is an array
+func elementTypeName(typeName *ast.TypeName) (*ast.TypeName, bool) {
+	if typeName.ValueType != nil {
+		return typeName.ValueType, false
+	}
+	name := typeName.GetName()
+	if !strings.HasSuffix(name, "]") {
+		return nil, false
+	}
+	element := *typeName
+	element.Id = 0
+	element.Name = name[:strings.LastIndex(name, "[")]
+	element.TypeDescription = nil
+	if !strings.HasSuffix(element.Name, "]") && element.ReferencedDeclaration == 0 {
+		element.NodeType = ast_pb.NodeType_ELEMENTARY_TYPE_NAME
+	}
+	return &element, true
+}
+
+// isValueType reports whether a local variable of the type holds a copy of the value
+func isValueType(typeName *ast.TypeName) bool {
+	if typeName.ValueType != nil {
+		return false
+	}
+	_, ok := sizeMap[typeName.GetName()]
+	return ok
+}
+
+// isStruct reports whether the type names a struct, rather than an array or a mapping of structs
+func (c *elementCacher) isStruct(typeName *ast.TypeName) bool {
+	if typeName.ValueType != nil || strings.HasSuffix(typeName.GetName(), "]") {
+		return false
+	}
+	_, ok := c.tree.GetById(typeName.GetReferencedDeclaration()).(*ast.StructDefinition)
+	return ok
+}
+
+// alwaysEvaluated returns the nodes of the statement that are evaluated every time the statement is,
+// leaving out branches, loop bodies and the right operand of && and ||
+func alwaysEvaluated(stmt ast.Node[ast.NodeType]) map[ast.Node[ast.NodeType]]bool {
+	evaluated := make(map[ast.Node[ast.NodeType]]bool, 0)
+	var visit func(node ast.Node[ast.NodeType])
+	visit = func(node ast.Node[ast.NodeType]) {
+		if isNilNode(node) || evaluated[node] {
+			return
+		}
+		evaluated[node] = true
+		switch node := node.(type) {
+		case *ast.IfStatement:
+			visit(node.GetCondition())
+		case *ast.ForStatement:
+			visit(node.Initialiser)
+			visit(node.Condition)
+		case *ast.WhileStatement:
+			visit(node.GetCondition())
+		case *ast.Conditional:
+			if len(node.Expressions) > 0 {
+				visit(node.Expressions[0])
+			}
+		default:
+			if isShortCircuit(node) {
+				left, _ := operandsOf(node)
+				visit(left)
+				return
+			}
+			for _, child := range node.GetNodes() {
+				visit(child)
+			}
+		}
+	}
+	visit(stmt)
+	return evaluated
+}
+
+var nonIdentifierCharacters = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// cachedElementName turns `orders[id].amount` into `cached_orders_id_amount`
+func cachedElementName(key string) string {
+	return "cached_" + strings.Trim(nonIdentifierCharacters.ReplaceAllString(key, "_"), "_")
+}
@@ -0,0 +1,85 @@
+// test file for storage_element_caching.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const elementCachingContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Orders {
+    struct Order {
+        uint256 amount;
+        address owner;
+    }
+    mapping(address => uint256) balances;
+    mapping(uint256 => Order) orders;
+    uint256[] values;
+
+    function withdraw(uint256 amount) external {
+        require(balances[msg.sender] >= amount);
+        uint256 left = balances[msg.sender] - amount;
+        balances[msg.sender] = left;
+        emit Withdrawn(balances[msg.sender]);
+    }
+
+    function fill(uint256 id, uint256 amount) external {
+        require(orders[id].owner == msg.sender);
+        require(orders[id].amount >= amount);
+        orders[id].amount -= amount;
+    }
+
+    function pick(uint256 i, bool flag) external view returns (uint256) {
+        if (flag) return values[i] + values[i];
+        return 0;
+    }
+
+    function moved(uint256 i) external returns (uint256) {
+        uint256 j = i;
+        j++;
+        return balances[address(uint160(j))] + balances[address(uint160(j))];
+    }
+
+    event Withdrawn(uint256 amount);
+}
+`
+
+// returns the declarations of local variables at the top level of the function, with their storage location
+func localDeclarations(builder *ir.Builder, contractName string, name string) map[string]string {
+	locals := make(map[string]string, 0)
+	for _, statement := range findFunction(builder, contractName, name).GetBody().GetStatements() {
+		if declaration, ok := statement.(*ast.VariableDeclaration); ok {
+			for _, d := range declaration.GetDeclarations() {
+				locals[d.GetName()] = d.GetStorageLocation().String()
+			}
+		}
+	}
+	return locals
+}
+
+func TestCacheStorageElements(t *testing.T) {
+	builder := setUpBuilder(t, elementCachingContract)
+	optimizer.NewOptimizer(builder).CacheStorageVariables(optimizer.DefaultGasModel)
+
+	// the write ends the cached value, the read after it is not cached
+	withdraw := localDeclarations(builder, "Orders", "withdraw")
+	assert.Equal(t, "DEFAULT", withdraw["cached_balances_msg_sender"])
+	assert.NotContains(t, withdraw, "cached_balances_msg_sender_2")
+	assert.Len(t, findFunction(builder, "Orders", "withdraw").GetBody().GetStatements(), 5)
+
+	// writing to a member keeps the pointer to the struct
+	fill := localDeclarations(builder, "Orders", "fill")
+	assert.Equal(t, "STORAGE", fill["cached_orders_id"])
+	assert.Len(t, fill, 1)
+
+	// an array index out of bounds reverts, so it is not read before the branch
+	assert.Empty(t, localDeclarations(builder, "Orders", "pick"))
+	// the index changes
+	assert.Equal(t, map[string]string{"j": "DEFAULT"}, localDeclarations(builder, "Orders", "moved"))
+}
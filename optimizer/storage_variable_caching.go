@@ -2,117 +2,263 @@ package optimizer
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	ast_pb "github.com/unpackdev/protos/dist/go/ast"
 	"github.com/unpackdev/solgo/ast"
+	"go.uber.org/zap"
 )
 
-// caches storage variables in local variables
-func (o *Optimizer) optimizeStorageVariableCaching() {
+// caches storage variables in local variables when the gas model estimates that it saves gas
+func (o *Optimizer) optimizeStorageVariableCaching(model GasModel) []Change {
+	changes := make([]Change, 0)
+	tree := o.builder.GetAstBuilder().GetTree()
 	contracts := o.builder.GetRoot().GetContracts()
 	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		visible := make(map[string]*ast.StateVariableDeclaration, 0)
+		if astContract, ok := contract.GetAST().GetContract().(*ast.Contract); ok {
+			visible = visibleStateVariables(tree, astContract)
+		}
 		// iterate through the contract's functions
 		functions := contract.GetFunctions()
 		for _, f := range functions {
 			modifier := f.GetStateMutability()
-			// TODO: we can check if the function modifies the state, but that is another feature
-			if modifier != ast_pb.Mutability_VIEW {
+			// pure functions cannot read storage
+			if modifier == ast_pb.Mutability_PURE || f.GetAST().GetBody() == nil {
 				continue
 			}
-			stateVariables := make(map[int64]*ast.StateVariableDeclaration, 0)
-			referencesToStateVariables := make(map[int64][]*ast.PrimaryExpression, 0)
-			tree := f.GetAST().GetTree()
-			tree.ExecuteCustomTypeVisit(f.GetAST().GetNodes(), ast_pb.NodeType_IDENTIFIER, func(node ast.Node[ast.NodeType]) (bool, error) {
-				var exp *ast.PrimaryExpression
-				var ok bool
-				if exp, ok = node.(*ast.PrimaryExpression); !ok {
-					return true, nil
+			changes = append(changes, cacheStorageElements(contract.GetName(), f.GetAST(), visible, model)...)
+			stateVariables, referencesToStateVariables := collectStateVariableReferences(f.GetAST(), visible)
+			weights := model.weights(f.GetAST().GetBody())
+			assigned := assignedIdentifiers(f.GetAST().GetBody())
+			written := writtenIdentifiers(f.GetAST().GetBody())
+			estimates := make(map[int64]int, 0)
+			locals := localNames(f.GetAST())
+
+			for id, refs := range referencesToStateVariables {
+				sv := stateVariables[id]
+				// the references left over from caching the variable before, such as its write-backs, are not cached again
+				if !usesStorage(sv) || locals[fmt.Sprintf("cached_%s", sv.GetName())] {
+					delete(referencesToStateVariables, id)
+					continue
 				}
-				// referenced declaration of 0 referes to a built in function
-				if exp.GetReferencedDeclaration() == 0 {
-					return true, nil
+				location, err := cachedLocation(tree, sv.GetTypeName())
+				if err != nil {
+					zap.L().Info("Skipping state variable", zap.String("function", f.GetName()), zap.String("variable", sv.GetName()), zap.Error(err))
+					delete(referencesToStateVariables, id)
+					continue
+				}
+				if location == ast_pb.StorageLocation_STORAGE {
+					// the elements and fields read more than once are already cached, a storage pointer to the variable
+					// itself would compute the same slots
+					zap.L().Debug("Not caching state variable, only its elements and fields", zap.String("function", f.GetName()), zap.String("variable", sv.GetName()))
+					delete(referencesToStateVariables, id)
+					continue
 				}
+				reads, writes := 0.0, 0.0
+				for _, ref := range refs {
+					weight, ok := weights[ref]
+					if !ok {
+						weight = 1
+					}
+					if written[ref] {
+						writes += weight
+					}
+					if !assigned[ref] {
+						reads += weight
+					}
+				}
+				savings := model.cachingSavings(reads, writes)
+				if savings <= 0 {
+					zap.L().Debug("Not caching state variable", zap.String("function", f.GetName()), zap.String("variable", sv.GetName()), zap.Int("estimated savings", savings))
+					delete(referencesToStateVariables, id)
+					continue
+				}
+				estimates[id] = savings
+			}
 
-				isStateVariable := false
-				var decl *ast.StateVariableDeclaration
+			// go through the variables in declaration order so the output is deterministic
+			ids := make([]int64, 0, len(referencesToStateVariables))
+			for id := range referencesToStateVariables {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-				if _, ok = stateVariables[exp.GetReferencedDeclaration()]; ok {
-					isStateVariable = true
-					decl = stateVariables[exp.GetReferencedDeclaration()]
-				} else {
-					if d, ok := tree.GetById(exp.GetReferencedDeclaration()).(*ast.StateVariableDeclaration); ok {
-						if d.IsStateVariable() {
-							isStateVariable = true
-							decl = d
-						}
+			for _, id := range ids {
+				sv := stateVariables[id]
+				if modifier != ast_pb.Mutability_VIEW {
+					if !cacheStateVariableWithWriteBack(f.GetAST(), sv, referencesToStateVariables[id]) {
+						continue
 					}
+				} else {
+					// HACK: doing this screws up the numbering of the nodes
+					cached := InsertCachedVariable(f.GetBody().Unit, sv, ast_pb.StorageLocation_DEFAULT)
+					renameReferences(referencesToStateVariables[id], cached)
 				}
+				zap.L().Info("Cached state variable", zap.String("function", f.GetName()), zap.String("variable", sv.GetName()), zap.Int("estimated savings", estimates[id]))
+				changes = append(changes, newChange(contract.GetName(), f.GetName(), f.GetAST().GetBody().GetSrc(), f.GetAST().GetBody(),
+					fmt.Sprintf("%s is read and written through a local variable, which only touches storage once", sv.GetName()), -estimates[id]))
+			}
+		}
+	}
+	return changes
+}
 
-				if isStateVariable && decl != nil {
-					stateVariables[decl.GetId()] = decl
-					referencesToStateVariables[decl.GetId()] = append(referencesToStateVariables[decl.GetId()], exp)
-				}
+// cachedLocation returns where a cached state variable of the type lives.
+// Value types are copied to the stack. Arrays, structs, strings and bytes stay in storage, since copying them
+// reads every slot, and mappings can not be copied at all, so only their elements and fields are cached.
+func cachedLocation(tree *ast.Tree, typeName *ast.TypeName) (ast_pb.StorageLocation, error) {
+	if typeName == nil {
+		return ast_pb.StorageLocation_DEFAULT, fmt.Errorf("missing type name")
+	}
+	if typeName.ValueType != nil || isMapping(typeName) {
+		return ast_pb.StorageLocation_DEFAULT, fmt.Errorf("mappings are not cached, only their elements are")
+	}
+	name := typeName.GetName()
+	if _, ok := sizeMap[name]; ok {
+		return ast_pb.StorageLocation_DEFAULT, nil
+	}
+	if strings.HasSuffix(name, "]") || name == "string" || name == "bytes" {
+		return ast_pb.StorageLocation_STORAGE, nil
+	}
+	if _, ok := tree.GetById(typeName.GetReferencedDeclaration()).(*ast.StructDefinition); ok {
+		return ast_pb.StorageLocation_STORAGE, nil
+	}
+	return ast_pb.StorageLocation_DEFAULT, fmt.Errorf("unsupported type %s", name)
+}
 
-				return true, nil
-			})
+// collectStateVariableReferences returns the state variables read or written in the function
+// along with every identifier that refers to them.
+// Identifiers inside call arguments are often left unresolved by the parser, so those are matched
+// by name against the visible state variables unless a parameter or local variable shadows them.
+func collectStateVariableReferences(f *ast.Function, visible map[string]*ast.StateVariableDeclaration) (map[int64]*ast.StateVariableDeclaration, map[int64][]*ast.PrimaryExpression) {
+	stateVariables := make(map[int64]*ast.StateVariableDeclaration, 0)
+	referencesToStateVariables := make(map[int64][]*ast.PrimaryExpression, 0)
+	seen := make(map[*ast.PrimaryExpression]bool, 0)
+	locals := localNames(f)
+	tree := f.GetTree()
+	tree.ExecuteCustomTypeVisit(f.GetNodes(), ast_pb.NodeType_IDENTIFIER, func(node ast.Node[ast.NodeType]) (bool, error) {
+		var exp *ast.PrimaryExpression
+		var ok bool
+		if exp, ok = node.(*ast.PrimaryExpression); !ok {
+			return true, nil
+		}
+		// the same node can be reached through more than one parent
+		if seen[exp] {
+			return true, nil
+		}
+		seen[exp] = true
 
-			for sv, ref := range referencesToStateVariables {
-				if len(ref) < 2 {
-					delete(referencesToStateVariables, sv)
+		isStateVariable := false
+		var decl *ast.StateVariableDeclaration
+
+		// unresolved identifiers either point at nothing or at themselves
+		if exp.GetReferencedDeclaration() == 0 || exp.GetReferencedDeclaration() == exp.GetId() {
+			if d, ok := visible[exp.GetName()]; ok && !locals[exp.GetName()] {
+				isStateVariable = true
+				decl = d
+			}
+		} else if _, ok = stateVariables[exp.GetReferencedDeclaration()]; ok {
+			isStateVariable = true
+			decl = stateVariables[exp.GetReferencedDeclaration()]
+		} else {
+			if d, ok := tree.GetById(exp.GetReferencedDeclaration()).(*ast.StateVariableDeclaration); ok {
+				if d.IsStateVariable() {
+					isStateVariable = true
+					decl = d
 				}
 			}
+		}
 
-			for _, sv := range stateVariables {
-				if _, ok := referencesToStateVariables[sv.GetId()]; !ok {
-					continue
-				}
-				// HACK: doing this screws up the numbering of the nodes
-				InsertCachedVariable(f.GetBody().Unit, sv)
-				for _, ident := range referencesToStateVariables[sv.GetId()] {
-					ident.Name = fmt.Sprintf("cached_%s", sv.GetName())
-				}
+		if isStateVariable && decl != nil {
+			stateVariables[decl.GetId()] = decl
+			referencesToStateVariables[decl.GetId()] = append(referencesToStateVariables[decl.GetId()], exp)
+		}
+
+		return true, nil
+	})
+	return stateVariables, referencesToStateVariables
+}
+
+// assignedIdentifiers returns the identifiers that are overwritten by a plain assignment without being read
+func assignedIdentifiers(node ast.Node[ast.NodeType]) map[*ast.PrimaryExpression]bool {
+	assigned := make(map[*ast.PrimaryExpression]bool, 0)
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if assignment, ok := n.(*ast.Assignment); ok && assignment.GetOperator() == ast_pb.Operator_EQUAL {
+			if ident, ok := assignment.LeftExpression.(*ast.PrimaryExpression); ok {
+				assigned[ident] = true
 			}
 		}
+		return true
+	})
+	return assigned
+}
+
+// renameReferences points the identifiers at the cached local variable
+func renameReferences(references []*ast.PrimaryExpression, cached *ast.Declaration) {
+	for _, ident := range references {
+		ident.Name = cached.GetName()
+		if cached.GetId() != 0 {
+			ident.ReferencedDeclaration = cached.GetId()
+		}
 	}
 }
 
-// InsertCachedVariable inserts a cached variable declaration at the beginning of the function body
-func InsertCachedVariable(body *ast.BodyNode, sv *ast.StateVariableDeclaration) {
+// InsertCachedVariable inserts a cached variable declaration at the beginning of the function body.
+// The location is DEFAULT for a copy of a value type and STORAGE for a pointer to an array or a struct.
+func InsertCachedVariable(body *ast.BodyNode, sv *ast.StateVariableDeclaration, loc ast_pb.StorageLocation) *ast.Declaration {
 	// create a new variable declaration
 	cachedName := fmt.Sprintf("cached_%s", sv.GetName())
 
-	// check if the type of the state variable is a reference type
-	hasStorageLocation := false
-	referenceTypes := []string{"mapping", "array", "struct"}
-	for _, t := range referenceTypes {
-		if strings.Contains(sv.GetTypeDescription().GetIdentifier(), t) {
-			hasStorageLocation = true
-			break
-		}
-	}
-	// if the type is a reference type, we need to store it in memory
-	loc := ast_pb.StorageLocation_DEFAULT
-	if hasStorageLocation {
-		loc = ast_pb.StorageLocation_MEMORY
+	declaration := &ast.Declaration{
+		Name:            cachedName,
+		NodeType:        ast_pb.NodeType_VARIABLE_DECLARATION,
+		TypeName:        sv.GetTypeName(),
+		StorageLocation: loc,
 	}
-
 	cachedVarDeclaration := &ast.VariableDeclaration{
-		Declarations: []*ast.Declaration{
-			{
-				Name:            cachedName,
-				TypeName:        sv.GetTypeName(),
-				StorageLocation: loc,
-			},
-		},
-		NodeType: ast_pb.NodeType_VARIABLE_DECLARATION,
-		InitialValue: &ast.PrimaryExpression{
-			NodeType:              ast_pb.NodeType_IDENTIFIER,
-			Name:                  sv.GetName(),
-			ReferencedDeclaration: sv.GetId(),
-			TypeName:              sv.GetTypeName(),
-		},
+		Declarations: []*ast.Declaration{declaration},
+		NodeType:     ast_pb.NodeType_VARIABLE_DECLARATION,
+		InitialValue: newStateVariableIdentifier(sv),
 	}
+	// give the new nodes their own ids so references can be resolved to them
+	cachedVarDeclaration.Id = nextID(body)
+	declaration.Id = nextID(body)
 	// put the new variable declaration at the beginning of the function body
 	body.Statements = append([]ast.Node[ast.NodeType]{cachedVarDeclaration}, body.Statements...)
+	return declaration
+}
+
+// newStateVariableIdentifier creates an identifier that reads the state variable
+func newStateVariableIdentifier(sv *ast.StateVariableDeclaration) *ast.PrimaryExpression {
+	return &ast.PrimaryExpression{
+		NodeType:              ast_pb.NodeType_IDENTIFIER,
+		Name:                  sv.GetName(),
+		ReferencedDeclaration: sv.GetId(),
+		TypeName:              sv.GetTypeName(),
+		TypeDescription:       sv.GetTypeDescription(),
+	}
+}
+
+// newLocalIdentifier creates an identifier that reads the local variable holding the state variable
+func newLocalIdentifier(local *ast.Declaration, sv *ast.StateVariableDeclaration) *ast.PrimaryExpression {
+	return &ast.PrimaryExpression{
+		NodeType:              ast_pb.NodeType_IDENTIFIER,
+		Name:                  local.GetName(),
+		ReferencedDeclaration: local.GetId(),
+		TypeName:              sv.GetTypeName(),
+		TypeDescription:       sv.GetTypeDescription(),
+	}
+}
+
+// nextID allocates a new node id from the builder the body was parsed with
+func nextID(body *ast.BodyNode) int64 {
+	if body.ASTBuilder == nil {
+		return 0
+	}
+	return body.GetNextID()
 }
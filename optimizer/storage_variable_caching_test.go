@@ -0,0 +1,134 @@
+// test file for storage_variable_caching.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const storageCachingContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Caching {
+    struct Pair {
+        uint256 a;
+        uint256 b;
+        uint256 c;
+        uint256 d;
+    }
+    uint256 total;
+    uint256 limit;
+    uint256[] values;
+    Pair pair;
+    mapping(uint256 => uint256) balances;
+
+    function branches(uint256 x) external view returns (uint256) {
+        if (x > 1) return total; else return total + 1;
+    }
+
+    function twice(uint256 x) external view returns (uint256) {
+        return total + total + x;
+    }
+
+    function loop(uint256 n) external view returns (uint256 s) {
+        for (uint256 i = 0; i < n; i++) {
+            s += limit;
+        }
+    }
+
+    function sum() external view returns (uint256 s) {
+        for (uint256 i = 0; i < values.length; i++) {
+            s += values[i];
+        }
+    }
+
+    function first() external view returns (uint256) {
+        return pair.a + pair.b;
+    }
+
+    function lookup(uint256 x, uint256 y) external view returns (uint256) {
+        return balances[x] + balances[y];
+    }
+
+    function reset() external {
+        values[0] = values[1];
+        delete values;
+    }
+
+    function bump(uint256 x) external {
+        total += x;
+        total += x;
+        payable(msg.sender).transfer(total);
+    }
+}
+`
+
+// returns the names of the cached copies declared in the function
+func cachedVariables(builder *ir.Builder, name string) []string {
+	names := make([]string, 0)
+	for _, statement := range findFunction(builder, "Caching", name).GetBody().GetStatements() {
+		if declaration, ok := statement.(*ast.VariableDeclaration); ok {
+			for _, d := range declaration.GetDeclarations() {
+				if strings.HasPrefix(d.GetName(), "cached_") {
+					names = append(names, d.GetName())
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestCacheStorageVariables(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	optimizer.NewOptimizer(builder).CacheStorageVariables(optimizer.DefaultGasModel)
+
+	// the branches of an if statement exclude each other, so total is read once
+	assert.Empty(t, cachedVariables(builder, "branches"))
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "twice"))
+	assert.Equal(t, []string{"cached_limit"}, cachedVariables(builder, "loop"))
+	// the length is read in every iteration, the array itself is neither copied nor pointed at,
+	// which would compute the same slots
+	assert.Equal(t, []string{"cached_values_length"}, cachedVariables(builder, "sum"))
+	// each field of the struct is read once
+	assert.Empty(t, localDeclarations(builder, "Caching", "first"))
+	// mappings are never copied
+	assert.Empty(t, cachedVariables(builder, "lookup"))
+	// deleting the array would not work through a pointer
+	assert.Empty(t, cachedVariables(builder, "reset"))
+}
+
+func TestCacheStorageVariablesTwice(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	opt := optimizer.NewOptimizer(builder)
+	assert.NotEmpty(t, opt.CacheStorageVariables(optimizer.DefaultGasModel))
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "bump"))
+
+	// the write-backs and reloads left by the first run are not cached again
+	assert.Empty(t, opt.CacheStorageVariables(optimizer.DefaultGasModel))
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "bump"))
+}
+
+func TestCacheStorageVariablesLoopIterations(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	model := optimizer.DefaultGasModel
+	model.LoopIterations = 1
+	optimizer.NewOptimizer(builder).CacheStorageVariables(model)
+
+	// a loop that runs once reads limit once
+	assert.Empty(t, cachedVariables(builder, "loop"))
+	assert.Equal(t, []string{"cached_total"}, cachedVariables(builder, "twice"))
+}
+
+func TestCacheStorageVariablesGasModel(t *testing.T) {
+	builder := setUpBuilder(t, storageCachingContract)
+	model := optimizer.DefaultGasModel
+	// with warm reads as cheap as the stack, reading total twice is not worth a local variable
+	model.WarmSload = model.StackRead
+	optimizer.NewOptimizer(builder).CacheStorageVariables(model)
+	assert.Empty(t, cachedVariables(builder, "twice"))
+}
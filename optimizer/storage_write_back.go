@@ -0,0 +1,313 @@
+// Caches state variables in functions that modify storage and writes the cached value back
+package optimizer
+
+import (
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+)
+
+// writeBack holds the state needed to cache a single state variable in a state-mutating function
+type writeBack struct {
+	tree       *ast.Tree
+	body       *ast.BodyNode
+	sv         *ast.StateVariableDeclaration
+	cached     *ast.Declaration
+	references map[*ast.PrimaryExpression]bool
+	written    map[*ast.PrimaryExpression]bool
+}
+
+// cacheStateVariableWithWriteBack caches the state variable in a local variable.
+// Writes go to the local copy, which is stored back before every return, before every call that may
+// touch storage and at the end of the function. The local copy is reloaded after such a call so
+// a reentrant call cannot leave us with a stale value.
+// Returns false if the function is left untouched.
+func cacheStateVariableWithWriteBack(f *ast.Function, sv *ast.StateVariableDeclaration, references []*ast.PrimaryExpression) bool {
+	// only value types can be copied into a local variable and stored back as a whole
+	if _, ok := sizeMap[sv.GetTypeName().GetName()]; !ok {
+		return false
+	}
+	body := f.GetBody()
+	wb := &writeBack{
+		tree:       f.GetTree(),
+		body:       body,
+		sv:         sv,
+		references: make(map[*ast.PrimaryExpression]bool, len(references)),
+		written:    make(map[*ast.PrimaryExpression]bool, 0),
+	}
+	for _, ref := range references {
+		wb.references[ref] = true
+	}
+	for ident := range writtenIdentifiers(body) {
+		if wb.references[ident] {
+			wb.written[ident] = true
+		}
+	}
+	if hasUnsupportedStatement(body) || !wb.canRewrite(body.GetStatements()) {
+		return false
+	}
+
+	statements := body.GetStatements()
+	wb.cached = InsertCachedVariable(body, sv, ast_pb.StorageLocation_DEFAULT)
+	renameReferences(references, wb.cached)
+
+	rewritten, dirty := wb.rewrite(statements, false, true)
+	if dirty && !endsFunction(rewritten) {
+		rewritten = append(rewritten, wb.store())
+	}
+	body.Statements = append([]ast.Node[ast.NodeType]{body.Statements[0]}, rewritten...)
+	return true
+}
+
+// hasUnsupportedStatement reports whether the body contains statements we cannot reason about.
+// Assembly can access storage directly, try statements call out of the contract in their header
+// and unchecked blocks are not kept in their original position by the parser.
+func hasUnsupportedStatement(body *ast.BodyNode) bool {
+	unsupported := false
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		switch node.GetType() {
+		case ast_pb.NodeType_ASSEMBLY_STATEMENT, ast_pb.NodeType_TRY_STATEMENT, ast_pb.NodeType_UNCHECKED_BLOCK:
+			unsupported = true
+		}
+		return !unsupported
+	})
+	return unsupported
+}
+
+// hasElseBranch reports whether the if statement had an else branch in the source.
+// The parser does not keep else branches apart from the if body, so they are detected from the source ranges.
+func hasElseBranch(stmt *ast.IfStatement) bool {
+	body, ok := stmt.GetBody().(*ast.BodyNode)
+	if !ok {
+		return false
+	}
+	// a body without braces has no source range and the else statement is appended to it
+	if body.GetSrc().End == 0 {
+		return len(body.GetStatements()) > 1
+	}
+	return stmt.GetSrc().End > body.GetSrc().End
+}
+
+// statementsOf returns the statements of a body, or the node itself if it is a single statement
+func statementsOf(node ast.Node[ast.NodeType]) []ast.Node[ast.NodeType] {
+	if body, ok := node.(*ast.BodyNode); ok {
+		return body.GetStatements()
+	}
+	return []ast.Node[ast.NodeType]{node}
+}
+
+// isLoop reports whether the statement is a for, while or do-while loop
+func isLoop(node ast.Node[ast.NodeType]) bool {
+	switch node.(type) {
+	case *ast.ForStatement, *ast.WhileStatement, *ast.DoWhileStatement:
+		return true
+	}
+	return false
+}
+
+// isRevert reports whether the statement reverts, which rolls back any storage write anyway
+func isRevert(node ast.Node[ast.NodeType]) bool {
+	switch node := node.(type) {
+	case *ast.RevertStatement:
+		return true
+	case *ast.FunctionCall:
+		ident, ok := node.GetExpression().(*ast.PrimaryExpression)
+		return ok && ident.GetName() == "revert"
+	}
+	return false
+}
+
+// endsFunction reports whether the last statement leaves the function
+func endsFunction(statements []ast.Node[ast.NodeType]) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	last := statements[len(statements)-1]
+	_, ok := last.(*ast.ReturnStatement)
+	return ok || isRevert(last)
+}
+
+// countReferences returns the number of references to the state variable inside the nodes
+func (wb *writeBack) countReferences(nodes ...ast.Node[ast.NodeType]) int {
+	count := 0
+	for _, node := range nodes {
+		walk(node, func(n ast.Node[ast.NodeType]) bool {
+			if ident, ok := n.(*ast.PrimaryExpression); ok && wb.references[ident] {
+				count++
+			}
+			return true
+		})
+	}
+	return count
+}
+
+// writes reports whether the state variable is assigned to inside the nodes
+func (wb *writeBack) writes(nodes ...ast.Node[ast.NodeType]) bool {
+	found := false
+	for _, node := range nodes {
+		walk(node, func(n ast.Node[ast.NodeType]) bool {
+			if ident, ok := n.(*ast.PrimaryExpression); ok && wb.written[ident] {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// canRewrite checks that every call that may touch storage can be surrounded by a write-back and a reload.
+// A statement that mixes such a call with the state variable is only accepted when the variable is read
+// in the arguments of the call, since those are evaluated before control leaves the function.
+func (wb *writeBack) canRewrite(statements []ast.Node[ast.NodeType]) bool {
+	for _, stmt := range statements {
+		calls := storageCalls(wb.tree, stmt)
+		if len(calls) == 0 || wb.countReferences(stmt) == 0 {
+			continue
+		}
+		switch stmt := stmt.(type) {
+		case *ast.IfStatement:
+			if hasElseBranch(stmt) || containsStorageCall(wb.tree, stmt.GetCondition()) {
+				return false
+			}
+			if !wb.canRewrite(statementsOf(stmt.GetBody())) {
+				return false
+			}
+		case *ast.BodyNode:
+			if !wb.canRewrite(stmt.GetStatements()) {
+				return false
+			}
+		default:
+			if isLoop(stmt) || len(calls) > 1 || wb.writes(stmt) {
+				return false
+			}
+			if wb.countReferences(stmt) != wb.countReferences(calls[0].GetArguments()...) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rewrite inserts the write-backs and reloads into the statements.
+// dirty tells whether the cached copy may hold a value that is not in storage yet when the statements start,
+// and the returned flag tells the same for when they end.
+func (wb *writeBack) rewrite(statements []ast.Node[ast.NodeType], dirty bool, topLevel bool) ([]ast.Node[ast.NodeType], bool) {
+	rewritten := make([]ast.Node[ast.NodeType], 0, len(statements))
+	for i, stmt := range statements {
+		hasCall := containsStorageCall(wb.tree, stmt)
+		references := wb.countReferences(stmt) > 0
+
+		switch s := stmt.(type) {
+		case *ast.IfStatement:
+			if references || containsReturn(s) {
+				body, ok := s.GetBody().(*ast.BodyNode)
+				if ok && (!hasCall || references) {
+					var bodyDirty bool
+					body.Statements, bodyDirty = wb.rewrite(body.GetStatements(), dirty, false)
+					// the branch may not be taken
+					dirty = dirty || bodyDirty
+					rewritten = append(rewritten, stmt)
+					continue
+				}
+			}
+		case *ast.BodyNode:
+			if references || containsReturn(s) {
+				if !hasCall || references {
+					s.Statements, dirty = wb.rewrite(s.GetStatements(), dirty, false)
+					rewritten = append(rewritten, stmt)
+					continue
+				}
+			}
+		}
+
+		if isLoop(stmt) && !hasCall && (references || containsReturn(stmt)) {
+			// a write in one iteration is still pending when the next iteration returns
+			loopDirty := dirty || wb.writes(stmt)
+			body := loopBody(stmt)
+			body.Statements, _ = wb.rewrite(body.GetStatements(), loopDirty, false)
+			rewritten = append(rewritten, stmt)
+			dirty = loopDirty
+			continue
+		}
+
+		if isRevert(stmt) {
+			rewritten = append(rewritten, stmt)
+			continue
+		}
+		if _, ok := stmt.(*ast.ReturnStatement); ok {
+			if dirty {
+				rewritten = append(rewritten, wb.store())
+			}
+			rewritten = append(rewritten, stmt)
+			continue
+		}
+		if hasCall {
+			if dirty {
+				rewritten = append(rewritten, wb.store())
+			}
+			rewritten = append(rewritten, stmt)
+			dirty = false
+			// no need to reload if nothing reads the cached copy afterwards
+			if !topLevel || wb.countReferences(statements[i+1:]...) > 0 {
+				rewritten = append(rewritten, wb.load())
+			}
+			continue
+		}
+		if wb.writes(stmt) {
+			dirty = true
+		}
+		rewritten = append(rewritten, stmt)
+	}
+	return rewritten, dirty
+}
+
+// loopBody returns the body of a for, while or do-while loop
+func loopBody(node ast.Node[ast.NodeType]) *ast.BodyNode {
+	switch node := node.(type) {
+	case *ast.ForStatement:
+		return node.GetBody()
+	case *ast.WhileStatement:
+		return node.GetBody()
+	case *ast.DoWhileStatement:
+		return node.GetBody()
+	}
+	return nil
+}
+
+// containsReturn reports whether there is a return statement inside the node
+func containsReturn(node ast.Node[ast.NodeType]) bool {
+	found := false
+	walk(node, func(n ast.Node[ast.NodeType]) bool {
+		if _, ok := n.(*ast.ReturnStatement); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// store creates `sv = cached_sv`
+func (wb *writeBack) store() ast.Node[ast.NodeType] {
+	return wb.assignment(newStateVariableIdentifier(wb.sv), wb.cachedIdentifier())
+}
+
+// load creates `cached_sv = sv`
+func (wb *writeBack) load() ast.Node[ast.NodeType] {
+	return wb.assignment(wb.cachedIdentifier(), newStateVariableIdentifier(wb.sv))
+}
+
+func (wb *writeBack) cachedIdentifier() *ast.PrimaryExpression {
+	ident := newLocalIdentifier(wb.cached, wb.sv)
+	ident.Id = nextID(wb.body)
+	return ident
+}
+
+func (wb *writeBack) assignment(left, right *ast.PrimaryExpression) *ast.Assignment {
+	return &ast.Assignment{
+		Id:              nextID(wb.body),
+		NodeType:        ast_pb.NodeType_ASSIGNMENT,
+		Operator:        ast_pb.Operator_EQUAL,
+		LeftExpression:  left,
+		RightExpression: right,
+		TypeDescription: wb.sv.GetTypeDescription(),
+	}
+}
@@ -0,0 +1,100 @@
+// test file for storage_write_back.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const writeBackContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+interface IFeed {
+    function price() external returns (uint256);
+}
+
+contract WriteBack {
+    uint256 public total;
+    IFeed public feed;
+
+    function add(uint256 amount) external returns (uint256) {
+        total += amount;
+        if (total > 100) {
+            return total;
+        }
+        feed.price();
+        total += amount;
+        return total;
+    }
+
+    function twice(uint256 amount) external {
+        total += amount;
+        total += amount;
+    }
+
+    function withAssembly(uint256 amount) external {
+        total += amount;
+        total += amount;
+        assembly {
+            sstore(0, 1)
+        }
+    }
+
+    function withTry(uint256 amount) external {
+        total += amount;
+        total += amount;
+        try feed.price() returns (uint256) {} catch {}
+    }
+
+    function withUnchecked(uint256 amount) external {
+        total += amount;
+        unchecked {
+            total += amount;
+        }
+    }
+
+    function withCall(uint256 amount) external {
+        total += amount;
+        total = feed.price() + total;
+    }
+}
+`
+
+func TestCacheStorageVariablesWriteBack(t *testing.T) {
+	builder := setUpBuilder(t, writeBackContract)
+	opt := optimizer.NewOptimizer(builder)
+	opt.CacheStorageVariables(optimizer.DefaultGasModel)
+
+	code, err := opt.Edits()[0].Apply()
+	assert.NoError(t, err)
+	// the cached value is stored back before returning and before the call, and read again after it
+	assert.Contains(t, code, `    function add(uint256 amount) external returns (uint256) {
+        uint256 cached_total = total;
+        cached_total += amount;
+        if (cached_total > 100) {
+            total = cached_total;
+            return cached_total;
+        }
+        total = cached_total;
+        feed.price();
+        cached_total = total;
+        cached_total += amount;
+        total = cached_total;
+        return cached_total;
+    }`)
+
+	assert.Contains(t, code, `    function twice(uint256 amount) external {
+        uint256 cached_total = total;
+        cached_total += amount;
+        cached_total += amount;
+        total = cached_total;
+    }`)
+	// the same writes are left alone next to statements the write-back can not reason about:
+	// assembly may touch storage, a try statement calls out in its header and unchecked blocks are moved
+	// by the parser, and the call mixed with total can not be surrounded by a write-back and a reload
+	for _, name := range []string{"withAssembly", "withTry", "withUnchecked", "withCall"} {
+		assert.Empty(t, localDeclarations(builder, "WriteBack", name), name)
+	}
+}
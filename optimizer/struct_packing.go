@@ -9,20 +9,31 @@ import (
 	"github.com/unpackdev/solgo/ir"
 )
 
-func (o *Optimizer) optimizeStructPacking() {
+func (o *Optimizer) optimizeStructPacking() []Change {
+	changes := make([]Change, 0)
 	contracts := o.builder.GetRoot().GetContracts()
 	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
 		// iterate through the contract's structs
 		structs := contract.GetStructs()
 		for _, s := range structs {
 			members := s.GetAST().GetMembers()
 			items := paramsToItems(members)
+			currentSlots := countSlots(items)
 			optimalSlots := binpack.OptimalBinPacking(items, SLOT_SIZE)
 
-			// re-arrange the members in the original struct
+			// re-arrange the members in the original struct, the first change saves the slots
+			rationale := fmt.Sprintf("reordered so the members of %s take %d storage slots instead of %d", s.GetName(), len(optimalSlots), currentSlots)
+			gasDelta := -(currentSlots - len(optimalSlots)) * sstoreSetCost
 			optimisedParams := make([]ast.Node[ast.NodeType], 0)
 			for _, slot := range optimalSlots {
 				for _, item := range slot {
+					if position := len(optimisedParams); item.Idx != position {
+						changes = append(changes, newChange(contract.GetName(), "", members[position].GetSrc(), members[item.Idx], rationale, gasDelta))
+						gasDelta = 0
+					}
 					optimisedParams = append(optimisedParams, members[item.Idx])
 				}
 			}
@@ -34,6 +45,7 @@ func (o *Optimizer) optimizeStructPacking() {
 		}
 		// update the contract with the optimised structs
 	}
+	return changes
 }
 
 func (o *Optimizer) printParams(params []*ir.Parameter) {
@@ -0,0 +1,223 @@
+// Moves the increment of a bounded for loop counter into an unchecked block at the end of the loop body
+package optimizer
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"go.uber.org/zap"
+)
+
+// unchecked blocks were added in 0.8.0, before that arithmetic is not checked anyway
+var uncheckedVersion = solidityVersion{major: 0, minor: 8, patch: 0}
+
+func (o *Optimizer) optimizeUncheckedLoopIncrements() []Change {
+	changes := make([]Change, 0)
+	contracts := o.builder.GetRoot().GetContracts()
+	for _, contract := range contracts {
+		if !o.rewritable(contract) {
+			continue
+		}
+		if !requiresSolidity(contract, uncheckedVersion) {
+			zap.L().Info("Skipping contract, its pragma allows compilers older than 0.8.0", zap.String("contract", contract.GetName()))
+			continue
+		}
+		for _, f := range contract.GetFunctions() {
+			fn := f.GetAST()
+			if fn.GetBody() == nil {
+				continue
+			}
+			walk(fn.GetBody(), func(node ast.Node[ast.NodeType]) bool {
+				if loop, ok := node.(*ast.ForStatement); ok {
+					src := loop.GetSrc()
+					if uncheckLoopIncrement(loop) {
+						changes = append(changes, newChange(contract.GetName(), fn.GetName(), src, loop,
+							"the counter stays below the bound of the loop, so its increment cannot overflow",
+							-checkedIncrementCost*DefaultGasModel.LoopIterations))
+					}
+				}
+				return true
+			})
+		}
+	}
+	return changes
+}
+
+// uncheckLoopIncrement turns `for (uint256 i = 0; i < n; i++) { ... }` into
+// `for (uint256 i = 0; i < n;) { ... unchecked { ++i; } }`.
+// The condition holds at the start of every iteration and nothing else writes to the counter,
+// so the counter is still below the bound at the end of the body and the increment cannot overflow.
+// Returns false if the loop is left untouched.
+func uncheckLoopIncrement(loop *ast.ForStatement) bool {
+	body := loop.GetBody()
+	if body == nil || hasUnsupportedStatement(body) || hasContinue(body) {
+		return false
+	}
+	counter, ok := loopCounter(loop)
+	if !ok || !isBoundedBy(loop.GetCondition(), counter) {
+		return false
+	}
+	operand, ok := incrementedIdentifier(loop.GetClosure())
+	if !ok || operand.GetName() != counter.GetName() {
+		return false
+	}
+	for ident := range writtenIdentifiers(body) {
+		if ident.GetName() == counter.GetName() {
+			return false
+		}
+	}
+
+	loop.Closure = nil
+	body.Statements = append(body.Statements, &ast.BodyNode{
+		ASTBuilder: body.ASTBuilder,
+		Id:         nextID(body),
+		NodeType:   ast_pb.NodeType_UNCHECKED_BLOCK,
+		Statements: []ast.Node[ast.NodeType]{
+			&ast.UnaryPrefix{
+				Id:              nextID(body),
+				NodeType:        ast_pb.NodeType_UNARY_OPERATION,
+				Operator:        ast_pb.Operator_INCREMENT,
+				Prefix:          true,
+				Expression:      operand,
+				TypeDescription: operand.GetTypeDescription(),
+			},
+		},
+	})
+	return true
+}
+
+// loopCounter returns the single variable declared in the initialiser of the loop
+func loopCounter(loop *ast.ForStatement) (*ast.Declaration, bool) {
+	init, ok := loop.GetInitialiser().(*ast.VariableDeclaration)
+	if !ok || len(init.GetDeclarations()) != 1 {
+		return nil, false
+	}
+	counter := init.GetDeclarations()[0]
+	if counter == nil || counter.GetTypeName() == nil {
+		return nil, false
+	}
+	if _, _, ok := integerType(counter.GetTypeName().GetTypeDescription()); !ok {
+		return nil, false
+	}
+	return counter, true
+}
+
+// isBoundedBy reports whether the condition is `counter < bound` or `bound > counter`,
+// with a bound that always fits in the type of the counter
+func isBoundedBy(condition ast.Node[ast.NodeType], counter *ast.Declaration) bool {
+	op, ok := condition.(*ast.BinaryOperation)
+	if !ok {
+		return false
+	}
+	var ident, bound ast.Node[ast.NodeType]
+	switch op.GetOperator() {
+	case ast_pb.Operator_LESS_THAN:
+		ident, bound = op.GetLeftExpression(), op.GetRightExpression()
+	case ast_pb.Operator_GREATER_THAN:
+		ident, bound = op.GetRightExpression(), op.GetLeftExpression()
+	default:
+		// `<=` would let the counter reach the bound and the increment overflow when the bound is the maximum
+		return false
+	}
+	if primary, ok := ident.(*ast.PrimaryExpression); !ok || primary.GetName() != counter.GetName() {
+		return false
+	}
+	return fitsIn(bound, counter.GetTypeName().GetTypeDescription())
+}
+
+// fitsIn reports whether every value of the expression fits in the integer type.
+// A bound of a wider type would let the counter wrap around instead of reverting.
+func fitsIn(bound ast.Node[ast.NodeType], counterType *ast.TypeDescription) bool {
+	signed, bits, _ := integerType(counterType)
+	switch bound := bound.(type) {
+	case *ast.PrimaryExpression:
+		if bound.GetKind() == ast_pb.NodeType_NUMBER {
+			value, ok := new(big.Int).SetString(strings.ReplaceAll(bound.GetValue(), "_", ""), 0)
+			if !ok {
+				return false
+			}
+			// a literal that does not fit would make the comparison happen in a wider type
+			limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+			if signed {
+				limit.Rsh(limit, 1)
+			}
+			return value.Cmp(limit) < 0
+		}
+	case *ast.MemberAccessExpression:
+		// the type of the member access is the type of the array, not of its length
+		if bound.GetMemberName() == "length" {
+			return !signed && bits == 256
+		}
+	case *ast.ExprOperation:
+		// the type of an exponentiation is not filled in
+		return false
+	}
+	boundSigned, boundBits, ok := integerType(bound.GetTypeDescription())
+	return ok && boundSigned == signed && boundBits <= bits
+}
+
+// integerType returns the signedness and size in bits of an integer type
+func integerType(typeDescription *ast.TypeDescription) (bool, int, bool) {
+	if typeDescription == nil {
+		return false, 0, false
+	}
+	name := typeDescription.GetString()
+	if !strings.HasPrefix(name, "int") && !strings.HasPrefix(name, "uint") {
+		return false, 0, false
+	}
+	signed := !strings.HasPrefix(name, "uint")
+	size := strings.TrimPrefix(strings.TrimPrefix(name, "u"), "int")
+	if size == "" {
+		return signed, 256, true
+	}
+	bits, err := strconv.Atoi(size)
+	if err != nil || bits <= 0 || bits > 256 || bits%8 != 0 {
+		return false, 0, false
+	}
+	return signed, bits, true
+}
+
+// incrementedIdentifier returns the operand of `i++` or `++i`
+func incrementedIdentifier(node ast.Node[ast.NodeType]) (*ast.PrimaryExpression, bool) {
+	var operand ast.Node[ast.NodeType]
+	switch node := node.(type) {
+	case *ast.UnarySuffix:
+		if node.GetOperator() != ast_pb.Operator_INCREMENT {
+			return nil, false
+		}
+		operand = node.GetExpression()
+	case *ast.UnaryPrefix:
+		if node.GetOperator() != ast_pb.Operator_INCREMENT || isDelete(node) {
+			return nil, false
+		}
+		operand = node.GetExpression()
+	default:
+		return nil, false
+	}
+	ident, ok := operand.(*ast.PrimaryExpression)
+	return ident, ok && ident.GetType() == ast_pb.NodeType_IDENTIFIER
+}
+
+// isDelete reports whether the prefix operation is a `delete`, which the parser reads as an increment.
+// They are told apart by the distance between the operator and the operand in the source.
+func isDelete(node *ast.UnaryPrefix) bool {
+	operand := node.GetExpression()
+	return operand != nil && operand.GetSrc().Start-node.GetSrc().Start >= int64(len("delete "))
+}
+
+// hasContinue reports whether a `continue` in the body jumps to the closure of the loop,
+// which would skip an increment at the end of the body
+func hasContinue(body *ast.BodyNode) bool {
+	found := false
+	walk(body, func(node ast.Node[ast.NodeType]) bool {
+		if node.GetType() == ast_pb.NodeType_CONTINUE {
+			found = true
+		}
+		// a continue in a nested loop belongs to that loop
+		return !found && !isLoop(node)
+	})
+	return found
+}
@@ -0,0 +1,137 @@
+// test file for unchecked_loop_increment.go
+package optimizer_test
+
+import (
+	"optimizer/optimizer/optimizer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
+)
+
+const loopContract = `// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Loops {
+    function sum(uint256[] memory a) public pure returns (uint256 total) {
+        for (uint256 i = 0; i < a.length; i++) {
+            total += a[i];
+        }
+    }
+
+    function prefix(uint256 n) public pure returns (uint256 total) {
+        for (uint256 i = 0; n > i; ++i) {
+            total += i;
+        }
+    }
+
+    function literal() public pure returns (uint256 total) {
+        for (uint8 i = 0; i < 200; i++) {
+            total += i;
+        }
+    }
+
+    function inclusive(uint256 n) public pure returns (uint256 total) {
+        for (uint256 i = 0; i <= n; i++) {
+            total += i;
+        }
+    }
+
+    function written(uint256 n) public pure returns (uint256 total) {
+        for (uint256 i = 0; i < n; i++) {
+            i += 1;
+            total += i;
+        }
+    }
+
+    function skipped(uint256 n) public pure returns (uint256 total) {
+        for (uint256 i = 0; i < n; i++) {
+            if (i == 2) {
+                continue;
+            }
+            total += i;
+        }
+    }
+
+    function narrow(uint256 n) public pure returns (uint256 total) {
+        for (uint8 i = 0; i < n; i++) {
+            total += i;
+        }
+    }
+}
+`
+
+const oldLoopContract = `// SPDX-License-Identifier: MIT
+pragma solidity >=0.7.0 <0.9.0;
+
+contract Loops {
+    function sum(uint256[] memory a) public pure returns (uint256 total) {
+        for (uint256 i = 0; i < a.length; i++) {
+            total += a[i];
+        }
+    }
+}
+`
+
+// finds the first for loop in the function
+func findForStatement(builder *ir.Builder, name string) *ast.ForStatement {
+	var loop *ast.ForStatement
+	for _, contract := range builder.GetRoot().GetContracts() {
+		for _, f := range contract.GetFunctions() {
+			if f.GetName() != name {
+				continue
+			}
+			for _, stmt := range f.GetAST().GetBody().GetStatements() {
+				if s, ok := stmt.(*ast.ForStatement); ok && loop == nil {
+					loop = s
+				}
+			}
+		}
+	}
+	return loop
+}
+
+// reports whether the loop ends with `unchecked { ++i; }` instead of incrementing in its header
+func isUnchecked(loop *ast.ForStatement) bool {
+	statements := loop.GetBody().GetStatements()
+	if loop.GetClosure() != nil || len(statements) == 0 {
+		return false
+	}
+	block, ok := statements[len(statements)-1].(*ast.BodyNode)
+	if !ok || block.GetType() != ast_pb.NodeType_UNCHECKED_BLOCK || len(block.GetStatements()) != 1 {
+		return false
+	}
+	increment, ok := block.GetStatements()[0].(*ast.UnaryPrefix)
+	if !ok || increment.GetOperator() != ast_pb.Operator_INCREMENT {
+		return false
+	}
+	counter, ok := increment.GetExpression().(*ast.PrimaryExpression)
+	return ok && counter.GetName() == "i"
+}
+
+func TestUncheckLoopIncrements(t *testing.T) {
+	builder := setUpBuilder(t, loopContract)
+	optimizer.NewOptimizer(builder).UncheckLoopIncrements()
+
+	assert.True(t, isUnchecked(findForStatement(builder, "sum")))
+	assert.True(t, isUnchecked(findForStatement(builder, "prefix")))
+	assert.True(t, isUnchecked(findForStatement(builder, "literal")))
+	// the counter may reach the maximum of its type
+	assert.False(t, isUnchecked(findForStatement(builder, "inclusive")))
+	assert.False(t, isUnchecked(findForStatement(builder, "written")))
+	// continue would skip the increment at the end of the body
+	assert.False(t, isUnchecked(findForStatement(builder, "skipped")))
+	// the bound does not fit in the counter
+	assert.False(t, isUnchecked(findForStatement(builder, "narrow")))
+}
+
+func TestUncheckLoopIncrementsOldPragma(t *testing.T) {
+	builder := setUpBuilder(t, oldLoopContract)
+	optimizer.NewOptimizer(builder).UncheckLoopIncrements()
+
+	loop := findForStatement(builder, "sum")
+	assert.NotNil(t, loop.GetClosure())
+	assert.False(t, isUnchecked(loop))
+}
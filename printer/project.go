@@ -0,0 +1,319 @@
+// Collects the files of a Solidity project together with the files they import
+package printer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/unpackdev/solgo"
+	"github.com/unpackdev/solgo/ir"
+	"go.uber.org/zap"
+)
+
+// Remapping replaces the Prefix of the import paths starting with it with Target, as in the remappings of solc.
+// A remapping with a Context only applies to the files whose path, relative to the project root, starts with it.
+type Remapping struct {
+	Context string
+	Prefix  string
+	Target  string
+}
+
+// Project is a set of Solidity files and the places the files they import are looked up in
+type Project struct {
+	// Root is the directory of the project. The optimizer only rewrites the files inside it that are not libraries.
+	Root string
+	// Files are the files to optimize
+	Files []string
+	// IncludePaths are the directories searched for imports that are neither relative nor found in Root
+	IncludePaths []string
+	Remappings   []Remapping
+}
+
+// projectMarkers are the files found at the root of Foundry and Hardhat projects
+var projectMarkers = []string{"remappings.txt", "foundry.toml", "hardhat.config.js", "hardhat.config.ts"}
+
+// libraryDirs are the directories Foundry and Hardhat install libraries into, searched for imports if they exist
+var libraryDirs = []string{"lib", "node_modules"}
+
+// importPattern matches the path of the import directives in all their forms:
+// `import "a.sol";`, `import "a.sol" as A;`, `import * as A from "a.sol";` and `import {A, B as C} from "a.sol";`
+var importPattern = regexp.MustCompile(`(?m)^\s*import\s+(?:[^;"']*?\s+from\s+)?["']([^"']+)["'][^;]*;`)
+
+// NewProject makes a project of files and of directories, which are searched for .sol files outside of their library
+// directories. If root is empty, it is the closest directory above the first path holding a remappings.txt,
+// foundry.toml or Hardhat config, or the directory of the first path if there is none.
+// The remappings are read from the remappings.txt of the root, and lib and node_modules are include paths.
+func NewProject(root string, paths ...string) (*Project, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no file to optimize")
+	}
+	files := make([]string, 0)
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := solidityFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .sol file in %s", strings.Join(paths, ", "))
+	}
+
+	if root == "" {
+		root = findRoot(files[0])
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	project := &Project{Root: root, Files: files, IncludePaths: make([]string, 0)}
+	for _, dir := range libraryDirs {
+		if info, err := os.Stat(filepath.Join(root, dir)); err == nil && info.IsDir() {
+			project.IncludePaths = append(project.IncludePaths, filepath.Join(root, dir))
+		}
+	}
+	if err := project.LoadRemappings(filepath.Join(root, "remappings.txt")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return project, nil
+}
+
+// solidityFiles returns the path if it is a file, and the .sol files below it if it is a directory
+func solidityFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	files := make([]string, 0)
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && file != path && (strings.HasPrefix(entry.Name(), ".") || isLibraryDir(entry.Name())) {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && strings.HasSuffix(file, ".sol") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func isLibraryDir(name string) bool {
+	for _, dir := range libraryDirs {
+		if name == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// findRoot returns the closest directory above the file holding one of the projectMarkers, or the directory of the file
+func findRoot(file string) string {
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		for _, marker := range projectMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return filepath.Dir(file)
+		}
+	}
+}
+
+// AddIncludePaths makes the directories, relative to the working directory unless they are absolute, include paths
+// searched before the ones found with the project
+func (p *Project) AddIncludePaths(dirs ...string) error {
+	includePaths := make([]string, 0, len(dirs)+len(p.IncludePaths))
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		includePaths = append(includePaths, dir)
+	}
+	p.IncludePaths = append(includePaths, p.IncludePaths...)
+	return nil
+}
+
+// LoadRemappings adds the remappings of the file, written one per line as [context:]prefix=target
+func (p *Project) LoadRemappings(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	remappings, err := ParseRemappings(string(content))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	p.Remappings = append(p.Remappings, remappings...)
+	return nil
+}
+
+// ParseRemappings reads remappings written one per line as [context:]prefix=target.
+// Empty lines and lines starting with # are skipped.
+func ParseRemappings(text string) ([]Remapping, error) {
+	remappings := make([]Remapping, 0)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		from, target, ok := strings.Cut(line, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("line %d: remapping %q is not [context:]prefix=target", i+1, line)
+		}
+		remapping := Remapping{Prefix: from, Target: target}
+		if context, prefix, ok := strings.Cut(from, ":"); ok {
+			remapping.Context, remapping.Prefix = context, prefix
+		}
+		remappings = append(remappings, remapping)
+	}
+	return remappings, nil
+}
+
+// Resolve returns the path of the file an import of the importing file refers to. Relative imports are found next to
+// the importing file. The other ones are remapped by the remapping with the longest prefix, then looked up in the root
+// and in the include paths.
+func (p *Project) Resolve(importing, path string) (string, error) {
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return existing(filepath.Join(filepath.Dir(importing), path), importing, path)
+	}
+	path = p.remap(importing, path)
+	if filepath.IsAbs(path) {
+		return existing(path, importing, path)
+	}
+	for _, dir := range append([]string{p.Root}, p.IncludePaths...) {
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			return filepath.Join(dir, path), nil
+		}
+	}
+	return "", fmt.Errorf("import %q of %s not found in %s", path, importing, strings.Join(append([]string{p.Root}, p.IncludePaths...), ", "))
+}
+
+func existing(file, importing, path string) (string, error) {
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("import %q of %s not found", path, importing)
+	}
+	return file, nil
+}
+
+// remap applies the remapping with the longest prefix, preferring the longest context among them
+func (p *Project) remap(importing, path string) string {
+	context := importing
+	if rel, err := filepath.Rel(p.Root, importing); err == nil {
+		context = filepath.ToSlash(rel)
+	}
+	var best *Remapping
+	for i, remapping := range p.Remappings {
+		if !strings.HasPrefix(path, remapping.Prefix) || !strings.HasPrefix(context, remapping.Context) {
+			continue
+		}
+		if best == nil || len(remapping.Prefix) > len(best.Prefix) ||
+			(len(remapping.Prefix) == len(best.Prefix) && len(remapping.Context) > len(best.Context)) {
+			best = &p.Remappings[i]
+		}
+	}
+	if best == nil {
+		return path
+	}
+	return best.Target + strings.TrimPrefix(path, best.Prefix)
+}
+
+// IsLibrary reports whether the file is outside the root or in a place libraries are imported from,
+// an include path or the target of a remapping
+func (p *Project) IsLibrary(file string) bool {
+	if !inside(p.Root, file) {
+		return true
+	}
+	for _, dir := range p.IncludePaths {
+		if inside(dir, file) {
+			return true
+		}
+	}
+	for _, remapping := range p.Remappings {
+		target := remapping.Target
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(p.Root, target)
+		}
+		// a remapping onto the project itself, such as src/=src/, does not make a library
+		if filepath.Clean(target) != p.Root && inside(target, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// inside reports whether the file is in the directory or below it
+func inside(dir, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Sources reads the files of the project and every file they import, directly or not,
+// each file coming after the ones it imports. Imports that can not be resolved are logged and skipped.
+func (p *Project) Sources() (*solgo.Sources, error) {
+	sources := &solgo.Sources{SourceUnits: make([]*solgo.SourceUnit, 0)}
+	names := make(map[string]bool, 0)
+	visited := make(map[string]bool, 0)
+	var visit func(file string) error
+	visit = func(file string) error {
+		if visited[file] {
+			return nil
+		}
+		visited[file] = true
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, match := range importPattern.FindAllStringSubmatch(string(content), -1) {
+			imported, err := p.Resolve(file, match[1])
+			if err != nil {
+				// the declarations of a missing file are only unresolved, as they were before imports were read
+				zap.L().Warn("Failed to resolve import, it is left out", zap.Error(err))
+				continue
+			}
+			if err := visit(imported); err != nil {
+				return err
+			}
+		}
+		// solgo tells the source units apart by name, so files sharing a name get a number
+		name := strings.TrimSuffix(filepath.Base(file), ".sol")
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s_%d", strings.TrimSuffix(filepath.Base(file), ".sol"), i)
+		}
+		names[name] = true
+		sources.SourceUnits = append(sources.SourceUnits, &solgo.SourceUnit{Name: name, Path: file, Content: string(content)})
+		return nil
+	}
+	for _, file := range p.Files {
+		if err := visit(file); err != nil {
+			return nil, err
+		}
+	}
+	zap.L().Info("Collected the source files of the project", zap.String("root", p.Root), zap.Int("files", len(sources.SourceUnits)))
+	return sources, nil
+}
+
+// GetProjectBuilder returns a builder of the files of the project and of the files they import
+func GetProjectBuilder(ctx context.Context, project *Project) (*ir.Builder, error) {
+	sources, err := project.Sources()
+	if err != nil {
+		return nil, err
+	}
+	return ir.NewBuilderFromSources(ctx, sources)
+}
@@ -0,0 +1,99 @@
+// test file for project.go
+package printer_test
+
+import (
+	"optimizer/optimizer/printer"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeProject writes the files under a temporary directory and returns it
+func writeProject(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestParseRemappings(t *testing.T) {
+	remappings, err := printer.ParseRemappings("# comment\n@oz/=lib/openzeppelin/contracts/\n\nsrc:ds/=lib/ds-test/src/\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []printer.Remapping{
+		{Prefix: "@oz/", Target: "lib/openzeppelin/contracts/"},
+		{Context: "src", Prefix: "ds/", Target: "lib/ds-test/src/"},
+	}, remappings)
+
+	_, err = printer.ParseRemappings("@oz/ lib/oz/")
+	assert.Error(t, err)
+}
+
+func TestProjectSources(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"foundry.toml":       "",
+		"remappings.txt":     "@oz/=lib/oz/\n",
+		"src/Token.sol":      "import \"./Base.sol\";\nimport {IERC} from \"@oz/IERC.sol\";\ncontract Token is Base {}\n",
+		"src/Base.sol":       "import * as Math from \"utils/Math.sol\";\ncontract Base {}\n",
+		"src/utils/A.sol":    "contract A {}\n",
+		"lib/oz/IERC.sol":    "interface IERC {}\n",
+		"lib/utils/Math.sol": "library Math {}\n",
+	})
+
+	project, err := printer.NewProject("", filepath.Join(dir, "src", "Token.sol"))
+	assert.NoError(t, err)
+	// the root is found from the file, and lib is an include path
+	assert.Equal(t, dir, project.Root)
+	assert.Equal(t, []string{filepath.Join(dir, "lib")}, project.IncludePaths)
+
+	sources, err := project.Sources()
+	assert.NoError(t, err)
+	paths := make([]string, 0)
+	for _, unit := range sources.SourceUnits {
+		rel, _ := filepath.Rel(dir, unit.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	// every file comes after the ones it imports
+	assert.Equal(t, []string{"lib/utils/Math.sol", "src/Base.sol", "lib/oz/IERC.sol", "src/Token.sol"}, paths)
+
+	assert.False(t, project.IsLibrary(filepath.Join(dir, "src", "Base.sol")))
+	assert.True(t, project.IsLibrary(filepath.Join(dir, "lib", "oz", "IERC.sol")))
+	assert.True(t, project.IsLibrary(filepath.Join(filepath.Dir(dir), "Other.sol")))
+
+	// a directory stands for the files below it, without its libraries
+	project, err = printer.NewProject(dir, dir)
+	assert.NoError(t, err)
+	assert.Len(t, project.Files, 3)
+
+	_, err = project.Resolve(filepath.Join(dir, "src", "Token.sol"), "@oz/Missing.sol")
+	assert.Error(t, err)
+}
+
+func TestProjectIncludePaths(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"foundry.toml":    "",
+		"src/Token.sol":   "import \"Math.sol\";\ncontract Token {}\n",
+		"lib/placeholder": "",
+	})
+	shared := writeProject(t, map[string]string{"Math.sol": "library Math {}\n"})
+
+	project, err := printer.NewProject("", filepath.Join(dir, "src", "Token.sol"))
+	assert.NoError(t, err)
+	// an absolute directory is kept as it is and comes before the libraries of the project
+	assert.NoError(t, project.AddIncludePaths(shared))
+	assert.Equal(t, []string{shared, filepath.Join(dir, "lib")}, project.IncludePaths)
+	path, err := project.Resolve(filepath.Join(dir, "src", "Token.sol"), "Math.sol")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(shared, "Math.sol"), path)
+	assert.True(t, project.IsLibrary(path))
+
+	// a relative one is found from the working directory
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, project.AddIncludePaths("testdata"))
+	assert.Equal(t, filepath.Join(cwd, "testdata"), project.IncludePaths[0])
+}
@@ -3,7 +3,6 @@ package printer
 import (
 	"context"
 	"fmt"
-	"os"
 	"regexp"
 
 	"github.com/unpackdev/solgo"
@@ -11,22 +10,15 @@ import (
 	"go.uber.org/zap"
 )
 
-// getDetector returns a detector instance for the given file path.
-
-func GetBuilder(ctx context.Context, filePath string) (*ir.Builder, error) {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		zap.L().Error("File does not exist", zap.Error(err))
+// GetBuilder returns a builder of the files, or of the .sol files of the directories, and of every file they import.
+// The project is found from the first path, as NewProject does.
+func GetBuilder(ctx context.Context, paths ...string) (*ir.Builder, error) {
+	project, err := NewProject("", paths...)
+	if err != nil {
+		zap.L().Error("Failed to collect the source files", zap.Error(err))
 		return nil, err
 	}
-	sources := &solgo.Sources{
-		SourceUnits: []*solgo.SourceUnit{
-			{
-				Name: "NotOptimized",
-				Path: filePath,
-			},
-		},
-	}
-	return ir.NewBuilderFromSources(ctx, sources)
+	return GetProjectBuilder(ctx, project)
 }
 
 func GetBuilderCode(ctx context.Context, code string) (*ir.Builder, error) {
@@ -2,6 +2,7 @@ package testing
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"optimizer/optimizer/optimizer"
 	"optimizer/optimizer/printer"
@@ -9,19 +10,29 @@ import (
 	"path/filepath"
 	"testing"
 
-	"github.com/unpackdev/solgo/printer/ast_printer"
+	"github.com/stretchr/testify/assert"
+	ast_pb "github.com/unpackdev/protos/dist/go/ast"
+	"github.com/unpackdev/solgo/ast"
+	"github.com/unpackdev/solgo/ir"
 )
 
 const TEST_DIR = "./testdata"
 
+// EXPECTED_DIR holds the optimized text of the test files, regenerated with `go test ./tests -update`
+const EXPECTED_DIR = "./testdata/expected"
+
+var update = flag.Bool("update", false, "Write the optimized output of the tests to the expected files")
+
 type Options struct {
 	filepath    string
 	printOutput bool
 
-	calldata             bool
-	structpack           bool
-	storagevarcache      bool
+	// optimization level and names of the passes to run on top of it
+	level                int
+	passes               []string
 	optimizationExpected bool
+	// expected is the file in EXPECTED_DIR holding the optimized text, the name of the test file if empty
+	expected string
 }
 
 func testHelper(options Options) bool {
@@ -60,28 +71,28 @@ func testHelper(options Options) bool {
 		fmt.Println("Error: ", errs)
 		return false
 	}
-	root := ast.GetRoot()
-	unoptimised, ok := ast_printer.Print(root.GetSourceUnits()[0])
-	if !ok {
-		fmt.Println("Error: ", "Failed to print unoptimised code")
-		return false
-	}
-
 	opt := optimizer.NewOptimizer(builder)
 	// Run the optimiser
-	if options.structpack {
-		opt.PackStructs()
-	}
-	if options.calldata {
-		opt.OptimizeCallData()
+	passes, err := optimizer.PipelinePasses(options.level, options.passes)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return false
 	}
-	if options.storagevarcache {
-		opt.CacheStorageVariables()
+	if _, err := opt.RunPipeline(passes, optimizer.LevelOptions(options.level), 0); err != nil {
+		fmt.Println("Error: ", err)
+		return false
 	}
 
-	optimised, ok := ast_printer.Print(root.GetSourceUnits()[0])
-	if !ok {
-		fmt.Println("Error: ", "Failed to print optimised code")
+	// the text written out for the file, which is what the CLI and the backend return
+	files := opt.Edits()
+	if len(files) != 1 {
+		fmt.Println("Error: ", "Expected the edits of one file, got", len(files))
+		return false
+	}
+	unoptimised := files[0].Original
+	optimised, err := files[0].Apply()
+	if err != nil {
+		fmt.Println("Error: ", err)
 		return false
 	}
 
@@ -94,20 +105,38 @@ func testHelper(options Options) bool {
 		fmt.Println(optimised)
 		fmt.Println("================================")
 	}
-	switch options.optimizationExpected {
-	case true:
-		if unoptimised == optimised {
-			fmt.Println("Error: ", "Code not optimised")
-			return false
-		}
-		return true
-	case false:
+	if !options.optimizationExpected {
 		if unoptimised != optimised {
 			fmt.Println("Error: ", "Code should not be optimised")
 			return false
 		}
 		return true
 	}
+	if unoptimised == optimised {
+		fmt.Println("Error: ", "Code not optimised")
+		return false
+	}
+	expectedFile := options.expected
+	if expectedFile == "" {
+		expectedFile = options.filepath
+	}
+	expectedFile = filepath.Join(EXPECTED_DIR, expectedFile)
+	if *update {
+		if err := os.WriteFile(expectedFile, []byte(optimised), 0644); err != nil {
+			fmt.Println("Error: ", err)
+			return false
+		}
+	}
+	expected, err := os.ReadFile(expectedFile)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return false
+	}
+	if string(expected) != optimised {
+		fmt.Println("Error: ", "Optimised code differs from", expectedFile)
+		fmt.Println(optimised)
+		return false
+	}
 	return true
 }
 
@@ -115,10 +144,20 @@ func TestOptimiser(t *testing.T) {
 	verbose := false
 	optimizationExpected := true
 	tests := []Options{
-		{filepath: "struct_packing.sol", printOutput: verbose, calldata: false, structpack: true, storagevarcache: false, optimizationExpected: optimizationExpected},
-		{filepath: "storage_variable_caching.sol", printOutput: verbose, calldata: false, structpack: false, storagevarcache: true, optimizationExpected: optimizationExpected},
-		{filepath: "calldata.sol", printOutput: verbose, calldata: true, structpack: false, storagevarcache: false, optimizationExpected: optimizationExpected},
-		{filepath: "OptimizationShowcase.sol", printOutput: verbose, calldata: true, structpack: true, storagevarcache: true, optimizationExpected: optimizationExpected},
+		{filepath: "struct_packing.sol", printOutput: verbose, passes: []string{"pack-structs"}, optimizationExpected: optimizationExpected},
+		{filepath: "storage_variable_caching.sol", printOutput: verbose, passes: []string{"cache-storage-variables"}, optimizationExpected: optimizationExpected},
+		{filepath: "storage_write_back.sol", printOutput: verbose, passes: []string{"cache-storage-variables"}, optimizationExpected: optimizationExpected},
+		{filepath: "calldata.sol", printOutput: verbose, passes: []string{"optimize-call-data"}, optimizationExpected: optimizationExpected},
+		{filepath: "state_variable_packing.sol", printOutput: verbose, passes: []string{"pack-state-variables"}, optimizationExpected: optimizationExpected},
+		{filepath: "loop_accumulator.sol", printOutput: verbose, passes: []string{"hoist-loop-accumulators"}, optimizationExpected: optimizationExpected},
+		{filepath: "external_functions.sol", printOutput: verbose, passes: []string{"promote-external-functions"}, optimizationExpected: optimizationExpected},
+		{filepath: "prefix_increment.sol", printOutput: verbose, passes: []string{"prefix-increments"}, optimizationExpected: optimizationExpected},
+		{filepath: "dead_code.sol", printOutput: verbose, passes: []string{"eliminate-dead-code"}, optimizationExpected: optimizationExpected},
+		{filepath: "condition_reordering.sol", printOutput: verbose, passes: []string{"reorder-conditions"}, optimizationExpected: optimizationExpected},
+		{filepath: "custom_errors.sol", printOutput: verbose, passes: []string{"custom-errors"}, optimizationExpected: optimizationExpected},
+		{filepath: "array_length_caching.sol", printOutput: verbose, passes: []string{"cache-array-lengths"}, optimizationExpected: optimizationExpected},
+		{filepath: "OptimizationShowcase.sol", printOutput: verbose, passes: []string{"pack-structs", "optimize-call-data", "cache-storage-variables"}, optimizationExpected: optimizationExpected, expected: "OptimizationShowcase.passes.sol"},
+		{filepath: "OptimizationShowcase.sol", printOutput: verbose, level: 3, optimizationExpected: optimizationExpected, expected: "OptimizationShowcase.O3.sol"},
 	}
 	for _, test := range tests {
 		if testHelper(test) {
@@ -132,9 +171,11 @@ func TestOptimiser(t *testing.T) {
 func TestOptimiserEdgeCase(t *testing.T) {
 	verbose := false
 	optimizationExpected := false
+	edgeCasePasses := []string{"eliminate-dead-code", "pack-structs", "pack-state-variables", "optimize-call-data", "hoist-loop-accumulators", "reorder-conditions", "prefix-increments", "custom-errors", "cache-storage-variables", "cache-array-lengths"}
 	tests := []Options{
-		{filepath: "Counter.sol", printOutput: verbose, calldata: true, structpack: true, storagevarcache: true, optimizationExpected: optimizationExpected}, // No optimisations needed
-		{filepath: "Empty.sol", printOutput: verbose, calldata: true, structpack: true, storagevarcache: true, optimizationExpected: optimizationExpected},   // Empty Contract
+		{filepath: "Counter.sol", printOutput: verbose, passes: edgeCasePasses, optimizationExpected: optimizationExpected}, // No optimisations needed
+		{filepath: "Empty.sol", printOutput: verbose, passes: edgeCasePasses, optimizationExpected: optimizationExpected},   // Empty Contract
+		{filepath: "MultipleContracts.sol", printOutput: verbose, level: 0, optimizationExpected: optimizationExpected},     // -O0 runs nothing
 	}
 
 	for _, test := range tests {
@@ -145,3 +186,43 @@ func TestOptimiserEdgeCase(t *testing.T) {
 		}
 	}
 }
+
+// storageLayout returns the type and name of the state variables that take storage slots, in declaration order
+func storageLayout(builder *ir.Builder) []string {
+	layout := make([]string, 0)
+	for _, contract := range builder.GetRoot().GetContracts() {
+		for _, node := range contract.GetAST().GetContract().GetNodes() {
+			sv, ok := node.(*ast.StateVariableDeclaration)
+			if !ok || sv.IsConstant() || sv.GetStateMutability() == ast_pb.Mutability_IMMUTABLE {
+				continue
+			}
+			layout = append(layout, contract.GetName()+"."+sv.GetTypeName().GetName()+" "+sv.GetName())
+		}
+	}
+	return layout
+}
+
+func TestOptimiserStorageLayout(t *testing.T) {
+	for level := 1; level < len(optimizer.Levels); level++ {
+		builder, err := printer.GetBuilder(context.Background(), filepath.Join(TEST_DIR, "storage_layout.sol"))
+		assert.NoError(t, err)
+		assert.Empty(t, builder.Parse())
+		assert.NoError(t, builder.Build())
+		assert.Empty(t, builder.GetAstBuilder().ResolveReferences())
+		before := storageLayout(builder)
+
+		opt := optimizer.NewOptimizer(builder)
+		passes, err := optimizer.PipelinePasses(level, nil)
+		assert.NoError(t, err)
+		_, err = opt.RunPipeline(passes, optimizer.LevelOptions(level), 0)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, opt.Result().Changes)
+
+		// every state variable keeps its slot below -O3, which is free to remove, promote and reorder them
+		if level < 3 {
+			assert.Equal(t, before, storageLayout(builder), "O%d", level)
+		} else {
+			assert.NotEqual(t, before, storageLayout(builder), "O%d", level)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.20;
+
+contract Airdrop {
+    address[] public recipients;
+    mapping(address => uint256) public balances;
+
+    function addRecipient(address recipient) public {
+        recipients.push(recipient);
+    }
+
+    function distribute(uint256 amount) public {
+        for (uint256 i = 0; i < recipients.length; i++) {
+            balances[recipients[i]] += amount;
+        }
+    }
+
+    function total(uint256[] memory amounts) public pure returns (uint256 sum) {
+        for (uint256 i = 0; i < amounts.length; i++) {
+            sum += amounts[i];
+        }
+    }
+}
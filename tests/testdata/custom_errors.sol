@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Vault {
+    address public owner;
+    uint256 public balance;
+
+    constructor() {
+        owner = msg.sender;
+    }
+
+    function deposit(uint256 amount) external {
+        require(amount > 0, "Amount must be positive");
+        balance += amount;
+    }
+
+    function withdraw(uint256 amount) external {
+        require(msg.sender == owner, "Only owner");
+        require(amount > 0, "Amount must be positive");
+        if (amount > balance) {
+            revert("Insufficient balance");
+        }
+        balance -= amount;
+    }
+}
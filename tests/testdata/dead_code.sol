@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Registry {
+    struct Entry {
+        address owner;
+        uint256 value;
+    }
+
+    struct Legacy {
+        uint256 id;
+    }
+
+    event Registered(address owner, uint256 value);
+    event Removed(address owner);
+
+    mapping(address => Entry) public entries;
+    uint256 private counter;
+    uint256 private legacyCounter;
+
+    function register(uint256 value) external returns (uint256) {
+        entries[msg.sender] = Entry(msg.sender, value);
+        emit Registered(msg.sender, value);
+        return next();
+        counter = 0;
+    }
+
+    function next() internal returns (uint256) {
+        counter += 1;
+        return counter;
+    }
+
+    function legacyNext() private returns (uint256) {
+        legacyCounter += 1;
+        return legacyCounter;
+    }
+}
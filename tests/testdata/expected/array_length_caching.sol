@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.20;
+
+contract Airdrop {
+    address[] public recipients;
+    mapping(address => uint256) public balances;
+
+    function addRecipient(address recipient) public {
+        recipients.push(recipient);
+    }
+
+    function distribute(uint256 amount) public {
+        uint256 cached_recipients_length = recipients.length;
+        for (uint256 i = 0; i < cached_recipients_length; i++) {
+            balances[recipients[i]] += amount;
+        }
+    }
+
+    function total(uint256[] memory amounts) public pure returns (uint256 sum) {
+        uint256 cached_amounts_length = amounts.length;
+        for (uint256 i = 0; i < cached_amounts_length; i++) {
+            sum += amounts[i];
+        }
+    }
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Allowance {
+    mapping(address => uint256) public balances;
+    mapping(address => bool) public blocked;
+    address public owner;
+
+    function transfer(address to, uint256 amount) external {
+        require(amount > 0 && to != address(0) && balances[msg.sender] >= amount, "invalid transfer");
+        balances[msg.sender] -= amount;
+        balances[to] += amount;
+    }
+
+    function canWithdraw(address account, bool force) external view returns (bool) {
+        return force || account == owner || blocked[account];
+    }
+}
@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Vault {
+    address public owner;
+    uint256 public balance;
+    error AmountMustBePositive();
+    error OnlyOwner();
+    error InsufficientBalance();
+
+    constructor() {
+        owner = msg.sender;
+    }
+
+    function deposit(uint256 amount) external {
+        if (!(amount > 0)) {
+            revert AmountMustBePositive();
+        }
+        balance += amount;
+    }
+
+    function withdraw(uint256 amount) external {
+        if (!(msg.sender == owner)) {
+            revert OnlyOwner();
+        }
+        if (!(amount > 0)) {
+            revert AmountMustBePositive();
+        }
+        if (amount > balance) {
+            revert InsufficientBalance();
+        }
+        balance -= amount;
+    }
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract Registry {
+    struct Entry {
+        address owner;
+        uint256 value;
+    }
+
+    event Registered(address owner, uint256 value);
+
+    mapping(address => Entry) public entries;
+    uint256 private counter;
+
+    function register(uint256 value) external returns (uint256) {
+        entries[msg.sender] = Entry(msg.sender, value);
+        emit Registered(msg.sender, value);
+        return next();
+    }
+
+    function next() internal returns (uint256) {
+        counter += 1;
+        return counter;
+    }
+}
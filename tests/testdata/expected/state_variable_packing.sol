@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.0;
+
+contract NotOptimizedStorage {
+    uint256 public totalSupply; // slot 1
+    uint256 public cap;         // slot 3
+    address public owner;       // slot 4
+    uint8 public decimals;      // slot 0
+    bool public paused;         // slot 2
+    uint256 public constant MAX_SUPPLY = 1000; // no storage
+    address public immutable deployer;         // no storage
+
+    constructor() {
+        deployer = msg.sender;
+    }
+}
@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.20;
+
+contract Counter1 {
+    uint256 public number;
+    int256[] public arr;
+
+    function increment() public view returns (uint256) {
+        uint256 cached_number = number;
+        require(cached_number < 10);
+        uint256 incremented = cached_number + 1;
+        return incremented;
+    }
+
+    function sum() public view returns (int256) {
+        int256 sum = 0;
+        uint256 cached_arr_length = arr.length;
+        for (uint256 i = 0; i < cached_arr_length; i++) {
+            sum += arr[i];
+        }
+        return sum;
+    }
+
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract NotOptimizedWriteBack {
+    uint256 public total;
+    uint256 public deposits;
+    address public owner;
+
+    function deposit(uint256 amount) external returns (uint256) {
+        uint256 cached_deposits = deposits;
+        uint256 cached_total = total;
+        require(cached_total + amount > cached_total, "overflow");
+        cached_total = cached_total + amount;
+        cached_deposits += 1;
+        if (amount > 100) {
+            total = cached_total;
+            deposits = cached_deposits;
+            return cached_total;
+        }
+        total = cached_total;
+        deposits = cached_deposits;
+        payable(owner).transfer(cached_total);
+        cached_deposits = deposits;
+        cached_total = total;
+        cached_total = cached_total - cached_deposits;
+        total = cached_total;
+        return cached_total;
+    }
+
+    function reset() external {
+        uint256 cached_total = total;
+        require(msg.sender == owner, "not owner");
+        cached_total = 0;
+        deposits = 0;
+        total = cached_total;
+        payable(owner).transfer(cached_total);
+        cached_total = total;
+        deposits = cached_total + 1;
+    }
+}
@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract NotOptimizedExternalFunctions {
+    uint256 public total;
+
+    function sum(uint256[] memory _array) public pure returns (uint256 result) {
+        for (uint256 i = 0; i < _array.length; ++i) {
+            result += _array[i];
+        }
+    }
+
+    function add(uint256 amount) public {
+        total = double(amount);
+    }
+
+    function double(uint256 amount) public pure returns (uint256) {
+        return amount * 2;
+    }
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract NotOptimizedLoopAccumulator {
+    uint256 public sumOfArray;
+    uint256 public processed;
+    address public owner;
+
+    function inefficientSum(uint256[] memory _array) public {
+        for (uint256 i; i < _array.length; i++) {
+            sumOfArray += _array[i];
+            processed++;
+        }
+    }
+
+    function sumWithTransfer(uint256[] memory _array) public {
+        for (uint256 i; i < _array.length; i++) {
+            sumOfArray += _array[i];
+            payable(owner).transfer(_array[i]);
+        }
+    }
+
+    function sumUntil(uint256[] memory _array, uint256 limit) public returns (uint256) {
+        for (uint256 i; i < _array.length; i++) {
+            if (sumOfArray > limit) {
+                return sumOfArray;
+            }
+            sumOfArray += _array[i];
+        }
+        return sumOfArray;
+    }
+}
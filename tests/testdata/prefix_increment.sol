@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract NotOptimizedPrefixIncrement {
+    uint256 public count;
+    uint256 public last;
+
+    function countEven(uint256[] memory _array) public returns (uint256 even) {
+        for (uint256 i = 0; i < _array.length; i++) {
+            if (_array[i] % 2 == 0) {
+                even++;
+            }
+        }
+        count--;
+    }
+
+    function next() public returns (uint256) {
+        last = count++;
+        return last;
+    }
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+// an implementation behind a proxy, whose state variables must keep their slots
+contract Vault {
+    uint256 private legacy;
+    uint256 public fee = 3;
+    address public admin;
+    uint8 public version;
+    address public owner;
+    uint256 public balance;
+
+    constructor() {
+        admin = msg.sender;
+    }
+
+    function deposit() external payable {
+        require(msg.sender == owner || msg.sender == admin, "not allowed");
+        balance += msg.value - fee;
+        version++;
+    }
+
+    function withdraw(uint256 amount) external {
+        require(msg.sender == owner, "not owner");
+        balance -= amount;
+        payable(owner).transfer(amount);
+    }
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.4;
+
+contract NotOptimizedWriteBack {
+    uint256 public total;
+    uint256 public deposits;
+    address public owner;
+
+    function deposit(uint256 amount) external returns (uint256) {
+        require(total + amount > total, "overflow");
+        total = total + amount;
+        deposits += 1;
+        if (amount > 100) {
+            return total;
+        }
+        payable(owner).transfer(total);
+        total = total - deposits;
+        return total;
+    }
+
+    function reset() external {
+        require(msg.sender == owner, "not owner");
+        total = 0;
+        deposits = 0;
+        payable(owner).transfer(total);
+        deposits = total + 1;
+    }
+}